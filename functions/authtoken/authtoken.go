@@ -0,0 +1,263 @@
+// Package authtoken issues and verifies HMAC-SHA256 signed JSON Web Tokens
+// used to authenticate jockey requests.
+package authtoken
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const DefaultTTL = time.Hour * 24 * 30
+
+// DefaultMaxAge is how long after signing in a session can be refreshed
+const DefaultMaxAge = time.Hour * 24 * 90
+
+var (
+	ErrMalformed  = errors.New("Malformed token")
+	ErrUnknownKey = errors.New("Token signed with unknown key")
+	ErrSignature  = errors.New("Invalid token signature")
+	ErrExpired    = errors.New("Token expired")
+	// ErrSessionExpired is returned when refreshing a token whose session is
+	// older than the Signer's max age, so the user must sign in again
+	ErrSessionExpired = errors.New("Session expired")
+)
+
+// Claims are the verified contents of a token.
+type Claims struct {
+	Subject   string `json:"sub"`
+	Admin     bool   `json:"admin,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	// AuthTime is when the subject signed in, which refreshed tokens keep
+	AuthTime int64 `json:"auth_time,omitempty"`
+}
+
+// Key is a signing secret identified by Id, which is stored in the token
+// header so the key can be found again when verifying.
+type Key struct {
+	Id     string
+	Secret []byte
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyId     string `json:"kid"`
+}
+
+// Signer issues tokens with its first key and verifies tokens signed by any of
+// its keys. Rotating keys is done by putting a new key first and keeping the
+// old ones around until the tokens they signed have expired.
+type Signer struct {
+	keys []Key
+	ttl  time.Duration
+	// MaxAge is how long after signing in tokens can be refreshed, and when
+	// refreshed tokens expire at the latest
+	MaxAge time.Duration
+	now    func() time.Time
+}
+
+func NewSigner(ttl time.Duration, keys ...Key) (*Signer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("At least one signing key is required")
+	}
+	for _, key := range keys {
+		if key.Id == "" || len(key.Secret) == 0 {
+			return nil, errors.New("Signing keys must have an id and secret")
+		}
+	}
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	return &Signer{
+		keys:   keys,
+		ttl:    ttl,
+		MaxAge: DefaultMaxAge,
+		now:    time.Now,
+	}, nil
+}
+
+// ParseKeys parses keys formatted as "id1:secret1,id2:secret2".
+func ParseKeys(spec string) ([]Key, error) {
+	var keys []Key
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Invalid signing key %q, expected id:secret", parts[0])
+		}
+		keys = append(keys, Key{Id: parts[0], Secret: []byte(parts[1])})
+	}
+
+	return keys, nil
+}
+
+// FromEnv creates a Signer from JOCKEY_SIGNING_KEYS (see ParseKeys) and the
+// optional JOCKEY_TOKEN_TTL and JOCKEY_SESSION_MAX_AGE durations.
+func FromEnv() (*Signer, error) {
+	keys, err := ParseKeys(os.Getenv("JOCKEY_SIGNING_KEYS"))
+	if err != nil {
+		return nil, err
+	}
+
+	var ttl time.Duration
+	if ttlEnv := os.Getenv("JOCKEY_TOKEN_TTL"); ttlEnv != "" {
+		ttl, err = time.ParseDuration(ttlEnv)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid JOCKEY_TOKEN_TTL: %v", err.Error())
+		}
+	}
+
+	signer, err := NewSigner(ttl, keys...)
+	if err != nil {
+		return nil, err
+	}
+	if maxAgeEnv := os.Getenv("JOCKEY_SESSION_MAX_AGE"); maxAgeEnv != "" {
+		signer.MaxAge, err = time.ParseDuration(maxAgeEnv)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid JOCKEY_SESSION_MAX_AGE: %v", err.Error())
+		}
+	}
+	return signer, nil
+}
+
+// Issue creates a token for a subject who just signed in, signed with the
+// current key.
+func (s *Signer) Issue(subject string, admin bool) (string, Claims, error) {
+	return s.issue(subject, admin, s.now())
+}
+
+func (s *Signer) issue(subject string, admin bool, authTime time.Time) (string, Claims, error) {
+	if subject == "" {
+		return "", Claims{}, errors.New("Token subject is required")
+	}
+
+	now := s.now()
+	expiresAt := now.Add(s.ttl)
+	if sessionEnd := authTime.Add(s.MaxAge); s.MaxAge > 0 && sessionEnd.Before(expiresAt) {
+		expiresAt = sessionEnd
+	}
+	claims := Claims{
+		Subject:   subject,
+		Admin:     admin,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+		AuthTime:  authTime.Unix(),
+	}
+
+	key := s.keys[0]
+	headerBytes, err := json.Marshal(header{Algorithm: "HS256", Type: "JWT", KeyId: key.Id})
+	if err != nil {
+		return "", Claims{}, err
+	}
+	claimsBytes, err := json.Marshal(claims)
+	if err != nil {
+		return "", Claims{}, err
+	}
+
+	signingInput := encode(headerBytes) + "." + encode(claimsBytes)
+	return signingInput + "." + encode(sign(key.Secret, signingInput)), claims, nil
+}
+
+// Verify checks the token's signature and expiry, returning its claims.
+func (s *Signer) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrMalformed
+	}
+
+	var h header
+	if err := decodeJSON(parts[0], &h); err != nil {
+		return Claims{}, ErrMalformed
+	}
+	if h.Algorithm != "HS256" {
+		return Claims{}, ErrMalformed
+	}
+
+	key, ok := s.key(h.KeyId)
+	if !ok {
+		return Claims{}, ErrUnknownKey
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, ErrMalformed
+	}
+	if !hmac.Equal(signature, sign(key.Secret, parts[0]+"."+parts[1])) {
+		return Claims{}, ErrSignature
+	}
+
+	var claims Claims
+	if err := decodeJSON(parts[1], &claims); err != nil {
+		return Claims{}, ErrMalformed
+	}
+	if claims.Subject == "" {
+		return Claims{}, ErrMalformed
+	}
+	if s.now().Unix() >= claims.ExpiresAt {
+		return Claims{}, ErrExpired
+	}
+
+	return claims, nil
+}
+
+// Refresh verifies the token and issues a new one for the same subject, signed
+// with the current key and with a new expiry. isAdmin decides the new token's
+// Admin claim, so it follows changes to who is an admin. Tokens can't be
+// refreshed past the session's MaxAge.
+func (s *Signer) Refresh(token string, isAdmin func(subject string) bool) (string, Claims, error) {
+	claims, err := s.Verify(token)
+	if err != nil {
+		return "", Claims{}, err
+	}
+
+	// tokens from before AuthTime was added started their session when issued
+	authTime := claims.AuthTime
+	if authTime == 0 {
+		authTime = claims.IssuedAt
+	}
+	signedIn := time.Unix(authTime, 0)
+	if s.MaxAge > 0 && !s.now().Before(signedIn.Add(s.MaxAge)) {
+		return "", Claims{}, ErrSessionExpired
+	}
+
+	return s.issue(claims.Subject, isAdmin(claims.Subject), signedIn)
+}
+
+func (s *Signer) key(id string) (Key, bool) {
+	for _, key := range s.keys {
+		if key.Id == id {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+func sign(secret []byte, signingInput string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeJSON(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,139 @@
+package authtoken
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSigner(t *testing.T, keys ...Key) *Signer {
+	signer, err := NewSigner(time.Hour, keys...)
+	require.Nil(t, err)
+	return signer
+}
+
+func TestIssueAndVerify(t *testing.T) {
+	signer := newTestSigner(t, Key{Id: "a", Secret: []byte("secret")})
+	token, issued, err := signer.Issue("alice@devetry.com", true)
+	require.Nil(t, err)
+
+	claims, err := signer.Verify(token)
+	require.Nil(t, err)
+	require.Equal(t, issued, claims)
+	require.Equal(t, "alice@devetry.com", claims.Subject)
+	require.True(t, claims.Admin)
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	signer := newTestSigner(t, Key{Id: "a", Secret: []byte("secret")})
+	token, _, err := signer.Issue("alice@devetry.com", false)
+	require.Nil(t, err)
+
+	// swap in claims for another user but keep the original signature
+	forger := newTestSigner(t, Key{Id: "a", Secret: []byte("guess")})
+	forged, _, err := forger.Issue("bob@devetry.com", true)
+	require.Nil(t, err)
+	tokenParts := strings.Split(token, ".")
+	forgedParts := strings.Split(forged, ".")
+	_, err = signer.Verify(tokenParts[0] + "." + forgedParts[1] + "." + tokenParts[2])
+	require.Equal(t, ErrSignature, err)
+	_, err = signer.Verify(forged)
+	require.Equal(t, ErrSignature, err)
+
+	_, err = signer.Verify("bob@devetry.com")
+	require.Equal(t, ErrMalformed, err)
+}
+
+func TestVerifyExpired(t *testing.T) {
+	signer := newTestSigner(t, Key{Id: "a", Secret: []byte("secret")})
+	token, _, err := signer.Issue("alice@devetry.com", false)
+	require.Nil(t, err)
+
+	signer.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	_, err = signer.Verify(token)
+	require.Equal(t, ErrExpired, err)
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey := Key{Id: "old", Secret: []byte("old secret")}
+	newKey := Key{Id: "new", Secret: []byte("new secret")}
+	oldSigner := newTestSigner(t, oldKey)
+	oldToken, _, err := oldSigner.Issue("alice@devetry.com", false)
+	require.Nil(t, err)
+
+	// the rotated signer still accepts old tokens and refreshes them with the new key
+	rotated := newTestSigner(t, newKey, oldKey)
+	_, err = rotated.Verify(oldToken)
+	require.Nil(t, err)
+	newToken, claims, err := rotated.Refresh(oldToken, func(string) bool { return false })
+	require.Nil(t, err)
+	require.Equal(t, "alice@devetry.com", claims.Subject)
+	_, err = oldSigner.Verify(newToken)
+	require.Equal(t, ErrUnknownKey, err)
+
+	// once the old key is retired its tokens are rejected
+	retired := newTestSigner(t, newKey)
+	_, err = retired.Verify(oldToken)
+	require.Equal(t, ErrUnknownKey, err)
+	_, err = retired.Verify(newToken)
+	require.Nil(t, err)
+}
+
+func TestRefresh(t *testing.T) {
+	signer := newTestSigner(t, Key{Id: "a", Secret: []byte("secret")})
+	signer.MaxAge = 90 * time.Minute
+	signedIn := time.Now()
+	signer.now = func() time.Time { return signedIn }
+	token, issued, err := signer.Issue("alice@devetry.com", true)
+	require.Nil(t, err)
+	require.Equal(t, signedIn.Unix(), issued.AuthTime)
+
+	// refreshed tokens keep when the session started and get a new admin claim
+	signer.now = func() time.Time { return signedIn.Add(50 * time.Minute) }
+	token, claims, err := signer.Refresh(token, func(subject string) bool { return false })
+	require.Nil(t, err)
+	require.False(t, claims.Admin)
+	require.Equal(t, signedIn.Unix(), claims.AuthTime)
+	// but don't outlive the session
+	require.Equal(t, signedIn.Add(90*time.Minute).Unix(), claims.ExpiresAt)
+
+	signer.now = func() time.Time { return signedIn.Add(90 * time.Minute) }
+	_, err = signer.Verify(token)
+	require.Equal(t, ErrExpired, err)
+	_, _, err = signer.Refresh(token, func(subject string) bool { return false })
+	require.Equal(t, ErrExpired, err)
+}
+
+func TestRefreshSessionExpired(t *testing.T) {
+	signer := newTestSigner(t, Key{Id: "a", Secret: []byte("secret")})
+	signedIn := time.Now()
+	signer.now = func() time.Time { return signedIn.Add(-50 * time.Minute) }
+	token, _, err := signer.Issue("alice@devetry.com", false)
+	require.Nil(t, err)
+
+	// after the max age is lowered the token is still valid, but its session
+	// is too old to extend
+	signer.MaxAge = 30 * time.Minute
+	signer.now = func() time.Time { return signedIn }
+	_, err = signer.Verify(token)
+	require.Nil(t, err)
+	_, _, err = signer.Refresh(token, func(subject string) bool { return false })
+	require.Equal(t, ErrSessionExpired, err)
+}
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys("new:s3cret, old:with:colon")
+	require.Nil(t, err)
+	require.Equal(t, []Key{
+		{Id: "new", Secret: []byte("s3cret")},
+		{Id: "old", Secret: []byte("with:colon")},
+	}, keys)
+
+	_, err = ParseKeys("missingsecret")
+	require.NotNil(t, err)
+
+	_, err = NewSigner(time.Hour)
+	require.NotNil(t, err)
+}
@@ -0,0 +1,351 @@
+package bouncer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// FieldError is a problem with one field of a request. Field is empty for
+// problems with the whole body.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// BindError is returned by Bind when a request doesn't fit its struct. It
+// lists every problem, so it should be answered with a 400.
+type BindError struct {
+	Errors []FieldError
+}
+
+func (e *BindError) Error() string {
+	var messages []string
+	for _, fieldErr := range e.Errors {
+		messages = append(messages, fieldErr.Message)
+	}
+	return "Invalid request: " + strings.Join(messages, "; ")
+}
+
+// Bind fills v, a pointer to a struct, from the request and validates it.
+//
+// A JSON body is decoded into v first, after decoding it from base64 if
+// IsBase64Encoded is set. Then fields tagged `path:"name"` are set from the
+// route parameter and fields tagged `query:"name"` from the query parameter,
+// where string slices get every value of the query parameter. These fields
+// can be strings, bools or numbers.
+//
+// Finally each field's `validate` tag is checked. It is a comma separated
+// list of rules:
+//
+//	required    the field must not be empty or zero
+//	min=n       strings must have at least n characters, slices n items
+//	            and numbers a value of at least n
+//	max=n       like min, for at most n
+//	url         strings must be absolute http or https URLs
+//	oneof=a b   strings, or every string in a slice, must be one of the
+//	            space separated values
+//
+// Apart from required, rules ignore empty fields. If the request doesn't fit,
+// the error is a *BindError. Any other error means v or its tags are invalid.
+func Bind(req events.APIGatewayProxyRequest, parameters map[string]string, v interface{}) error {
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Ptr || target.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("can't bind to %T, it must be a pointer to a struct", v)
+	}
+
+	if fieldErr := decodeBody(req, v); fieldErr != nil {
+		return &BindError{Errors: []FieldError{*fieldErr}}
+	}
+
+	var fieldErrors []FieldError
+	value := target.Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		fieldErr, err := bindField(req, parameters, field, value.Field(i))
+		if err != nil {
+			return err
+		}
+		if fieldErr == nil {
+			fieldErr, err = validateField(field, value.Field(i))
+			if err != nil {
+				return err
+			}
+		}
+		if fieldErr != nil {
+			fieldErrors = append(fieldErrors, *fieldErr)
+		}
+	}
+
+	if len(fieldErrors) > 0 {
+		return &BindError{Errors: fieldErrors}
+	}
+	return nil
+}
+
+// Bind binds the request into v, as the package's Bind does
+func (c *Context) Bind(v interface{}) error {
+	return Bind(c.Request, c.Parameters, v)
+}
+
+func decodeBody(req events.APIGatewayProxyRequest, v interface{}) *FieldError {
+	body := req.Body
+	if req.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return &FieldError{Message: "Body isn't valid base64"}
+		}
+		body = string(decoded)
+	}
+	if strings.TrimSpace(body) == "" {
+		return nil
+	}
+
+	err := json.Unmarshal([]byte(body), v)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		name := typeErr.Field
+		if name == "" {
+			name = "Body"
+		}
+		return &FieldError{
+			Field:   typeErr.Field,
+			Message: fmt.Sprintf("%v must be %v", name, describeType(typeErr.Type)),
+		}
+	}
+	if err != nil {
+		return &FieldError{Message: "Body isn't valid JSON"}
+	}
+	return nil
+}
+
+// fieldName is how errors refer to the field: by its parameter name, its
+// JSON name or else its Go name
+func fieldName(field reflect.StructField) string {
+	for _, tag := range []string{"path", "query"} {
+		if name := field.Tag.Get(tag); name != "" {
+			return name
+		}
+	}
+	if name := strings.Split(field.Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+		return name
+	}
+	return field.Name
+}
+
+// bindField sets the field from its path or query parameter, if it has one
+func bindField(req events.APIGatewayProxyRequest, parameters map[string]string, field reflect.StructField, value reflect.Value) (*FieldError, error) {
+	var values []string
+	if name := field.Tag.Get("path"); name != "" {
+		if parameter, ok := parameters[name]; ok {
+			values = []string{parameter}
+		}
+	} else if name := field.Tag.Get("query"); name != "" {
+		values = req.MultiValueQueryStringParameters[name]
+		if parameter, ok := req.QueryStringParameters[name]; ok && len(values) == 0 {
+			values = []string{parameter}
+		}
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	if value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.String {
+		value.Set(reflect.ValueOf(values))
+		return nil, nil
+	}
+
+	// like API Gateway, a single value parameter gets the last value
+	text := values[len(values)-1]
+	var err error
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(text)
+	case reflect.Bool:
+		var parsed bool
+		parsed, err = strconv.ParseBool(text)
+		value.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var parsed int64
+		parsed, err = strconv.ParseInt(text, 10, value.Type().Bits())
+		value.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var parsed uint64
+		parsed, err = strconv.ParseUint(text, 10, value.Type().Bits())
+		value.SetUint(parsed)
+	case reflect.Float32, reflect.Float64:
+		var parsed float64
+		parsed, err = strconv.ParseFloat(text, value.Type().Bits())
+		value.SetFloat(parsed)
+	default:
+		return nil, fmt.Errorf("can't bind parameter to field %v of type %v", field.Name, field.Type)
+	}
+	if err != nil {
+		name := fieldName(field)
+		return &FieldError{Field: name, Message: fmt.Sprintf("%v must be %v", name, describeType(field.Type))}, nil
+	}
+	return nil, nil
+}
+
+func describeType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a whole number"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "a list"
+	default:
+		return "an object"
+	}
+}
+
+// validateField checks the field's validate rules, returning the first one it
+// breaks
+func validateField(field reflect.StructField, value reflect.Value) (*FieldError, error) {
+	tag := field.Tag.Get("validate")
+	if tag == "" {
+		return nil, nil
+	}
+
+	name := fieldName(field)
+	empty := value.IsZero()
+	switch value.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		empty = value.Len() == 0
+	}
+
+	for _, rule := range strings.Split(tag, ",") {
+		ruleName, arg := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			ruleName, arg = rule[:i], rule[i+1:]
+		}
+
+		if ruleName == "required" {
+			if empty {
+				return &FieldError{Field: name, Message: name + " is required"}, nil
+			}
+			continue
+		}
+		if empty {
+			continue
+		}
+
+		message, err := checkRule(ruleName, arg, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid validate tag %q on field %v: %v", tag, field.Name, err)
+		}
+		if message != "" {
+			return &FieldError{Field: name, Message: name + " " + message}, nil
+		}
+	}
+	return nil, nil
+}
+
+// checkRule returns how the value breaks the rule, or "" if it doesn't
+func checkRule(rule, arg string, value reflect.Value) (string, error) {
+	switch rule {
+	case "min", "max":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return "", fmt.Errorf("%v needs a number", rule)
+		}
+		return checkLimit(rule, limit, arg, value)
+
+	case "url":
+		if value.Kind() != reflect.String {
+			return "", errors.New("url only applies to strings")
+		}
+		parsed, err := url.Parse(value.String())
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return "must be an http or https URL", nil
+		}
+		return "", nil
+
+	case "oneof":
+		options := strings.Fields(arg)
+		if len(options) == 0 {
+			return "", errors.New("oneof needs values")
+		}
+		var values []string
+		switch {
+		case value.Kind() == reflect.String:
+			values = []string{value.String()}
+		case value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.String:
+			for i := 0; i < value.Len(); i++ {
+				values = append(values, value.Index(i).String())
+			}
+		default:
+			return "", errors.New("oneof only applies to strings")
+		}
+		for _, v := range values {
+			if !contains(options, v) {
+				return "must be one of " + strings.Join(options, ", "), nil
+			}
+		}
+		return "", nil
+
+	default:
+		return "", fmt.Errorf("unknown rule %q", rule)
+	}
+}
+
+func checkLimit(rule string, limit float64, arg string, value reflect.Value) (string, error) {
+	var size float64
+	var unit string
+	switch value.Kind() {
+	case reflect.String:
+		size, unit = float64(utf8.RuneCountInString(value.String())), " characters"
+	case reflect.Slice, reflect.Map:
+		size, unit = float64(value.Len()), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		size = float64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		size = float64(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		size = value.Float()
+	default:
+		return "", fmt.Errorf("%v doesn't apply to %v", rule, value.Type())
+	}
+
+	if rule == "min" && size < limit {
+		if unit == " items" {
+			return "must have at least " + arg + unit, nil
+		}
+		return "must be at least " + arg + unit, nil
+	}
+	if rule == "max" && size > limit {
+		if unit == " items" {
+			return "must have at most " + arg + unit, nil
+		}
+		return "must be at most " + arg + unit, nil
+	}
+	return "", nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package bouncer
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+type bookRequest struct {
+	AuthorId string   `path:"authorId" json:"-"`
+	Page     int      `query:"page" json:"-" validate:"min=1,max=100"`
+	Tags     []string `query:"tag" json:"-" validate:"oneof=new used"`
+	Title    string   `json:"title" validate:"required,max=10"`
+	Link     string   `validate:"url"`
+	Format   string   `validate:"oneof=paperback hardcover"`
+	Authors  []string `validate:"required,max=2"`
+}
+
+func bindRequest(body string, query map[string][]string) events.APIGatewayProxyRequest {
+	req := events.APIGatewayProxyRequest{
+		Body:                            body,
+		QueryStringParameters:           map[string]string{},
+		MultiValueQueryStringParameters: query,
+	}
+	for name, values := range query {
+		req.QueryStringParameters[name] = values[len(values)-1]
+	}
+	return req
+}
+
+func TestBind(t *testing.T) {
+	req := bindRequest(
+		`{"title": "Dune", "Link": "https://example.com/dune", "Format": "paperback", "Authors": ["Frank Herbert"]}`,
+		map[string][]string{"page": {"2"}, "tag": {"new", "used"}},
+	)
+
+	var book bookRequest
+	err := Bind(req, map[string]string{"authorId": "herbert"}, &book)
+	require.Nil(t, err)
+	require.Equal(t, bookRequest{
+		AuthorId: "herbert",
+		Page:     2,
+		Tags:     []string{"new", "used"},
+		Title:    "Dune",
+		Link:     "https://example.com/dune",
+		Format:   "paperback",
+		Authors:  []string{"Frank Herbert"},
+	}, book)
+}
+
+func TestBindBase64Body(t *testing.T) {
+	req := bindRequest(base64.StdEncoding.EncodeToString([]byte(`{"title": "Dune", "Authors": ["Frank Herbert"]}`)), nil)
+	req.IsBase64Encoded = true
+
+	var book bookRequest
+	require.Nil(t, Bind(req, nil, &book))
+	require.Equal(t, "Dune", book.Title)
+
+	req.Body = "not base64!"
+	err := Bind(req, nil, &book)
+	require.Equal(t, &BindError{Errors: []FieldError{{Message: "Body isn't valid base64"}}}, err)
+}
+
+func TestBindListsFieldErrors(t *testing.T) {
+	req := bindRequest(
+		`{"title": "The Left Hand of Darkness", "Link": "ftp://example.com", "Format": "scroll", "Authors": ["a", "b", "c"]}`,
+		map[string][]string{"page": {"-1"}, "tag": {"new", "signed"}},
+	)
+
+	var book bookRequest
+	err := Bind(req, nil, &book)
+	var bindErr *BindError
+	require.True(t, errors.As(err, &bindErr))
+	require.Equal(t, []FieldError{
+		{Field: "page", Message: "page must be at least 1"},
+		{Field: "tag", Message: "tag must be one of new, used"},
+		{Field: "title", Message: "title must be at most 10 characters"},
+		{Field: "Link", Message: "Link must be an http or https URL"},
+		{Field: "Format", Message: "Format must be one of paperback, hardcover"},
+		{Field: "Authors", Message: "Authors must have at most 2 items"},
+	}, bindErr.Errors)
+
+	book = bookRequest{}
+	err = Bind(bindRequest("", map[string][]string{"page": {"two"}}), nil, &book)
+	require.Equal(t, &BindError{Errors: []FieldError{
+		{Field: "page", Message: "page must be a whole number"},
+		{Field: "title", Message: "title is required"},
+		{Field: "Authors", Message: "Authors is required"},
+	}}, err)
+}
+
+func TestBindRejectsBadBodies(t *testing.T) {
+	var book bookRequest
+	err := Bind(bindRequest(`{"title": `, nil), nil, &book)
+	require.Equal(t, &BindError{Errors: []FieldError{{Message: "Body isn't valid JSON"}}}, err)
+
+	err = Bind(bindRequest(`{"title": 12}`, nil), nil, &book)
+	require.Equal(t, &BindError{Errors: []FieldError{{Field: "title", Message: "title must be a string"}}}, err)
+
+	err = Bind(bindRequest(`[]`, nil), nil, &book)
+	require.Equal(t, &BindError{Errors: []FieldError{{Message: "Body must be an object"}}}, err)
+}
+
+func TestBindRejectsBadTargets(t *testing.T) {
+	var book bookRequest
+	err := Bind(bindRequest("", nil), nil, book)
+	require.NotNil(t, err)
+	require.False(t, errors.As(err, new(*BindError)))
+
+	var badTag struct {
+		Name string `validate:"shiny"`
+	}
+	err = Bind(bindRequest(`{"Name": "x"}`, nil), nil, &badTag)
+	require.NotNil(t, err)
+	require.False(t, errors.As(err, new(*BindError)))
+}
+
+func TestContextBindErrorResponse(t *testing.T) {
+	b := New("")
+	b.HandleContext(Post, "/authors/{authorId}/books", func(c *Context) (*events.APIGatewayProxyResponse, error) {
+		var book bookRequest
+		if err := c.Bind(&book); err != nil {
+			return nil, err
+		}
+		return &events.APIGatewayProxyResponse{Body: book.AuthorId + " wrote " + book.Title}, nil
+	})
+
+	res, err := b.Route(events.APIGatewayProxyRequest{
+		HTTPMethod: Post,
+		Path:       "/authors/herbert/books",
+		Body:       `{"title": "Dune", "Authors": ["Frank Herbert"]}`,
+	})
+	require.Nil(t, err)
+	require.Equal(t, "herbert wrote Dune", res.Body)
+
+	res, err = b.Route(events.APIGatewayProxyRequest{HTTPMethod: Post, Path: "/authors/herbert/books", Body: `{}`})
+	require.Nil(t, err)
+	require.Equal(t, 400, res.StatusCode)
+	require.Equal(t, "Invalid request: title is required; Authors is required", res.Body)
+}
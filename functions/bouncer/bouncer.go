@@ -1,7 +1,34 @@
+// Package bouncer routes API Gateway proxy requests to handlers by method and
+// path pattern.
+//
+// Patterns are split on "/" into segments. Besides static segments, a segment
+// can be:
+//
+//	{name}        a parameter matching any non-empty segment
+//	{name:type}   a parameter constrained by a named type (int, date or uuid)
+//	{name:regexp} a parameter constrained by a regular expression, which must
+//	              match the whole segment
+//	{name...}     a catch-all matching the rest of the path, which must be the
+//	              last segment
+//
+// A parameter in the last segment can be made optional with a "?" after its
+// name (e.g. {page?} or {page?:int}), in which case the route also matches
+// without it.
+//
+// When a path could match more than one route, each segment prefers static
+// segments, then constrained parameters, then plain parameters and finally
+// catch-alls. If the preferred route doesn't match the rest of the path, or
+// has no handler for the request's method, the next is tried.
+//
+// Routes are served by a Handler, which gets a *Context and can fail with an
+// error, or by an older ApiHandler registered with Handle. Bind fills and
+// validates typed request structs from a request's body and parameters.
 package bouncer
 
 import (
+	"context"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
@@ -11,111 +38,212 @@ type Method string
 
 const (
 	Get     = "GET"
+	Head    = "HEAD"
 	Post    = "POST"
+	Put     = "PUT"
+	Patch   = "PATCH"
+	Delete  = "DELETE"
 	Options = "OPTIONS"
 )
 
+// RouteError is returned by Route when no handler could serve the request.
+// The response returned alongside it is still suitable for the client, and
+// comes from the Bouncer's ErrorResponse given the RouteError.
+type RouteError struct {
+	StatusCode int
+	Method     string
+	Path       string
+	// Allow lists the methods the path does allow for a 405
+	Allow []string
+}
+
+func (e *RouteError) Error() string {
+	if e.StatusCode == 405 {
+		return fmt.Sprintf("Method %v not allowed for %v", e.Method, e.Path)
+	}
+	return fmt.Sprintf("No resource at %v %v", e.Method, e.Path)
+}
+
 type ApiHandler func(map[string]string, events.APIGatewayProxyRequest) *events.APIGatewayProxyResponse
 
-type handlerNode struct {
-	handler       *ApiHandler
-	subnodes      map[string]handlerNode
-	parameterName string
+// Middleware wraps an ApiHandler. Middlewares run in the order they are
+// declared: global middlewares (Use) first, then those of each enclosing
+// Group from outermost to innermost, then those passed to Handle.
+type Middleware func(ApiHandler) ApiHandler
+
+// chain wraps handler so that middlewares[0] is the outermost.
+func chain(handler ApiHandler, middlewares []Middleware) ApiHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
 }
 
 type Bouncer struct {
-	BasePath     string
-	getHandlers  handlerNode
-	postHandlers handlerNode
+	BasePath string
+	// ErrorResponse turns errors from Handlers into responses. It defaults to
+	// DefaultErrorResponse.
+	ErrorResponse ErrorMapper
+	// PrincipalFrom sets Context.Principal from the parameters the
+	// middlewares pass on. Without it requests are anonymous.
+	PrincipalFrom func(parameters map[string]string) Principal
+
+	handlers    *node
+	middlewares []Middleware
+	err         *PatternError
 }
 
 func New(basePath string) *Bouncer {
 	return &Bouncer{
 		BasePath: basePath,
-		getHandlers: handlerNode{
-			subnodes: make(map[string]handlerNode),
-		},
-		postHandlers: handlerNode{
-			subnodes: make(map[string]handlerNode),
-		},
+		handlers: newNode(),
 	}
 }
 
-func (h *handlerNode) update(path []string, handler ApiHandler) {
-	if len(path) == 0 {
-		h.handler = &handler
-		return
+// allowed lists the methods the node can serve. GET handlers also serve HEAD.
+func (n *node) allowed() []string {
+	var methods []string
+	for method := range n.handlers {
+		methods = append(methods, string(method))
 	}
-
-	thisPart := path[0]
-	// if it's a pattern, store the name then continue
-	if strings.HasPrefix(thisPart, "{") && strings.HasSuffix(thisPart, "}") {
-		// FIXME: should be a better way to do this
-		h.parameterName = strings.Replace(strings.Replace(thisPart, "{", "", -1), "}", "", -1)
-		h.update(path[1:], handler)
-		return
+	if _, ok := n.handlers[Get]; ok {
+		if _, ok := n.handlers[Head]; !ok {
+			methods = append(methods, Head)
+		}
 	}
+	sort.Strings(methods)
+	return methods
+}
 
-	if subnode, ok := h.subnodes[thisPart]; ok {
-		subnode.update(path[1:], handler)
-	} else {
-		newSubnode := handlerNode{
-			subnodes: make(map[string]handlerNode),
+// route returns the route for the method, falling back to the GET route for
+// HEAD requests.
+func (n *node) route(method Method) (r route, found bool, headFallback bool) {
+	if r, ok := n.handlers[method]; ok {
+		return r, true, false
+	}
+	if method == Head {
+		if r, ok := n.handlers[Get]; ok {
+			return r, true, true
 		}
-		newSubnode.update(path[1:], handler)
-		h.subnodes[thisPart] = newSubnode
 	}
+	return route{}, false, false
+}
+
+// Use adds global middlewares. They wrap every request, including those that
+// end in a 404 or 405 response, regardless of when routes were registered.
+func (b *Bouncer) Use(middlewares ...Middleware) {
+	b.middlewares = append(b.middlewares, middlewares...)
+}
+
+// PatternError is returned by Handle when a route can't be registered
+// because its pattern is invalid, ambiguous with a registered route or
+// registered already.
+type PatternError struct {
+	Method  string
+	Pattern string
+	Reason  string
+}
+
+func (e *PatternError) Error() string {
+	return fmt.Sprintf("Can't register %v %v: %v", e.Method, e.Pattern, e.Reason)
 }
 
-func (h *handlerNode) get(path string) (*ApiHandler, map[string]string) {
-	pathElements := strings.Split(path, "/")
-	parameters := make(map[string]string)
-	currNode := h
-	for currNode != nil && len(pathElements) > 0 {
-		if nextNode, ok := currNode.subnodes[pathElements[0]]; ok {
-			currNode = &nextNode
-		} else if currNode.parameterName != "" {
-			parameters[currNode.parameterName] = pathElements[0]
+// Handle registers the ApiHandler for the method and pattern, as HandleContext
+// does for a Handler.
+func (b *Bouncer) Handle(method Method, pattern string, handler ApiHandler, middlewares ...Middleware) error {
+	return b.HandleContext(method, pattern, Adapt(handler), middlewares...)
+}
+
+// HandleContext registers the handler for the method and pattern, which is
+// appended to the base path. See the package documentation for pattern
+// syntax. Any middlewares given only apply to this route. If the route can't
+// be registered a *PatternError is returned and the routes are unchanged.
+//
+// The first error is also kept for Err, so a table of routes can be checked
+// once after registering them all.
+func (b *Bouncer) HandleContext(method Method, pattern string, handler Handler, middlewares ...Middleware) error {
+	method = Method(strings.ToUpper(string(method)))
+	segments, err := parsePattern(b.BasePath + pattern)
+	if err == nil {
+		err = b.handlers.conflict(segments, method)
+	}
+	if err != nil {
+		patternErr := &PatternError{Method: string(method), Pattern: pattern, Reason: err.Error()}
+		if b.err == nil {
+			b.err = patternErr
 		}
-		pathElements = pathElements[1:]
+		return patternErr
 	}
 
-	if len(pathElements) == 0 {
-		return currNode.handler, parameters
+	b.handlers.add(segments, method, route{handler: handler, middlewares: middlewares})
+	return nil
+}
+
+// Err returns the first error from registering a route, if any
+func (b *Bouncer) Err() error {
+	if b.err == nil {
+		return nil
 	}
-	return nil, nil
-}
-
-func (b *Bouncer) Handle(method Method, pattern string, handler ApiHandler) {
-	// FIXME: for now just prepend the path with the base path
-	path := strings.Split(b.BasePath+pattern, "/")
-	switch method {
-	case Get:
-		b.getHandlers.update(path, handler)
-	case Post:
-		b.postHandlers.update(path, handler)
-	default:
-		b.getHandlers.update(path, handler)
+	return b.err
+}
+
+// Group returns a Group for registering routes under the prefix.
+func (b *Bouncer) Group(prefix string, middlewares ...Middleware) *Group {
+	return &Group{
+		bouncer:     b,
+		prefix:      prefix,
+		middlewares: middlewares,
 	}
 }
 
+// Route calls the handler registered for the request, wrapped in the global
+// middlewares. If the path is unknown a 404 response is returned, and if the
+// path is known but the method is not a 405 response with an Allow header is
+// returned. In both cases the error is a *RouteError. An empty HTTPMethod is
+// treated as GET.
 func (b *Bouncer) Route(req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
-	var handler *ApiHandler
-	var parameters map[string]string
-	switch req.HTTPMethod {
-	case Get:
-		handler, parameters = b.getHandlers.get(req.Path)
-	case Post:
-		handler, parameters = b.postHandlers.get(req.Path)
-	default:
-		handler, parameters = b.getHandlers.get(req.Path)
+	return b.RouteContext(context.Background(), req)
+}
+
+// RouteContext is Route for a request with a deadline or cancellation, which
+// handlers get through their Context.
+func (b *Bouncer) RouteContext(ctx context.Context, req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
+	method := Method(strings.ToUpper(req.HTTPMethod))
+	if method == "" {
+		method = Get
 	}
-	if handler == nil {
-		return &events.APIGatewayProxyResponse{
-			StatusCode: 404,
-			Body:       fmt.Sprintf("No resource at %v %v", req.HTTPMethod, req.Path),
-		}, nil
+
+	r, parameters, headFallback, routeErr := b.resolve(method, req.Path)
+	handler := b.serve(&Context{Context: ctx}, r.handler)
+	response := chain(chain(handler, r.middlewares), b.middlewares)(parameters, req)
+	if headFallback && response != nil {
+		response.Body = ""
+	}
+	if routeErr != nil {
+		return response, routeErr
+	}
+	return response, nil
+}
+
+// resolve finds the route for the request. When there is none, the returned
+// route fails with routeErr, which describes why.
+func (b *Bouncer) resolve(method Method, path string) (r route, parameters map[string]string, headFallback bool, routeErr *RouteError) {
+	node, parameters := b.handlers.get(method, path)
+	if node == nil {
+		routeErr = &RouteError{StatusCode: 404, Method: string(method), Path: path}
+		if allow := b.handlers.allowedAt(path); len(allow) > 0 {
+			routeErr.StatusCode = 405
+			routeErr.Allow = allow
+		}
+		return failRoute(routeErr), make(map[string]string), false, routeErr
 	}
 
-	return (*handler)(parameters, req), nil
+	r, _, headFallback = node.route(method)
+	return r, parameters, headFallback, nil
+}
+
+func failRoute(err *RouteError) route {
+	return route{handler: func(*Context) (*events.APIGatewayProxyResponse, error) {
+		return nil, err
+	}}
 }
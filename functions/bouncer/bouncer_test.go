@@ -112,3 +112,216 @@ func TestBouncerSimpleRestUseCase(t *testing.T) {
 	require.Equal(t, "map[authorId:123 bookId:666 pageNumber:41]", res.Body)
 
 }
+
+func TestBouncerMethods(t *testing.T) {
+	b := New("")
+	b.Handle(Get, "/books", handlerA)
+	b.Handle(Put, "/books", handlerB)
+	b.Handle(Delete, "/books/{bookId}", paramPrinter)
+
+	res, err := b.Route(events.APIGatewayProxyRequest{HTTPMethod: Get, Path: "/books"})
+	require.Nil(t, err)
+	require.Equal(t, "hello from A", res.Body)
+
+	res, err = b.Route(events.APIGatewayProxyRequest{HTTPMethod: Put, Path: "/books"})
+	require.Nil(t, err)
+	require.Equal(t, "hello from B", res.Body)
+
+	res, err = b.Route(events.APIGatewayProxyRequest{HTTPMethod: Delete, Path: "/books/666"})
+	require.Nil(t, err)
+	require.Equal(t, "map[bookId:666]", res.Body)
+}
+
+func TestBouncerHeadUsesGetHandler(t *testing.T) {
+	b := New("")
+	b.Handle(Get, "/hello", handlerA)
+	res, err := b.Route(events.APIGatewayProxyRequest{HTTPMethod: Head, Path: "/hello"})
+	require.Nil(t, err)
+	require.Equal(t, "", res.Body)
+}
+
+func TestBouncerMethodNotAllowed(t *testing.T) {
+	b := New("")
+	b.Handle(Get, "/hello", handlerA)
+	b.Handle(Post, "/hello", handlerB)
+	res, err := b.Route(events.APIGatewayProxyRequest{HTTPMethod: Delete, Path: "/hello"})
+	require.NotNil(t, err)
+	require.Equal(t, 405, err.(*RouteError).StatusCode)
+	require.Equal(t, 405, res.StatusCode)
+	require.Equal(t, "GET, HEAD, POST", res.Headers["Allow"])
+}
+
+func TestBouncerNotFound(t *testing.T) {
+	b := New("")
+	b.Handle(Get, "/hello", handlerA)
+	res, err := b.Route(events.APIGatewayProxyRequest{HTTPMethod: Post, Path: "/world"})
+	require.NotNil(t, err)
+	require.Equal(t, 404, err.(*RouteError).StatusCode)
+	require.Equal(t, 404, res.StatusCode)
+}
+
+// tracer returns a middleware that records name in calls before calling the
+// handler.
+func tracer(name string, calls *[]string) Middleware {
+	return func(handler ApiHandler) ApiHandler {
+		return func(parameters map[string]string, req events.APIGatewayProxyRequest) *events.APIGatewayProxyResponse {
+			*calls = append(*calls, name)
+			return handler(parameters, req)
+		}
+	}
+}
+
+func TestBouncerMiddlewareOrder(t *testing.T) {
+	var calls []string
+	b := New("")
+	b.Handle(Get, "/hello", handlerA, tracer("route", &calls))
+	b.Use(tracer("global1", &calls), tracer("global2", &calls))
+	res, err := b.Route(events.APIGatewayProxyRequest{Path: "/hello"})
+	require.Nil(t, err)
+	require.Equal(t, "hello from A", res.Body)
+	require.Equal(t, []string{"global1", "global2", "route"}, calls)
+}
+
+func TestBouncerGlobalMiddlewareWrapsNotFound(t *testing.T) {
+	var calls []string
+	b := New("")
+	b.Use(tracer("global", &calls))
+	res, err := b.Route(events.APIGatewayProxyRequest{Path: "/nothing"})
+	require.NotNil(t, err)
+	require.Equal(t, 404, res.StatusCode)
+	require.Equal(t, []string{"global"}, calls)
+}
+
+// routeBody routes a GET for the path and returns the response body, or the
+// status code if no route matched
+func routeBody(b *Bouncer, path string) string {
+	res, err := b.Route(events.APIGatewayProxyRequest{Path: path})
+	if err != nil {
+		return fmt.Sprint(res.StatusCode)
+	}
+	return res.Body
+}
+
+func TestBouncerStaticBeforeParameters(t *testing.T) {
+	b := New("")
+	b.Handle(Get, "/books/new", handlerA)
+	b.Handle(Get, "/books/{bookId}", paramPrinter)
+	b.Handle(Get, "/{first}/one", paramPrinter)
+	b.Handle(Get, "/a/{second}/two", paramPrinter)
+
+	require.Equal(t, "hello from A", routeBody(b, "/books/new"))
+	require.Equal(t, "map[bookId:666]", routeBody(b, "/books/666"))
+	// a static segment that leads nowhere falls back to the parameter, without
+	// keeping the parameters of the abandoned route
+	require.Equal(t, "map[first:a]", routeBody(b, "/a/one"))
+	require.Equal(t, "map[second:b]", routeBody(b, "/a/b/two"))
+	// unknown segments are never skipped
+	require.Equal(t, "404", routeBody(b, "/books/666/extra"))
+	require.Equal(t, "404", routeBody(b, "/books/"))
+}
+
+func TestBouncerConstrainedParameters(t *testing.T) {
+	b := New("")
+	b.Handle(Get, "/themes/{themeId:date}", paramPrinter)
+	b.Handle(Get, "/themes/{name}", handlerA)
+	b.Handle(Get, "/pages/{page:int}", paramPrinter)
+	b.Handle(Get, "/hooks/{id:uuid}", paramPrinter)
+	b.Handle(Get, "/codes/{code:[a-z]{3}}", paramPrinter)
+
+	require.Equal(t, "map[themeId:2020-05-01]", routeBody(b, "/themes/2020-05-01"))
+	require.Equal(t, "hello from A", routeBody(b, "/themes/2020-13-01"))
+	require.Equal(t, "map[page:12]", routeBody(b, "/pages/12"))
+	require.Equal(t, "404", routeBody(b, "/pages/twelve"))
+	require.Equal(t, "map[id:1b4e28ba-2fa1-11d2-883f-0016d3cca427]", routeBody(b, "/hooks/1b4e28ba-2fa1-11d2-883f-0016d3cca427"))
+	require.Equal(t, "404", routeBody(b, "/hooks/123"))
+	require.Equal(t, "map[code:abc]", routeBody(b, "/codes/abc"))
+	require.Equal(t, "404", routeBody(b, "/codes/abcd"))
+}
+
+func TestBouncerCatchAll(t *testing.T) {
+	b := New("")
+	b.Handle(Get, "/files/{path...}", paramPrinter)
+	b.Handle(Get, "/files/readme", handlerA)
+
+	require.Equal(t, "map[path:a/b/c.txt]", routeBody(b, "/files/a/b/c.txt"))
+	require.Equal(t, "map[path:a]", routeBody(b, "/files/a"))
+	require.Equal(t, "hello from A", routeBody(b, "/files/readme"))
+	require.Equal(t, "map[path:readme/more]", routeBody(b, "/files/readme/more"))
+	require.Equal(t, "404", routeBody(b, "/files"))
+}
+
+func TestBouncerOptionalParameters(t *testing.T) {
+	b := New("")
+	b.Handle(Get, "/games/{gameId?}", paramPrinter)
+	b.Handle(Get, "/pages/{page?:int}", paramPrinter)
+
+	require.Equal(t, "map[]", routeBody(b, "/games"))
+	require.Equal(t, "map[gameId:current]", routeBody(b, "/games/current"))
+	require.Equal(t, "map[]", routeBody(b, "/pages"))
+	require.Equal(t, "map[page:2]", routeBody(b, "/pages/2"))
+	require.Equal(t, "404", routeBody(b, "/pages/two"))
+}
+
+func TestBouncerRejectsBadPatterns(t *testing.T) {
+	for _, patterns := range [][]string{
+		{"/a/{x}", "/a/{y}/b"},
+		{"/a/{x:int}", "/a/{x:date}"},
+		{"/a/{rest...}", "/a/{more...}"},
+		{"/a/{rest...}/b"},
+		{"/a/{x?}/b"},
+		{"/a/{x}/{x}"},
+		{"/a/{x"},
+		{"/a/x{y}"},
+		{"/a/{}"},
+		{"/a/{x:[}"},
+		{"/a/{rest?...}"},
+	} {
+		b := New("")
+		last := len(patterns) - 1
+		for _, pattern := range patterns[:last] {
+			require.Nil(t, b.Handle(Get, pattern, handlerA))
+		}
+		err := b.Handle(Get, patterns[last], handlerB)
+		require.NotNil(t, err, patterns[last])
+		require.Equal(t, patterns[last], err.(*PatternError).Pattern)
+		require.Equal(t, err, b.Err())
+	}
+
+	// the same parameter can be shared by routes
+	b := New("")
+	require.Nil(t, b.Handle(Get, "/a/{x:int}", handlerA))
+	require.Nil(t, b.Handle(Get, "/a/{x:int}/b", handlerB))
+	require.Nil(t, b.Err())
+}
+
+func TestBouncerRejectsDuplicateRoutes(t *testing.T) {
+	b := New("/base")
+	g := b.Group("/books")
+	require.Nil(t, g.Handle(Get, "/{bookId}", handlerA))
+	require.Nil(t, g.Handle(Put, "/{bookId}", handlerA))
+	require.Nil(t, b.Handle(Get, "/pages/{page?}", handlerA))
+
+	err := b.Handle(Get, "/books/{bookId}", handlerB)
+	require.NotNil(t, err)
+	require.Equal(t, "Can't register GET /books/{bookId}: GET is already registered", err.Error())
+	require.NotNil(t, g.Handle("put", "/{bookId}", handlerB))
+	require.NotNil(t, b.Handle(Get, "/pages", handlerB))
+	require.NotNil(t, b.Handle(Get, "/pages/{page}", handlerB))
+	require.Equal(t, err, b.Err())
+
+	// the original routes are kept
+	require.Equal(t, "hello from A", routeBody(b, "/base/books/1"))
+	require.Equal(t, "hello from A", routeBody(b, "/base/pages"))
+}
+
+func TestBouncerRejectedRoutesLeaveNoTrace(t *testing.T) {
+	b := New("")
+	require.Nil(t, b.Handle(Get, "/a/{x}", handlerA))
+	require.NotNil(t, b.Handle(Get, "/b/{y}/{x}/{x}", handlerB))
+	require.NotNil(t, b.Handle(Get, "/a/{y}/c", handlerB))
+
+	// neither rejected route created a {y} parameter
+	require.Nil(t, b.Handle(Get, "/b/{z}", handlerB))
+	require.Nil(t, b.Handle(Get, "/a/{x}/c", handlerB))
+	require.Equal(t, "404", routeBody(b, "/b/1/2/3"))
+}
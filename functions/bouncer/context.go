@@ -0,0 +1,117 @@
+package bouncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Principal is who a request is authenticated as. It is empty for anonymous
+// requests.
+type Principal struct {
+	Username string
+	Admin    bool
+}
+
+// Context is what a Handler gets for a request. The embedded context.Context
+// carries the request's deadline and cancellation.
+type Context struct {
+	context.Context
+	Request events.APIGatewayProxyRequest
+	// Parameters holds the route's parameters and anything set by middlewares
+	Parameters map[string]string
+	Principal  Principal
+}
+
+// Handler serves a request, returning an error instead of a response when it
+// fails. Errors are turned into responses by the Bouncer's ErrorResponse.
+type Handler func(*Context) (*events.APIGatewayProxyResponse, error)
+
+// ErrorMapper turns an error from a Handler into a response
+type ErrorMapper func(*Context, error) *events.APIGatewayProxyResponse
+
+// HTTPError is an error whose message can be shown to the client with its
+// status code
+type HTTPError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *HTTPError) Error() string {
+	return e.Message
+}
+
+// Errorf returns an *HTTPError with the status code and formatted message
+func Errorf(statusCode int, format string, args ...interface{}) *HTTPError {
+	return &HTTPError{StatusCode: statusCode, Message: fmt.Sprintf(format, args...)}
+}
+
+// DefaultErrorResponse is the ErrorMapper used when a Bouncer has none. An
+// *HTTPError responds with its status code and message, a *BindError with a
+// 400 listing its problems, a *RouteError with its 404 or 405, and any other
+// error is logged and responds with a 500.
+func DefaultErrorResponse(c *Context, err error) *events.APIGatewayProxyResponse {
+	var routeErr *RouteError
+	if errors.As(err, &routeErr) {
+		response := &events.APIGatewayProxyResponse{
+			StatusCode: routeErr.StatusCode,
+			Body:       routeErr.Error(),
+		}
+		if len(routeErr.Allow) > 0 {
+			response.Headers = map[string]string{"Allow": strings.Join(routeErr.Allow, ", ")}
+		}
+		return response
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return &events.APIGatewayProxyResponse{
+			StatusCode: httpErr.StatusCode,
+			Body:       httpErr.Message,
+		}
+	}
+	var bindErr *BindError
+	if errors.As(err, &bindErr) {
+		return &events.APIGatewayProxyResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       bindErr.Error(),
+		}
+	}
+
+	fmt.Printf("ERROR: %v %v: %v\n", c.Request.HTTPMethod, c.Request.Path, err.Error())
+	return &events.APIGatewayProxyResponse{
+		StatusCode: http.StatusInternalServerError,
+		Body:       "Internal Server Error",
+	}
+}
+
+// Adapt turns an ApiHandler into a Handler that never fails
+func Adapt(handler ApiHandler) Handler {
+	return func(c *Context) (*events.APIGatewayProxyResponse, error) {
+		return handler(c.Parameters, c.Request), nil
+	}
+}
+
+// serve returns an ApiHandler that calls the handler with c, so middlewares
+// can wrap it. c is filled in from what the middlewares pass on.
+func (b *Bouncer) serve(c *Context, handler Handler) ApiHandler {
+	return func(parameters map[string]string, req events.APIGatewayProxyRequest) *events.APIGatewayProxyResponse {
+		c.Request = req
+		c.Parameters = parameters
+		if b.PrincipalFrom != nil {
+			c.Principal = b.PrincipalFrom(parameters)
+		}
+
+		response, err := handler(c)
+		if err == nil {
+			return response
+		}
+		if b.ErrorResponse != nil {
+			return b.ErrorResponse(c, err)
+		}
+		return DefaultErrorResponse(c, err)
+	}
+}
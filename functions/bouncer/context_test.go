@@ -0,0 +1,117 @@
+package bouncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+type contextKey string
+
+// signIn is a middleware that authenticates every request as the user in the
+// X-User header
+func signIn(handler ApiHandler) ApiHandler {
+	return func(parameters map[string]string, req events.APIGatewayProxyRequest) *events.APIGatewayProxyResponse {
+		parameters["username"] = req.Headers["X-User"]
+		return handler(parameters, req)
+	}
+}
+
+func TestHandleContext(t *testing.T) {
+	b := New("")
+	b.Use(signIn)
+	b.PrincipalFrom = func(parameters map[string]string) Principal {
+		return Principal{Username: parameters["username"], Admin: parameters["username"] == "root"}
+	}
+	b.HandleContext(Post, "/books/{bookId}", func(c *Context) (*events.APIGatewayProxyResponse, error) {
+		return &events.APIGatewayProxyResponse{
+			Body: fmt.Sprintf("%v %v %v %+v", c.Value(contextKey("trace")), c.Parameters["bookId"], c.Request.Body, c.Principal),
+		}, nil
+	})
+
+	ctx := context.WithValue(context.Background(), contextKey("trace"), "abc")
+	res, err := b.RouteContext(ctx, events.APIGatewayProxyRequest{
+		HTTPMethod: Post,
+		Path:       "/books/123",
+		Headers:    map[string]string{"X-User": "root"},
+		Body:       "hello",
+	})
+	require.Nil(t, err)
+	require.Equal(t, "abc 123 hello {Username:root Admin:true}", res.Body)
+}
+
+func TestHandleContextErrors(t *testing.T) {
+	b := New("")
+	b.HandleContext(Get, "/missing", func(c *Context) (*events.APIGatewayProxyResponse, error) {
+		return nil, Errorf(404, "No book %v", 123)
+	})
+	b.HandleContext(Get, "/wrapped", func(c *Context) (*events.APIGatewayProxyResponse, error) {
+		return nil, fmt.Errorf("checking: %w", Errorf(403, "Forbidden"))
+	})
+	b.HandleContext(Get, "/broken", func(c *Context) (*events.APIGatewayProxyResponse, error) {
+		return nil, errors.New("database is down")
+	})
+
+	expected := map[string]struct {
+		status int
+		body   string
+	}{
+		"/missing": {404, "No book 123"},
+		"/wrapped": {403, "Forbidden"},
+		"/broken":  {500, "Internal Server Error"},
+	}
+	for path, want := range expected {
+		res, err := b.Route(events.APIGatewayProxyRequest{Path: path})
+		require.Nil(t, err, path)
+		require.Equal(t, want.status, res.StatusCode, path)
+		require.Equal(t, want.body, res.Body, path)
+	}
+}
+
+func TestErrorResponseWrappedByMiddlewares(t *testing.T) {
+	var calls []string
+	b := New("")
+	b.Use(tracer("global", &calls))
+	b.ErrorResponse = func(c *Context, err error) *events.APIGatewayProxyResponse {
+		calls = append(calls, "error")
+		return &events.APIGatewayProxyResponse{StatusCode: 418, Body: c.Parameters["name"] + ": " + err.Error()}
+	}
+	b.HandleContext(Get, "/{name}", func(c *Context) (*events.APIGatewayProxyResponse, error) {
+		return nil, errors.New("failed")
+	}, tracer("route", &calls))
+
+	res, err := b.Route(events.APIGatewayProxyRequest{Path: "/teapot"})
+	require.Nil(t, err)
+	require.Equal(t, 418, res.StatusCode)
+	require.Equal(t, "teapot: failed", res.Body)
+	require.Equal(t, []string{"global", "route", "error"}, calls)
+}
+
+func TestRouteContextCancellation(t *testing.T) {
+	b := New("")
+	b.HandleContext(Get, "/slow", func(c *Context) (*events.APIGatewayProxyResponse, error) {
+		<-c.Done()
+		return nil, Errorf(503, "Gave up: %v", c.Err())
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res, err := b.RouteContext(ctx, events.APIGatewayProxyRequest{Path: "/slow"})
+	require.Nil(t, err)
+	require.Equal(t, 503, res.StatusCode)
+	require.Equal(t, "Gave up: context canceled", res.Body)
+}
+
+func TestAdapt(t *testing.T) {
+	handler := Adapt(paramPrinter)
+	res, err := handler(&Context{
+		Context:    context.Background(),
+		Parameters: map[string]string{"bookId": "123"},
+	})
+	require.Nil(t, err)
+	require.Equal(t, "map[bookId:123]", res.Body)
+}
@@ -0,0 +1,36 @@
+package bouncer
+
+// Group registers routes that share a path prefix and middlewares.
+type Group struct {
+	bouncer     *Bouncer
+	prefix      string
+	middlewares []Middleware
+}
+
+// Use adds middlewares to the group. They only apply to routes registered on
+// the group afterwards.
+func (g *Group) Use(middlewares ...Middleware) {
+	g.middlewares = append(g.middlewares, middlewares...)
+}
+
+// Handle registers the handler for the method and the pattern appended to the
+// group's prefix. The group's middlewares run before any given here. Errors
+// are as for Bouncer.Handle.
+func (g *Group) Handle(method Method, pattern string, handler ApiHandler, middlewares ...Middleware) error {
+	return g.HandleContext(method, pattern, Adapt(handler), middlewares...)
+}
+
+// HandleContext is Handle for a Handler.
+func (g *Group) HandleContext(method Method, pattern string, handler Handler, middlewares ...Middleware) error {
+	all := append(append([]Middleware{}, g.middlewares...), middlewares...)
+	return g.bouncer.HandleContext(method, g.prefix+pattern, handler, all...)
+}
+
+// Group returns a nested group whose prefix and middlewares extend this one's.
+func (g *Group) Group(prefix string, middlewares ...Middleware) *Group {
+	return &Group{
+		bouncer:     g.bouncer,
+		prefix:      g.prefix + prefix,
+		middlewares: append(append([]Middleware{}, g.middlewares...), middlewares...),
+	}
+}
@@ -0,0 +1,61 @@
+package bouncer
+
+import (
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupPrefix(t *testing.T) {
+	b := New("/base")
+	authors := b.Group("/authors/{authorId}")
+	authors.Handle(Get, "", paramPrinter)
+	authors.Handle(Get, "/books/{bookId}", paramPrinter)
+
+	res, err := b.Route(events.APIGatewayProxyRequest{Path: "/base/authors/123"})
+	require.Nil(t, err)
+	require.Equal(t, "map[authorId:123]", res.Body)
+
+	res, err = b.Route(events.APIGatewayProxyRequest{Path: "/base/authors/123/books/666"})
+	require.Nil(t, err)
+	require.Equal(t, "map[authorId:123 bookId:666]", res.Body)
+}
+
+func TestGroupMiddlewareOrder(t *testing.T) {
+	var calls []string
+	b := New("")
+	b.Use(tracer("global", &calls))
+	outer := b.Group("/outer", tracer("outer", &calls))
+	inner := outer.Group("/inner", tracer("inner", &calls))
+	inner.Handle(Get, "/hello", handlerA, tracer("route", &calls))
+	b.Handle(Get, "/plain", handlerB)
+
+	res, err := b.Route(events.APIGatewayProxyRequest{Path: "/outer/inner/hello"})
+	require.Nil(t, err)
+	require.Equal(t, "hello from A", res.Body)
+	require.Equal(t, []string{"global", "outer", "inner", "route"}, calls)
+
+	calls = nil
+	res, err = b.Route(events.APIGatewayProxyRequest{Path: "/plain"})
+	require.Nil(t, err)
+	require.Equal(t, "hello from B", res.Body)
+	require.Equal(t, []string{"global"}, calls)
+}
+
+func TestGroupUseOnlyAffectsLaterRoutes(t *testing.T) {
+	var calls []string
+	b := New("")
+	g := b.Group("/g")
+	g.Handle(Get, "/before", handlerA)
+	g.Use(tracer("late", &calls))
+	g.Handle(Get, "/after", handlerB)
+
+	_, err := b.Route(events.APIGatewayProxyRequest{Path: "/g/before"})
+	require.Nil(t, err)
+	require.Empty(t, calls)
+
+	_, err = b.Route(events.APIGatewayProxyRequest{Path: "/g/after"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"late"}, calls)
+}
@@ -0,0 +1,338 @@
+package bouncer
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type segmentKind int
+
+const (
+	staticSegment segmentKind = iota
+	paramSegment
+	catchAllSegment
+)
+
+// constraint limits the values a parameter matches
+type constraint struct {
+	name  string
+	match func(string) bool
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// namedConstraints are the types a parameter can be constrained to by name
+var namedConstraints = map[string]func(string) bool{
+	"int": func(value string) bool {
+		_, err := strconv.Atoi(value)
+		return err == nil
+	},
+	"date": func(value string) bool {
+		_, err := time.Parse("2006-01-02", value)
+		return err == nil
+	},
+	"uuid": uuidPattern.MatchString,
+}
+
+func newConstraint(name string) (*constraint, error) {
+	if match, ok := namedConstraints[name]; ok {
+		return &constraint{name: name, match: match}, nil
+	}
+
+	re, err := regexp.Compile("^(?:" + name + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid parameter constraint %q: %v", name, err)
+	}
+	return &constraint{name: name, match: re.MatchString}, nil
+}
+
+type segment struct {
+	kind segmentKind
+	// value is the text of a static segment or the name of a parameter
+	value      string
+	constraint *constraint
+	optional   bool
+}
+
+func (s segment) String() string {
+	switch s.kind {
+	case catchAllSegment:
+		return "{" + s.value + "...}"
+	case paramSegment:
+		name := s.value
+		if s.optional {
+			name += "?"
+		}
+		if s.constraint != nil {
+			return "{" + name + ":" + s.constraint.name + "}"
+		}
+		return "{" + name + "}"
+	default:
+		return s.value
+	}
+}
+
+func parseSegment(text string) (segment, error) {
+	if !strings.HasPrefix(text, "{") {
+		if strings.ContainsAny(text, "{}") {
+			return segment{}, fmt.Errorf("segment %q must be static or a whole {parameter}", text)
+		}
+		return segment{kind: staticSegment, value: text}, nil
+	}
+	if !strings.HasSuffix(text, "}") {
+		return segment{}, fmt.Errorf("segment %q must be static or a whole {parameter}", text)
+	}
+
+	inner := text[1 : len(text)-1]
+	name, constraintName := inner, ""
+	if i := strings.Index(inner, ":"); i >= 0 {
+		name, constraintName = inner[:i], inner[i+1:]
+	}
+
+	result := segment{kind: paramSegment}
+	if strings.HasSuffix(name, "...") {
+		result.kind = catchAllSegment
+		name = strings.TrimSuffix(name, "...")
+	}
+	if strings.HasSuffix(name, "?") {
+		result.optional = true
+		name = strings.TrimSuffix(name, "?")
+	}
+	if name == "" || strings.ContainsAny(name, "{}?.") {
+		return segment{}, fmt.Errorf("invalid parameter name in %q", text)
+	}
+	result.value = name
+
+	if result.kind == catchAllSegment && (result.optional || constraintName != "") {
+		return segment{}, fmt.Errorf("catch-all %q can't be optional or constrained", text)
+	}
+	if constraintName != "" {
+		c, err := newConstraint(constraintName)
+		if err != nil {
+			return segment{}, err
+		}
+		result.constraint = c
+	}
+	return result, nil
+}
+
+// parsePattern splits a pattern into segments, checking that catch-alls and
+// optional parameters are last and parameter names are unique.
+func parsePattern(pattern string) ([]segment, error) {
+	parts := strings.Split(pattern, "/")
+	segments := make([]segment, len(parts))
+	names := map[string]bool{}
+	for i, part := range parts {
+		s, err := parseSegment(part)
+		if err != nil {
+			return nil, err
+		}
+		last := i == len(parts)-1
+		if s.kind == catchAllSegment && !last {
+			return nil, fmt.Errorf("catch-all %v must be the last segment", s)
+		}
+		if s.optional && !last {
+			return nil, fmt.Errorf("optional parameter %v must be the last segment", s)
+		}
+		if s.kind != staticSegment {
+			if names[s.value] {
+				return nil, fmt.Errorf("parameter %q is used more than once", s.value)
+			}
+			names[s.value] = true
+		}
+		segments[i] = s
+	}
+	return segments, nil
+}
+
+// paramEdge leads from a node to the node after one of its parameters
+type paramEdge struct {
+	name       string
+	constraint *constraint
+	next       *node
+}
+
+// route is a registered handler with the middlewares that only apply to it
+type route struct {
+	handler     Handler
+	middlewares []Middleware
+}
+
+// node is a segment of the routing tree. Nodes are only reached by pointer so
+// registering a route under an existing prefix extends the same nodes.
+type node struct {
+	handlers map[Method]route
+	static   map[string]*node
+	// constrained is tried before param, and catchAll last
+	constrained *paramEdge
+	param       *paramEdge
+	catchAll    *paramEdge
+}
+
+func newNode() *node {
+	return &node{
+		handlers: make(map[Method]route),
+		static:   make(map[string]*node),
+	}
+}
+
+// slot returns where the node keeps its edge for the parameter segment
+func (n *node) slot(s segment) **paramEdge {
+	switch {
+	case s.kind == catchAllSegment:
+		return &n.catchAll
+	case s.constraint != nil:
+		return &n.constrained
+	default:
+		return &n.param
+	}
+}
+
+// sameParam determines if the edge is for the parameter segment. Different
+// parameters in the same slot would be ambiguous.
+func (e *paramEdge) sameParam(s segment) bool {
+	if e.name != s.value || (e.constraint == nil) != (s.constraint == nil) {
+		return false
+	}
+	return e.constraint == nil || e.constraint.name == s.constraint.name
+}
+
+// conflict returns why the route can't be added to the tree, or nil if it
+// can. It doesn't change the tree, so a rejected route leaves no trace.
+func (n *node) conflict(segments []segment, method Method) error {
+	current := n
+	for _, s := range segments {
+		if _, ok := current.handlers[method]; ok && s.optional {
+			return fmt.Errorf("%v is already registered without %v", method, s)
+		}
+
+		var next *node
+		if s.kind == staticSegment {
+			next = current.static[s.value]
+		} else if e := *current.slot(s); e != nil {
+			if !e.sameParam(s) {
+				registered := segment{kind: s.kind, value: e.name, constraint: e.constraint}
+				return fmt.Errorf("%v is ambiguous with %v", s, registered)
+			}
+			next = e.next
+		}
+		if next == nil {
+			// the rest of the route is new
+			return nil
+		}
+		current = next
+	}
+
+	if _, ok := current.handlers[method]; ok {
+		return fmt.Errorf("%v is already registered", method)
+	}
+	return nil
+}
+
+// add registers the route at the end of the segments. The route must not
+// conflict with the tree.
+func (n *node) add(segments []segment, method Method, r route) {
+	current := n
+	for _, s := range segments {
+		if s.optional {
+			// the route also ends before its optional parameter
+			current.handlers[method] = r
+		}
+
+		if s.kind == staticSegment {
+			next, ok := current.static[s.value]
+			if !ok {
+				next = newNode()
+				current.static[s.value] = next
+			}
+			current = next
+			continue
+		}
+
+		slot := current.slot(s)
+		if *slot == nil {
+			*slot = &paramEdge{name: s.value, constraint: s.constraint, next: newNode()}
+		}
+		current = (*slot).next
+	}
+
+	current.handlers[method] = r
+}
+
+// match finds the node serving the path's segments, adding the values of its
+// parameters to parameters. A node only serves the path if accept does, so a
+// route that can't serve the request doesn't hide a less preferred one that
+// can. It returns nil if there is none.
+func (n *node) match(segments []string, parameters map[string]string, accept func(*node) bool) *node {
+	if len(segments) == 0 {
+		if len(n.handlers) > 0 && accept(n) {
+			return n
+		}
+		return nil
+	}
+
+	value := segments[0]
+	if next, ok := n.static[value]; ok {
+		if found := next.match(segments[1:], parameters, accept); found != nil {
+			return found
+		}
+	}
+
+	if value != "" {
+		for _, e := range []*paramEdge{n.constrained, n.param} {
+			if e == nil || (e.constraint != nil && !e.constraint.match(value)) {
+				continue
+			}
+			if found := e.next.match(segments[1:], parameters, accept); found != nil {
+				parameters[e.name] = value
+				return found
+			}
+		}
+	}
+
+	if n.catchAll != nil && len(n.catchAll.next.handlers) > 0 {
+		rest := strings.Join(segments, "/")
+		if rest != "" && accept(n.catchAll.next) {
+			parameters[n.catchAll.name] = rest
+			return n.catchAll.next
+		}
+	}
+	return nil
+}
+
+// get finds the node serving the method at the path, returning nil if there
+// is none.
+func (n *node) get(method Method, path string) (*node, map[string]string) {
+	parameters := make(map[string]string)
+	found := n.match(strings.Split(path, "/"), parameters, func(candidate *node) bool {
+		_, ok, _ := candidate.route(method)
+		return ok
+	})
+	if found == nil {
+		return nil, nil
+	}
+	return found, parameters
+}
+
+// allowedAt lists the methods of every route matching the path, or nil if no
+// route does.
+func (n *node) allowedAt(path string) []string {
+	seen := map[string]bool{}
+	var methods []string
+	n.match(strings.Split(path, "/"), make(map[string]string), func(candidate *node) bool {
+		for _, method := range candidate.allowed() {
+			if !seen[method] {
+				seen[method] = true
+				methods = append(methods, method)
+			}
+		}
+		// keep looking for other matching routes
+		return false
+	})
+	sort.Strings(methods)
+	return methods
+}
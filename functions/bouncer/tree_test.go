@@ -0,0 +1,263 @@
+package bouncer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+// referenceRoute is a route for referenceMatch, with any optional parameter
+// expanded into a route with and a route without it
+type referenceRoute struct {
+	method   string
+	pattern  string
+	segments []segment
+}
+
+func referenceRoutes(method, pattern string) []referenceRoute {
+	segments, err := parsePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+	routes := []referenceRoute{{method: method, pattern: pattern, segments: segments}}
+	if last := len(segments) - 1; segments[last].optional {
+		routes = append(routes, referenceRoute{method: method, pattern: pattern, segments: segments[:last]})
+	}
+	return routes
+}
+
+// splitMethod splits a line like "POST /leagues/new" into its method and
+// pattern. Lines without a method are GET routes.
+func splitMethod(line string) (string, string) {
+	for _, method := range []string{Get, Post, Delete} {
+		if strings.HasPrefix(line, method+" ") {
+			return method, strings.TrimPrefix(line, method+" ")
+		}
+	}
+	return Get, line
+}
+
+// rank orders the kinds of segments by precedence
+func rank(s segment) int {
+	switch {
+	case s.kind == staticSegment:
+		return 0
+	case s.kind == paramSegment && s.constraint != nil:
+		return 1
+	case s.kind == paramSegment:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// matchRoute matches every segment of the path against the route
+func matchRoute(route referenceRoute, parts []string) (map[string]string, bool) {
+	parameters := map[string]string{}
+	for i, s := range route.segments {
+		if s.kind == catchAllSegment {
+			rest := strings.Join(parts[i:], "/")
+			if i >= len(parts) || rest == "" {
+				return nil, false
+			}
+			parameters[s.value] = rest
+			return parameters, true
+		}
+		if i >= len(parts) {
+			return nil, false
+		}
+		switch {
+		case s.kind == staticSegment && parts[i] != s.value:
+			return nil, false
+		case s.kind == paramSegment && (parts[i] == "" || (s.constraint != nil && !s.constraint.match(parts[i]))):
+			return nil, false
+		case s.kind == paramSegment:
+			parameters[s.value] = parts[i]
+		}
+	}
+	return parameters, len(route.segments) == len(parts)
+}
+
+// referenceMatch is a slow matcher to check the tree against. It tries every
+// route for the method and picks the match whose segments have the best
+// precedence, earlier segments first. If none matches it returns the methods
+// of the routes matching the path.
+func referenceMatch(routes []referenceRoute, method, path string) (string, []string) {
+	parts := strings.Split(path, "/")
+	var best *referenceRoute
+	var bestParameters map[string]string
+	allowed := map[string]bool{}
+	for i := range routes {
+		parameters, ok := matchRoute(routes[i], parts)
+		if !ok {
+			continue
+		}
+		allowed[routes[i].method] = true
+		if routes[i].method == Get {
+			allowed[Head] = true
+		}
+		if routes[i].method != method {
+			continue
+		}
+		if best == nil || precedes(routes[i].segments, best.segments) {
+			best = &routes[i]
+			bestParameters = parameters
+		}
+	}
+	if best == nil {
+		var allow []string
+		for m := range allowed {
+			allow = append(allow, m)
+		}
+		sort.Strings(allow)
+		return "", allow
+	}
+	return best.pattern + " " + fmt.Sprint(bestParameters), nil
+}
+
+func precedes(a, b []segment) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if rank(a[i]) != rank(b[i]) {
+			return rank(a[i]) < rank(b[i])
+		}
+	}
+	return false
+}
+
+// patternHandler responds with its pattern and the request's parameters, like
+// referenceMatch
+func patternHandler(pattern string) ApiHandler {
+	return func(parameters map[string]string, req events.APIGatewayProxyRequest) *events.APIGatewayProxyResponse {
+		return &events.APIGatewayProxyResponse{Body: pattern + " " + fmt.Sprint(parameters)}
+	}
+}
+
+// checkAgainstReference registers the patterns that the bouncer accepts and
+// checks that it routes the request like referenceMatch
+func checkAgainstReference(t *testing.T, lines []string, method, path string) {
+	b := New("")
+	var routes []referenceRoute
+	for _, line := range lines {
+		routeMethod, pattern := splitMethod(line)
+		if b.Handle(Method(routeMethod), pattern, patternHandler(pattern)) == nil {
+			routes = append(routes, referenceRoutes(routeMethod, pattern)...)
+		}
+	}
+
+	expected, allow := referenceMatch(routes, method, path)
+	res, err := b.Route(events.APIGatewayProxyRequest{HTTPMethod: method, Path: path})
+	if expected == "" {
+		require.NotNil(t, err, "%v %v matched %v", method, path, res.Body)
+		routeErr, ok := err.(*RouteError)
+		require.True(t, ok)
+		if len(allow) == 0 {
+			require.Equal(t, 404, res.StatusCode, "%v %v", method, path)
+		} else {
+			require.Equal(t, 405, res.StatusCode, "%v %v", method, path)
+			require.Equal(t, allow, routeErr.Allow, "%v %v", method, path)
+		}
+		return
+	}
+	require.Nil(t, err, "%v %v should match %v", method, path, expected)
+	require.Equal(t, expected, res.Body)
+}
+
+var referencePatterns = []string{
+	"/leagues/{leagueName}",
+	"/leagues/{leagueName}/themes/{themeId:date}",
+	"/leagues/{leagueName}/themes/{themeId}/songs",
+	"/leagues/{leagueName}/themes/new",
+	"/leagues/mxtp/themes/{themeId}",
+	"/leagues/{leagueName}/games/{gameId?}",
+	"/leagues/{leagueName}/hooks/{hookId:uuid}",
+	"/leagues/{leagueName}/pages/{page?:int}",
+	"/files/{path...}",
+	"/files/{name}/raw",
+	"/{first}/one",
+	"/a/{second}/two",
+	"/codes/{code:[a-z]{3}}",
+	"",
+	"/",
+	"POST /leagues/new",
+	"POST /leagues/{leagueName}/themes/{themeId}",
+	"DELETE /leagues/{leagueName}/hooks/{hookId}",
+	"POST /files/{path...}",
+}
+
+func TestTreeMatchesReference(t *testing.T) {
+	paths := []string{
+		"", "/", "//", "/leagues", "/leagues/devetry", "/leagues/devetry/",
+		"/leagues/devetry/themes/2020-05-01", "/leagues/devetry/themes/2020-05-01/songs",
+		"/leagues/devetry/themes/tomorrow", "/leagues/devetry/themes/new", "/leagues/mxtp/themes/new",
+		"/leagues/mxtp/themes/2020-05-01", "/leagues/mxtp/themes/tomorrow",
+		"/leagues/devetry/games", "/leagues/devetry/games/current", "/leagues/devetry/games/current/more",
+		"/leagues/devetry/hooks/1b4e28ba-2fa1-11d2-883f-0016d3cca427", "/leagues/devetry/hooks/bot",
+		"/leagues/devetry/pages", "/leagues/devetry/pages/2", "/leagues/devetry/pages/two",
+		"/files", "/files/", "/files/a", "/files/a/raw", "/files/a/b/raw", "/files/a/raw/more",
+		"/a/one", "/a/b/two", "/b/one", "/codes/abc", "/codes/ab", "/leagues/new",
+		"/leagues/devetry/hooks/1b4e28ba-2fa1-11d2-883f-0016d3cca427/more",
+	}
+	for _, path := range paths {
+		for _, method := range []string{Get, Post, Delete} {
+			checkAgainstReference(t, referencePatterns, method, path)
+		}
+	}
+}
+
+func FuzzTree(f *testing.F) {
+	f.Add(strings.Join(referencePatterns, "\n"), Get, "/leagues/devetry/themes/2020-05-01")
+	f.Add(strings.Join(referencePatterns, "\n"), Get, "/files/a/raw")
+	f.Add(strings.Join(referencePatterns, "\n"), Get, "/leagues/new")
+	f.Add("/a/{x}\n/a/b/{y...}\n/{z:int}/b", Get, "/a/b/c")
+	f.Add("/{x?}\n/{y:[0-9]+}/{z}", Get, "/1/2")
+	f.Add("GET /a/{x}\nPOST /a/b\nDELETE /{y...}", Post, "/a/c")
+
+	f.Fuzz(func(t *testing.T, patterns string, method string, path string) {
+		if method != Get && method != Post && method != Delete {
+			t.Skip()
+		}
+		checkAgainstReference(t, strings.Split(patterns, "\n"), method, path)
+	})
+}
+
+func benchmarkBouncer() *Bouncer {
+	b := New("/.netlify/functions/jockey")
+	for _, pattern := range referencePatterns {
+		b.Handle(Get, pattern, handlerA)
+	}
+	return b
+}
+
+func benchmarkRoute(b *testing.B, path string) {
+	router := benchmarkBouncer()
+	req := events.APIGatewayProxyRequest{Path: "/.netlify/functions/jockey" + path}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		router.Route(req)
+	}
+}
+
+func BenchmarkRouteStatic(b *testing.B) {
+	benchmarkRoute(b, "/leagues/devetry/themes/new")
+}
+
+func BenchmarkRouteParameters(b *testing.B) {
+	benchmarkRoute(b, "/leagues/devetry/themes/2020-05-01/songs")
+}
+
+func BenchmarkRouteBacktracking(b *testing.B) {
+	benchmarkRoute(b, "/leagues/mxtp/themes/tomorrow/songs")
+}
+
+func BenchmarkRouteCatchAll(b *testing.B) {
+	benchmarkRoute(b, "/files/a/b/c/d/e/f")
+}
+
+func BenchmarkRouteNotFound(b *testing.B) {
+	benchmarkRoute(b, "/leagues/devetry/unknown/path")
+}
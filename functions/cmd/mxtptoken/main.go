@@ -0,0 +1,35 @@
+// Command mxtptoken issues jockey auth tokens using the signing keys in
+// JOCKEY_SIGNING_KEYS, e.g. for bootstrapping an admin.
+//
+//	JOCKEY_SIGNING_KEYS=k1:secret go run ./cmd/mxtptoken -user ted@devetry.com -admin
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/macintoshpie/mxtp-fx/authtoken"
+)
+
+func main() {
+	user := flag.String("user", "", "username (subject) of the token")
+	admin := flag.Bool("admin", false, "grant the admin claim")
+	flag.Parse()
+
+	signer, err := authtoken.FromEnv()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: ", err.Error())
+		os.Exit(1)
+	}
+
+	token, claims, err := signer.Issue(*user, *admin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: ", err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "expires %v\n", time.Unix(claims.ExpiresAt, 0).UTC().Format(time.RFC3339))
+	fmt.Println(token)
+}
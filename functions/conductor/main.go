@@ -0,0 +1,302 @@
+// conductor advances every league through its theme phases. It runs on a
+// schedule and acts on each phase boundary a theme has crossed: submissions
+// opening, submissions about to close, submissions closing (building the
+// voting playlist), voting opening and voting closing (tallying the results).
+// Each boundary is marked on the theme once acted on, so repeated or
+// overlapping runs are safe, and the league's hooks are notified.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/macintoshpie/mxtp-fx/mixtape"
+	"github.com/macintoshpie/mxtp-fx/music"
+	"github.com/macintoshpie/mxtp-fx/mxtpdb"
+	"github.com/macintoshpie/mxtp-fx/notify"
+	"github.com/macintoshpie/mxtp-fx/scoring"
+)
+
+// openStore returns the store used by the conductor. Tests replace it with an
+// in-memory store.
+var openStore = func() (mxtpdb.Store, error) {
+	db, err := mxtpdb.New()
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// spotifyHTTPClient sends the requests of Spotify clients, including token
+// refreshes. Tests replace it to talk to a fake Spotify server.
+var spotifyHTTPClient = http.DefaultClient
+
+// the conductor only refreshes tokens, so doesn't need a redirect
+var spotifyConfig = music.SpotifyConfig("")
+
+// notifyHTTPClient sends notifications to league hooks
+var notifyHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// ReminderLead is how long before submissions close leagues are reminded
+const ReminderLead = 24 * time.Hour
+
+// openMusic returns the music provider acting as the given user, whose
+// requests stop when ctx is done
+func openMusic(ctx context.Context, db mxtpdb.Store, userId string) (music.Provider, error) {
+	provider, err := music.OpenSpotify(ctx, db, userId, spotifyConfig, spotifyHTTPClient)
+	if err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+// ConductorHandler conducts every league. A league that fails doesn't stop
+// the others, and is tried again on the next run.
+func ConductorHandler(ctx context.Context, event events.CloudWatchEvent) error {
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+	db := store.WithContext(ctx)
+
+	leagueNames, err := db.GetLeagueNames()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	now := time.Now()
+	for _, leagueName := range leagueNames {
+		err := conductLeague(ctx, db, leagueName, now)
+		if err != nil {
+			fmt.Printf("ERROR: failed to conduct league %v: %v\n", leagueName, err.Error())
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("Failed to conduct %v of %v leagues", failed, len(leagueNames))
+	}
+	return nil
+}
+
+// conductLeague acts on the phase boundaries each of the league's themes has
+// crossed by now. Themes open as their schedule reaches them, so opening the
+// next theme is marking it once its submissions open. A theme that fails is
+// logged and doesn't stop the later ones.
+func conductLeague(ctx context.Context, db mxtpdb.Store, leagueName string, now time.Time) error {
+	league, err := db.GetLeague(leagueName)
+	if err != nil {
+		return err
+	}
+	themes, err := db.GetThemes(leagueName)
+	if err != nil {
+		return err
+	}
+	hooks, err := db.GetHooks(leagueName)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, theme := range themes {
+		err := conductTheme(ctx, db, league, hooks, theme, now)
+		if err != nil {
+			fmt.Printf("ERROR: failed to conduct league %v theme %v: %v\n", leagueName, theme.Date, err.Error())
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("Failed to conduct %v of %v themes", failed, len(themes))
+	}
+	return nil
+}
+
+func conductTheme(ctx context.Context, db mxtpdb.Store, league mxtpdb.League, hooks []mxtpdb.Hook, theme mxtpdb.Theme, now time.Time) error {
+	theme, err := league.ScheduleTheme(theme)
+	if err != nil {
+		return err
+	}
+	conductor := &themeConductor{ctx: ctx, db: db, leagueName: league.Name, hooks: hooks, theme: theme, now: now}
+	return conductor.conduct()
+}
+
+// themeConductor acts on the phase boundaries of one theme
+type themeConductor struct {
+	ctx        context.Context
+	db         mxtpdb.Store
+	leagueName string
+	hooks      []mxtpdb.Hook
+	theme      mxtpdb.Theme
+	now        time.Time
+}
+
+// conduct marks each transition the theme has reached that isn't marked yet.
+// Events are only sent for the theme's current phase, so themes that ended
+// before the conductor first ran don't notify anyone.
+func (c *themeConductor) conduct() error {
+	theme := c.theme
+	phase := theme.PhaseAt(c.now)
+	if phase == mxtpdb.PhaseScheduled {
+		return nil
+	}
+
+	if theme.SubmitOpenedAt.IsZero() {
+		marked, err := c.mark(mxtpdb.TransitionSubmitOpened)
+		if err != nil {
+			return err
+		}
+		if marked && phase == mxtpdb.PhaseSubmit {
+			c.notify(notify.EventThemeOpened, theme.SubmitClose, nil)
+		}
+	}
+	if phase == mxtpdb.PhaseSubmit {
+		if theme.SubmitRemindedAt.IsZero() && !c.now.Before(theme.SubmitClose.Add(-ReminderLead)) {
+			marked, err := c.mark(mxtpdb.TransitionSubmitReminded)
+			if err != nil {
+				return err
+			}
+			if marked {
+				c.notify(notify.EventSubmitClosing, theme.SubmitClose, nil)
+			}
+		}
+		return nil
+	}
+
+	if theme.SubmitClosedAt.IsZero() {
+		// claim the transition before creating the playlist, so overlapping
+		// runs don't each create one
+		marked, err := c.mark(mxtpdb.TransitionSubmitClosed)
+		if err != nil {
+			return err
+		}
+		// a theme whose voting already closed doesn't need a voting playlist.
+		// Voting opens without one if it can't be built, and an admin can
+		// build it later by its ThemeId.
+		if marked && phase != mxtpdb.PhaseClosed {
+			playlistId, err := buildPlaylist(c.ctx, c.db, c.leagueName, theme)
+			if err != nil {
+				fmt.Printf("ERROR: failed to build playlist for %v theme %v: %v\n", c.leagueName, theme.Date, err.Error())
+			}
+			if playlistId != "" {
+				c.theme.SpotifyPlaylistId = playlistId
+			}
+		}
+	}
+	if phase == mxtpdb.PhasePending {
+		return nil
+	}
+
+	if theme.VoteOpenedAt.IsZero() {
+		marked, err := c.mark(mxtpdb.TransitionVoteOpened)
+		if err != nil {
+			return err
+		}
+		if marked && phase == mxtpdb.PhaseVote {
+			c.notify(notify.EventVoteOpened, theme.VoteClose, nil)
+		}
+	}
+	if phase == mxtpdb.PhaseVote {
+		return nil
+	}
+
+	if theme.VoteClosedAt.IsZero() {
+		themeItems, err := c.db.GetThemeItems(c.leagueName, theme.Date)
+		if err != nil {
+			return err
+		}
+		results := scoring.Tally(themeItems)
+		marked, err := c.mark(mxtpdb.TransitionVoteClosed)
+		if err != nil {
+			return err
+		}
+		// only announce results of themes the conductor saw voting open for
+		if marked && !theme.VoteOpenedAt.IsZero() {
+			var winners []string
+			for _, winner := range results.Winners() {
+				winners = append(winners, notify.DisplayName(winner.UserId))
+			}
+			c.notify(notify.EventResultsPublished, theme.VoteClose, winners)
+		}
+	}
+	return nil
+}
+
+// mark marks the theme's transition, returning false if another run already
+// marked it.
+func (c *themeConductor) mark(transition mxtpdb.Transition) (bool, error) {
+	err := c.db.MarkTransition(c.leagueName, c.theme.Date, transition, c.now)
+	if err == mxtpdb.ErrTransitionDone {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	fmt.Printf("INFO: %v theme %v %v\n", c.leagueName, c.theme.Date, transition)
+	return true, nil
+}
+
+// notify sends the event to the league's hooks. Failed notifications are
+// logged but don't stop the theme advancing.
+func (c *themeConductor) notify(eventType notify.EventType, deadline time.Time, winners []string) {
+	event := notify.Event{
+		Type:             eventType,
+		League:           c.leagueName,
+		ThemeId:          c.theme.Date,
+		ThemeName:        c.theme.Name,
+		ThemeDescription: c.theme.Description,
+		Deadline:         deadline,
+		Winners:          winners,
+	}
+	if c.theme.SpotifyPlaylistId != "" {
+		event.PlaylistUrl = "https://open.spotify.com/playlist/" + c.theme.SpotifyPlaylistId
+	}
+
+	err := notify.Notify(c.hooks, event, notifyHTTPClient)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+	}
+}
+
+// buildPlaylist builds the theme's playlist with the league owner's account
+// and returns its id. Leagues without an owner don't have playlists.
+func buildPlaylist(ctx context.Context, db mxtpdb.Store, leagueName string, theme mxtpdb.Theme) (string, error) {
+	owner, err := mxtpdb.LeagueOwner(db, leagueName)
+	if err == mxtpdb.ErrNoOwner {
+		fmt.Printf("WARNING: league %v has no owner to build playlists with\n", leagueName)
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	provider, err := openMusic(ctx, db, owner)
+	if err != nil {
+		return "", err
+	}
+	playlistId, _, err := mixtape.BuildPlaylist(db, provider, leagueName, theme)
+	if errors.Is(err, music.ErrSpotifyReauth) {
+		return "", fmt.Errorf("owner %v needs to reconnect their Spotify account", owner)
+	}
+	return playlistId, err
+}
+
+func main() {
+	once := flag.Bool("once", false, "conduct every league once and exit instead of running as a lambda")
+	flag.Parse()
+
+	if !*once {
+		lambda.Start(ConductorHandler)
+		return
+	}
+
+	err := ConductorHandler(context.Background(), events.CloudWatchEvent{})
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/macintoshpie/mxtp-fx/music/spotifytest"
+	"github.com/macintoshpie/mxtp-fx/mxtpdb"
+	"github.com/macintoshpie/mxtp-fx/notify"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+// useLeague seeds a league owned by ted, with a theme in each phase, and
+// points Spotify clients at a fake server. Close the server when done with it.
+func useLeague(t *testing.T) (*mxtpdb.MemoryStore, *spotifytest.Server) {
+	store := mxtpdb.NewMemoryStore()
+	openStore = func() (mxtpdb.Store, error) { return store, nil }
+	server := spotifytest.NewServer()
+	spotifyHTTPClient = &http.Client{Transport: server.Transport()}
+
+	now := time.Now().UTC()
+	require.Nil(t, store.PutLeague(mxtpdb.League{Name: "devetry"}))
+	require.Nil(t, store.PutMember("devetry", "ted", mxtpdb.RoleOwner))
+	require.Nil(t, store.UpdateSpotifyToken(&oauth2.Token{AccessToken: "expired", RefreshToken: "refresh", Expiry: now.Add(-time.Hour)}, "ted"))
+	themes := map[string]int{"closed": -28, "vote": -14, "submit": 0, "scheduled": 14}
+	for name, days := range themes {
+		date := now.AddDate(0, 0, days).Format(mxtpdb.ThemeDateFormat)
+		require.Nil(t, store.PutTheme("devetry", mxtpdb.Theme{Name: name, Date: date}))
+		require.Nil(t, store.UpdateSong("devetry", date, "alice@devetry.com", "url", "sub-"+name, "track-"+name, name, nil))
+		require.Nil(t, store.UpdateVotes("devetry", date, "bob@devetry.com", []string{"sub-" + name}))
+	}
+	return store, server
+}
+
+func themeNamed(t *testing.T, store mxtpdb.Store, name string) mxtpdb.Theme {
+	themes, err := store.GetThemes("devetry")
+	require.Nil(t, err)
+	for _, theme := range themes {
+		if theme.Name == name {
+			return theme
+		}
+	}
+	t.Fatalf("no theme named %v", name)
+	return mxtpdb.Theme{}
+}
+
+func TestConductor(t *testing.T) {
+	store, server := useLeague(t)
+	defer server.Close()
+
+	require.Nil(t, ConductorHandler(context.Background(), events.CloudWatchEvent{}))
+
+	// each theme is marked up to its phase
+	scheduled := themeNamed(t, store, "scheduled")
+	require.True(t, scheduled.SubmitOpenedAt.IsZero())
+
+	submit := themeNamed(t, store, "submit")
+	require.False(t, submit.SubmitOpenedAt.IsZero())
+	require.True(t, submit.SubmitClosedAt.IsZero())
+	require.Empty(t, submit.SpotifyPlaylistId)
+
+	// the voting playlist is built once submissions close
+	vote := themeNamed(t, store, "vote")
+	require.False(t, vote.SubmitClosedAt.IsZero())
+	require.True(t, vote.VoteClosedAt.IsZero())
+	require.NotEmpty(t, vote.SpotifyPlaylistId)
+	playlist, ok := server.Playlist(vote.SpotifyPlaylistId)
+	require.True(t, ok)
+	require.Equal(t, []string{"track-vote"}, playlist.TrackIds)
+
+	// themes that closed before the conductor ran don't get a playlist
+	closed := themeNamed(t, store, "closed")
+	require.False(t, closed.SubmitClosedAt.IsZero())
+	require.False(t, closed.VoteClosedAt.IsZero())
+	require.Empty(t, closed.SpotifyPlaylistId)
+
+	// running again changes nothing
+	calls := len(server.Calls())
+	require.Nil(t, ConductorHandler(context.Background(), events.CloudWatchEvent{}))
+	require.Equal(t, calls, len(server.Calls()))
+	require.Equal(t, vote, themeNamed(t, store, "vote"))
+	require.Equal(t, closed, themeNamed(t, store, "closed"))
+}
+
+func TestConductorOpensVotingWithoutPlaylist(t *testing.T) {
+	store, server := useLeague(t)
+	defer server.Close()
+	hook, received := useHook(t, store)
+	defer hook.Close()
+	server.RevokeRefreshToken("refresh")
+
+	// the playlist can't be built, but the themes still advance
+	require.Nil(t, conductLeague(context.Background(), store, "devetry", time.Now()))
+	vote := themeNamed(t, store, "vote")
+	require.False(t, vote.SubmitClosedAt.IsZero())
+	require.False(t, vote.VoteOpenedAt.IsZero())
+	require.Empty(t, vote.SpotifyPlaylistId)
+	require.False(t, themeNamed(t, store, "submit").SubmitOpenedAt.IsZero())
+
+	events := received()
+	require.Equal(t, map[string]notify.EventType{
+		"submit": notify.EventThemeOpened,
+		"vote":   notify.EventVoteOpened,
+	}, eventTypes(events))
+	for _, event := range events {
+		require.Empty(t, event.PlaylistUrl)
+	}
+}
+
+// failingStore fails to mark transitions of one theme
+type failingStore struct {
+	*mxtpdb.MemoryStore
+	themeId string
+}
+
+func (s failingStore) MarkTransition(leagueName, themeId string, transition mxtpdb.Transition, at time.Time) error {
+	if themeId == s.themeId {
+		return errors.New("throttled")
+	}
+	return s.MemoryStore.MarkTransition(leagueName, themeId, transition, at)
+}
+
+func TestConductorContinuesPastFailedThemes(t *testing.T) {
+	store, server := useLeague(t)
+	defer server.Close()
+	db := failingStore{MemoryStore: store, themeId: themeNamed(t, store, "vote").Date}
+
+	require.NotNil(t, conductLeague(context.Background(), db, "devetry", time.Now()))
+	require.True(t, themeNamed(t, store, "vote").SubmitClosedAt.IsZero())
+	require.False(t, themeNamed(t, store, "submit").SubmitOpenedAt.IsZero())
+	require.False(t, themeNamed(t, store, "closed").VoteClosedAt.IsZero())
+}
+
+func TestConductorBuildsPlaylistOnce(t *testing.T) {
+	store, server := useLeague(t)
+	defer server.Close()
+
+	// overlapping runs saw submissions open and both try to close them
+	vote := themeNamed(t, store, "vote")
+	league, err := store.GetLeague("devetry")
+	require.Nil(t, err)
+	now := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.Nil(t, conductTheme(context.Background(), store, league, nil, vote, now))
+		}()
+	}
+	wg.Wait()
+
+	created := 0
+	for _, call := range server.Calls() {
+		if call.Method == http.MethodPost && strings.HasSuffix(call.Path, "/playlists") {
+			created++
+		}
+	}
+	require.Equal(t, 1, created)
+}
+
+func TestConductorWithoutOwner(t *testing.T) {
+	store, server := useLeague(t)
+	defer server.Close()
+	require.Nil(t, store.RemoveMember("devetry", "ted"))
+
+	require.Nil(t, conductLeague(context.Background(), store, "devetry", time.Now()))
+	vote := themeNamed(t, store, "vote")
+	require.False(t, vote.SubmitClosedAt.IsZero())
+	require.Empty(t, vote.SpotifyPlaylistId)
+	require.Empty(t, server.Calls())
+}
+
+// useHook adds a webhook to the league that records the events it's sent
+func useHook(t *testing.T, store *mxtpdb.MemoryStore) (*httptest.Server, func() []notify.Event) {
+	var mu sync.Mutex
+	var received []notify.Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if !notify.Verify([]byte("secret"), r.Header.Get(notify.HeaderTimestamp), body, r.Header.Get(notify.HeaderSignature)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var event notify.Event
+		require.Nil(t, json.Unmarshal(body, &event))
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, event)
+	}))
+	require.Nil(t, store.PutHook("devetry", mxtpdb.Hook{Id: "bot", Kind: notify.KindWebhook, Url: server.URL, Secret: "secret"}))
+
+	return server, func() []notify.Event {
+		mu.Lock()
+		defer mu.Unlock()
+		events := received
+		received = nil
+		return events
+	}
+}
+
+func eventTypes(events []notify.Event) map[string]notify.EventType {
+	types := map[string]notify.EventType{}
+	for _, event := range events {
+		types[event.ThemeName] = event.Type
+	}
+	return types
+}
+
+func TestConductorNotifies(t *testing.T) {
+	store, server := useLeague(t)
+	defer server.Close()
+	hook, received := useHook(t, store)
+	defer hook.Close()
+
+	// themes ending before the conductor first ran aren't announced
+	now := time.Now()
+	require.Nil(t, conductLeague(context.Background(), store, "devetry", now))
+	events := received()
+	require.Equal(t, map[string]notify.EventType{
+		"submit": notify.EventThemeOpened,
+		"vote":   notify.EventVoteOpened,
+	}, eventTypes(events))
+	for _, event := range events {
+		if event.Type == notify.EventVoteOpened {
+			require.Contains(t, event.PlaylistUrl, "https://open.spotify.com/playlist/")
+		}
+	}
+
+	// nothing is sent twice
+	require.Nil(t, conductLeague(context.Background(), store, "devetry", now))
+	require.Empty(t, received())
+
+	// submissions are about to close
+	require.Nil(t, conductLeague(context.Background(), store, "devetry", now.Add(13*24*time.Hour+time.Hour)))
+	require.Equal(t, map[string]notify.EventType{"submit": notify.EventSubmitClosing}, eventTypes(received()))
+
+	// a day later the next theme opens, voting opens for the last one and
+	// closes for the one before
+	require.Nil(t, conductLeague(context.Background(), store, "devetry", now.Add(14*24*time.Hour+time.Hour)))
+	events = received()
+	require.Equal(t, map[string]notify.EventType{
+		"scheduled": notify.EventThemeOpened,
+		"submit":    notify.EventVoteOpened,
+		"vote":      notify.EventResultsPublished,
+	}, eventTypes(events))
+	for _, event := range events {
+		if event.Type == notify.EventResultsPublished {
+			require.Equal(t, []string{"alice"}, event.Winners)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Package gateway serves API Gateway proxy handlers over plain net/http, so
+// lambda functions can run locally without the lambda runtime.
+package gateway
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Handler is the signature of an API Gateway proxy lambda handler. It gets
+// the HTTP request's context, which is cancelled if the client goes away.
+type Handler func(context.Context, events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error)
+
+// NewRequest converts an HTTP request into an API Gateway proxy request.
+// Bodies that aren't valid UTF-8 are base64 encoded, as API Gateway does for
+// binary content.
+func NewRequest(r *http.Request) (events.APIGatewayProxyRequest, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return events.APIGatewayProxyRequest{}, err
+	}
+
+	request := events.APIGatewayProxyRequest{
+		HTTPMethod:                      r.Method,
+		Path:                            r.URL.Path,
+		Headers:                         map[string]string{},
+		MultiValueHeaders:               map[string][]string{},
+		QueryStringParameters:           map[string]string{},
+		MultiValueQueryStringParameters: map[string][]string{},
+		RequestContext: events.APIGatewayProxyRequestContext{
+			HTTPMethod: r.Method,
+			Identity: events.APIGatewayRequestIdentity{
+				SourceIP:  sourceIP(r.RemoteAddr),
+				UserAgent: r.UserAgent(),
+			},
+		},
+	}
+
+	for name, values := range r.Header {
+		request.Headers[name] = strings.Join(values, ",")
+		request.MultiValueHeaders[name] = values
+	}
+	if r.Host != "" {
+		request.Headers["Host"] = r.Host
+		request.MultiValueHeaders["Host"] = []string{r.Host}
+	}
+
+	for name, values := range r.URL.Query() {
+		// like API Gateway, the single value map holds the last value
+		request.QueryStringParameters[name] = values[len(values)-1]
+		request.MultiValueQueryStringParameters[name] = values
+	}
+
+	if utf8.Valid(body) {
+		request.Body = string(body)
+	} else {
+		request.Body = base64.StdEncoding.EncodeToString(body)
+		request.IsBase64Encoded = true
+	}
+
+	return request, nil
+}
+
+func sourceIP(remoteAddr string) string {
+	if i := strings.LastIndex(remoteAddr, ":"); i >= 0 {
+		return strings.Trim(remoteAddr[:i], "[]")
+	}
+	return remoteAddr
+}
+
+// WriteResponse writes an API Gateway proxy response to w
+func WriteResponse(w http.ResponseWriter, response *events.APIGatewayProxyResponse) error {
+	body := []byte(response.Body)
+	if response.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(response.Body)
+		if err != nil {
+			return fmt.Errorf("failed to decode response body: %v", err)
+		}
+		body = decoded
+	}
+
+	header := w.Header()
+	for name, value := range response.Headers {
+		header.Set(name, value)
+	}
+	for name, values := range response.MultiValueHeaders {
+		header.Del(name)
+		for _, value := range values {
+			header.Add(name, value)
+		}
+	}
+
+	status := response.StatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		// these responses can't have a body
+		return nil
+	}
+	_, err := w.Write(body)
+	return err
+}
+
+type server struct {
+	handler Handler
+}
+
+// NewHandler returns an http.Handler that serves requests with the lambda
+// handler. Like API Gateway, it responds with a 502 if the handler fails.
+func NewHandler(handler Handler) http.Handler {
+	return &server{handler: handler}
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	request, err := NewRequest(r)
+	if err != nil {
+		fmt.Println("ERROR: failed to read request: ", err.Error())
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	response, err := s.handler(r.Context(), request)
+	if err != nil {
+		fmt.Println("ERROR: handler failed: ", err.Error())
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+	if response == nil {
+		fmt.Println("ERROR: handler returned no response")
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+
+	if err := WriteResponse(w, response); err != nil {
+		fmt.Println("ERROR: failed to write response: ", err.Error())
+	}
+}
@@ -0,0 +1,100 @@
+package gateway
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRequest(t *testing.T) {
+	r := httptest.NewRequest("POST", "http://localhost:8000/.netlify/functions/jockey/leagues?from=a&from=b", strings.NewReader(`{"a": 1}`))
+	r.Header.Add("Authorization", "Bearer token")
+	r.Header.Add("Accept", "text/plain")
+	r.Header.Add("Accept", "application/json")
+
+	request, err := NewRequest(r)
+	require.Nil(t, err)
+	require.Equal(t, "POST", request.HTTPMethod)
+	require.Equal(t, "/.netlify/functions/jockey/leagues", request.Path)
+	require.Equal(t, `{"a": 1}`, request.Body)
+	require.False(t, request.IsBase64Encoded)
+	require.Equal(t, "Bearer token", request.Headers["Authorization"])
+	require.Equal(t, "text/plain,application/json", request.Headers["Accept"])
+	require.Equal(t, []string{"text/plain", "application/json"}, request.MultiValueHeaders["Accept"])
+	require.Equal(t, "localhost:8000", request.Headers["Host"])
+	require.Equal(t, "b", request.QueryStringParameters["from"])
+	require.Equal(t, []string{"a", "b"}, request.MultiValueQueryStringParameters["from"])
+}
+
+func TestNewRequestBinaryBody(t *testing.T) {
+	body := []byte{0xff, 0xfe, 0x00}
+	r := httptest.NewRequest("PUT", "/upload", strings.NewReader(string(body)))
+
+	request, err := NewRequest(r)
+	require.Nil(t, err)
+	require.True(t, request.IsBase64Encoded)
+	require.Equal(t, base64.StdEncoding.EncodeToString(body), request.Body)
+}
+
+func TestWriteResponse(t *testing.T) {
+	w := httptest.NewRecorder()
+	err := WriteResponse(w, &events.APIGatewayProxyResponse{
+		StatusCode:        201,
+		Headers:           map[string]string{"Content-Type": "application/json"},
+		MultiValueHeaders: map[string][]string{"Set-Cookie": {"a=1", "b=2"}},
+		Body:              `{"ok": true}`,
+	})
+	require.Nil(t, err)
+	require.Equal(t, 201, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	require.Equal(t, []string{"a=1", "b=2"}, w.Header()["Set-Cookie"])
+	require.Equal(t, `{"ok": true}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	err = WriteResponse(w, &events.APIGatewayProxyResponse{
+		Body:            base64.StdEncoding.EncodeToString([]byte("binary")),
+		IsBase64Encoded: true,
+	})
+	require.Nil(t, err)
+	require.Equal(t, 200, w.Code)
+	require.Equal(t, "binary", w.Body.String())
+}
+
+func TestHandler(t *testing.T) {
+	server := httptest.NewServer(NewHandler(func(ctx context.Context, request events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
+		switch request.Path {
+		case "/fail":
+			return nil, errors.New("failed")
+		case "/nil":
+			return nil, nil
+		}
+		return &events.APIGatewayProxyResponse{
+			StatusCode: 200,
+			Body:       request.HTTPMethod + " " + request.Path + " " + request.Body,
+		}, nil
+	}))
+	defer server.Close()
+
+	res, err := http.Post(server.URL+"/echo", "text/plain", strings.NewReader("hello"))
+	require.Nil(t, err)
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+	require.Equal(t, "POST /echo hello", string(body))
+
+	for _, path := range []string{"/fail", "/nil"} {
+		res, err = http.Get(server.URL + path)
+		require.Nil(t, err)
+		res.Body.Close()
+		require.Equal(t, 502, res.StatusCode, path)
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/macintoshpie/mxtp-fx
 
-go 1.13
+go 1.18
 
 require (
 	github.com/aws/aws-lambda-go v1.16.0
@@ -12,3 +12,13 @@ require (
 	github.com/zmb3/spotify v0.0.0-20200422222148-5fe5f9535a2c
 	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
 )
+
+require (
+	github.com/cenkalti/backoff v2.1.1+incompatible // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/gofrs/uuid v3.2.0+incompatible // indirect
+	github.com/jmespath/go-jmespath v0.3.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/net v0.0.0-20200202094626-16171245cfb2 // indirect
+	gopkg.in/yaml.v2 v2.2.2 // indirect
+)
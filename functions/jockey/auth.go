@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/macintoshpie/mxtp-fx/authtoken"
+	"github.com/macintoshpie/mxtp-fx/bouncer"
+	"github.com/macintoshpie/mxtp-fx/mailer"
+	"github.com/macintoshpie/mxtp-fx/mxtpdb"
+)
+
+const loginNonceDuration = time.Minute * 15
+
+// openMailer returns the mailer used to send login links. Tests replace it to
+// capture messages.
+var openMailer = func() (mailer.Mailer, error) {
+	return mailer.FromEnv()
+}
+
+type LoginRequest struct {
+	Email string `validate:"required,max=254"`
+}
+
+type SessionRequest struct {
+	Nonce string `validate:"required"`
+}
+
+func newTokenResponse(token string, claims authtoken.Claims) *jsonResponse {
+	return &jsonResponse{
+		content: TokenResponse{
+			Token:     token,
+			Username:  claims.Subject,
+			ExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC(),
+		},
+		status: 200,
+	}
+}
+
+// normalizeEmail lowercases the address and checks it belongs to the domain
+// in LOGIN_EMAIL_DOMAIN, if set.
+func normalizeEmail(email string) (string, bool) {
+	address, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil || address.Address != strings.TrimSpace(email) {
+		return "", false
+	}
+
+	normalized := strings.ToLower(address.Address)
+	if strings.Contains(normalized, "#") {
+		return "", false
+	}
+	if domain := os.Getenv("LOGIN_EMAIL_DOMAIN"); domain != "" && !strings.HasSuffix(normalized, "@"+strings.ToLower(domain)) {
+		return "", false
+	}
+	return normalized, true
+}
+
+// isAdminEmail reports whether the user is listed in JOCKEY_ADMINS.
+func isAdminEmail(username string) bool {
+	for _, admin := range strings.Split(os.Getenv("JOCKEY_ADMINS"), ",") {
+		if strings.TrimSpace(strings.ToLower(admin)) == username {
+			return true
+		}
+	}
+	return false
+}
+
+func newLoginNonce() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// loginLink returns the link sent to users, pointing at LOGIN_LINK_BASE or the
+// site root.
+func loginLink(nonce string) string {
+	base := os.Getenv("LOGIN_LINK_BASE")
+	if base == "" {
+		base = "https://www.mxtp.xyz/"
+	}
+	return base + "?login=" + nonce
+}
+
+// postLoginHandler emails a single use login link to the address in the body.
+func postLoginHandler(c *bouncer.Context) (*events.APIGatewayProxyResponse, error) {
+	var loginRequest LoginRequest
+	if err := c.Bind(&loginRequest); err != nil {
+		return nil, err
+	}
+
+	email, ok := normalizeEmail(loginRequest.Email)
+	if !ok {
+		return nil, errBadRequest("Invalid email address")
+	}
+
+	db, err := storeFor(c)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := openMailer()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load mailer: %w", err)
+	}
+
+	nonce, err := newLoginNonce()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate login nonce: %w", err)
+	}
+
+	err = db.PutLoginNonce(email, nonce, time.Now().Add(loginNonceDuration))
+	if err != nil {
+		return nil, fmt.Errorf("failed to put login nonce: %w", err)
+	}
+
+	err = m.Send(mailer.Message{
+		To:      email,
+		Subject: "Your mxtp login link",
+		Body: fmt.Sprintf(
+			"Follow this link to log in to mxtp:\n\n%v\n\nThe link can only be used once and expires in %v minutes.\n",
+			loginLink(nonce),
+			int(loginNonceDuration.Minutes()),
+		),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to send login link: %w", err)
+	}
+
+	return newMessageResponse(200, "Login link sent").toAPIGatewayProxyResponse(), nil
+}
+
+// postSessionHandler exchanges a login nonce for an auth token.
+func postSessionHandler(c *bouncer.Context) (*events.APIGatewayProxyResponse, error) {
+	var sessionRequest SessionRequest
+	if err := c.Bind(&sessionRequest); err != nil {
+		return nil, err
+	}
+
+	db, err := storeFor(c)
+	if err != nil {
+		return nil, err
+	}
+
+	username, err := db.ConsumeLoginNonce(sessionRequest.Nonce)
+	if err == mxtpdb.ErrNotFound || err == mxtpdb.ErrNonceExpired {
+		return nil, errUnauthorized("Login link is invalid or expired")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume login nonce: %w", err)
+	}
+
+	signer, err := openSigner()
+	if err != nil {
+		return nil, err
+	}
+
+	token, claims, err := signer.Issue(username, isAdminEmail(username))
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue token: %w", err)
+	}
+
+	return newTokenResponse(token, claims).toAPIGatewayProxyResponse(), nil
+}
+
+// postRefreshTokenHandler exchanges a valid token for a new one signed with the
+// current key. The new token's admin claim is worked out again from
+// JOCKEY_ADMINS.
+func postRefreshTokenHandler(c *bouncer.Context) (*events.APIGatewayProxyResponse, error) {
+	token, ok := bearerToken(c.Request)
+	if !ok {
+		return nil, errUnauthorized("Missing Authorization header")
+	}
+	if c.Principal.Username == "" {
+		return nil, errUnauthorized("Invalid or expired token")
+	}
+
+	signer, err := openSigner()
+	if err != nil {
+		return nil, err
+	}
+
+	refreshed, claims, err := signer.Refresh(token, isAdminEmail)
+	if err != nil {
+		fmt.Println("WARNING: failed to refresh token: ", err.Error())
+		return nil, errUnauthorized("Invalid or expired token")
+	}
+
+	return newTokenResponse(refreshed, claims).toAPIGatewayProxyResponse(), nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/macintoshpie/mxtp-fx/mailer"
+	"github.com/stretchr/testify/require"
+)
+
+var loginLinkRegex = regexp.MustCompile(`\?login=([A-Za-z0-9_-]+)`)
+
+// captureMail makes login handlers write mail to the returned buffer.
+func captureMail() *bytes.Buffer {
+	var buf bytes.Buffer
+	openMailer = func() (mailer.Mailer, error) { return &mailer.WriterMailer{W: &buf}, nil }
+	return &buf
+}
+
+func postJSON(path, body string) events.APIGatewayProxyRequest {
+	return events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: jockeyBase + path, Body: body}
+}
+
+func TestMagicLinkLogin(t *testing.T) {
+	useMemoryStore(t)
+	mail := captureMail()
+	os.Setenv("LOGIN_EMAIL_DOMAIN", "devetry.com")
+	os.Setenv("JOCKEY_ADMINS", "ted@devetry.com")
+	defer os.Unsetenv("LOGIN_EMAIL_DOMAIN")
+	defer os.Unsetenv("JOCKEY_ADMINS")
+
+	res, err := JockeyHandler(postJSON("/auth/login", `{"Email": "Alice@Devetry.com"}`))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+	require.Contains(t, mail.String(), "To: alice@devetry.com")
+
+	match := loginLinkRegex.FindStringSubmatch(mail.String())
+	require.Len(t, match, 2)
+	res, err = JockeyHandler(postJSON("/auth/session", `{"Nonce": "`+match[1]+`"}`))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+
+	var tokenResponse TokenResponse
+	require.Nil(t, json.Unmarshal([]byte(res.Body), &tokenResponse))
+	require.Equal(t, "alice@devetry.com", tokenResponse.Username)
+	claims, err := testSigner.Verify(tokenResponse.Token)
+	require.Nil(t, err)
+	require.Equal(t, "alice@devetry.com", claims.Subject)
+	require.False(t, claims.Admin)
+
+	// the link only works once
+	res, err = JockeyHandler(postJSON("/auth/session", `{"Nonce": "`+match[1]+`"}`))
+	require.Nil(t, err)
+	require.Equal(t, 401, res.StatusCode)
+}
+
+func TestMagicLinkAdmin(t *testing.T) {
+	useMemoryStore(t)
+	mail := captureMail()
+	os.Setenv("JOCKEY_ADMINS", "ted@devetry.com")
+	defer os.Unsetenv("JOCKEY_ADMINS")
+
+	res, err := JockeyHandler(postJSON("/auth/login", `{"Email": "ted@devetry.com"}`))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+
+	match := loginLinkRegex.FindStringSubmatch(mail.String())
+	require.Len(t, match, 2)
+	res, err = JockeyHandler(postJSON("/auth/session", `{"Nonce": "`+match[1]+`"}`))
+	require.Nil(t, err)
+	var tokenResponse TokenResponse
+	require.Nil(t, json.Unmarshal([]byte(res.Body), &tokenResponse))
+	claims, err := testSigner.Verify(tokenResponse.Token)
+	require.Nil(t, err)
+	require.True(t, claims.Admin)
+}
+
+func TestMagicLinkRejectsOtherDomains(t *testing.T) {
+	useMemoryStore(t)
+	mail := captureMail()
+	os.Setenv("LOGIN_EMAIL_DOMAIN", "devetry.com")
+	defer os.Unsetenv("LOGIN_EMAIL_DOMAIN")
+
+	for _, email := range []string{"mallory@example.com", "not an email", "Mallory <mallory@devetry.com>"} {
+		res, err := JockeyHandler(postJSON("/auth/login", `{"Email": "`+email+`"}`))
+		require.Nil(t, err)
+		require.Equal(t, 400, res.StatusCode, email)
+	}
+	require.Empty(t, mail.String())
+}
+
+func TestSessionUnknownNonce(t *testing.T) {
+	useMemoryStore(t)
+
+	res, err := JockeyHandler(postJSON("/auth/session", `{"Nonce": "nope"}`))
+	require.Nil(t, err)
+	require.Equal(t, 401, res.StatusCode)
+
+	res, err = JockeyHandler(postJSON("/auth/session", `{}`))
+	require.Nil(t, err)
+	require.Equal(t, 400, res.StatusCode)
+}
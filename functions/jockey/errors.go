@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/macintoshpie/mxtp-fx/bouncer"
+	"github.com/macintoshpie/mxtp-fx/mxtpdb"
+)
+
+// Error codes tell clients what went wrong without parsing messages
+const (
+	CodeBadRequest       = "bad_request"
+	CodeInvalidRequest   = "invalid_request"
+	CodeVoteRules        = "vote_rules"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeConflict         = "conflict"
+	CodePhaseClosed      = "phase_closed"
+	CodeInternal         = "internal_error"
+)
+
+// ErrorResponse is the body of every error response. Details depend on the
+// code, e.g. the field errors of an invalid_request, and RequestId finds the
+// request in the logs.
+type ErrorResponse struct {
+	Code      string
+	Message   string
+	Details   interface{} `json:",omitempty"`
+	RequestId string      `json:",omitempty"`
+}
+
+// PhaseDetails are the details of a phase_closed error
+type PhaseDetails struct {
+	ThemeId  string
+	Required mxtpdb.Phase
+	Actual   mxtpdb.Phase
+}
+
+// APIError is an error whose message can be shown to the client
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+	Details interface{}
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+func errBadRequest(message string) *APIError {
+	return &APIError{Status: 400, Code: CodeBadRequest, Message: message}
+}
+
+func errUnauthorized(message string) *APIError {
+	return &APIError{Status: 401, Code: CodeUnauthorized, Message: message}
+}
+
+func errForbidden(message string) *APIError {
+	return &APIError{Status: 403, Code: CodeForbidden, Message: message}
+}
+
+func errNotFound(message string) *APIError {
+	return &APIError{Status: 404, Code: CodeNotFound, Message: message}
+}
+
+func errConflict(message string) *APIError {
+	return &APIError{Status: 409, Code: CodeConflict, Message: message}
+}
+
+// internalErrorBody is the body of an internal error that can't be marshalled
+const internalErrorBody = `{"Code": "internal_error", "Message": "Internal Server Error"}`
+
+var errInternal = &APIError{Status: 500, Code: CodeInternal, Message: "Internal Server Error"}
+
+// codeForStatus is the code of a bouncer.HTTPError
+func codeForStatus(status int) string {
+	switch {
+	case status == 401:
+		return CodeUnauthorized
+	case status == 403:
+		return CodeForbidden
+	case status == 404:
+		return CodeNotFound
+	case status == 405:
+		return CodeMethodNotAllowed
+	case status == 409:
+		return CodeConflict
+	case status >= 500:
+		return CodeInternal
+	default:
+		return CodeBadRequest
+	}
+}
+
+// toAPIError maps an error to what the client is told about it. Errors it
+// doesn't know are internal errors.
+func toAPIError(err error) *APIError {
+	var apiErr *APIError
+	var httpErr *bouncer.HTTPError
+	var bindErr *bouncer.BindError
+	var routeErr *bouncer.RouteError
+	var ruleErr *mxtpdb.VoteRuleError
+	var phaseErr *mxtpdb.PhaseError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.As(err, &httpErr):
+		return &APIError{Status: httpErr.StatusCode, Code: codeForStatus(httpErr.StatusCode), Message: httpErr.Message}
+	case errors.As(err, &bindErr):
+		return &APIError{Status: 400, Code: CodeInvalidRequest, Message: "Invalid request", Details: bindErr.Errors}
+	case errors.As(err, &routeErr):
+		return &APIError{Status: routeErr.StatusCode, Code: codeForStatus(routeErr.StatusCode), Message: routeErr.Error()}
+	case errors.As(err, &ruleErr):
+		return &APIError{Status: 400, Code: CodeVoteRules, Message: ruleErr.Error(), Details: ruleErr.Violations}
+	case errors.As(err, &phaseErr):
+		return &APIError{
+			Status:  409,
+			Code:    CodePhaseClosed,
+			Message: phaseErr.Error(),
+			Details: PhaseDetails{
+				ThemeId:  phaseErr.Theme.Date,
+				Required: phaseErr.Required,
+				Actual:   phaseErr.Actual,
+			},
+		}
+	case errors.Is(err, mxtpdb.ErrNotFound):
+		return errNotFound("Not found")
+	default:
+		return errInternal
+	}
+}
+
+// newErrorResponse responds to the request with the error. Internal errors are
+// logged with the request's id, and their cause is hidden from the client.
+func newErrorResponse(request events.APIGatewayProxyRequest, err error) *events.APIGatewayProxyResponse {
+	requestId := request.RequestContext.RequestID
+	apiErr := toAPIError(err)
+	if apiErr.Status >= 500 {
+		fmt.Printf("ERROR: request %v: %v\n", requestId, err.Error())
+	}
+
+	response := jsonResponse{
+		content: ErrorResponse{
+			Code:      apiErr.Code,
+			Message:   apiErr.Message,
+			Details:   apiErr.Details,
+			RequestId: requestId,
+		},
+		status: apiErr.Status,
+	}
+	apiResponse := response.toAPIGatewayProxyResponse()
+
+	var routeErr *bouncer.RouteError
+	if errors.As(err, &routeErr) && len(routeErr.Allow) > 0 {
+		apiResponse.Headers["Allow"] = strings.Join(routeErr.Allow, ", ")
+	}
+	return apiResponse
+}
+
+// errorResponse is the router's bouncer.ErrorMapper
+func errorResponse(c *bouncer.Context, err error) *events.APIGatewayProxyResponse {
+	return newErrorResponse(c.Request, err)
+}
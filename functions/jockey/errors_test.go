@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/macintoshpie/mxtp-fx/mxtpdb"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeError(t *testing.T, res *events.APIGatewayProxyResponse) ErrorResponse {
+	var errorResponse ErrorResponse
+	require.Nil(t, json.Unmarshal([]byte(res.Body), &errorResponse), res.Body)
+	return errorResponse
+}
+
+func withRequestId(request events.APIGatewayProxyRequest, requestId string) events.APIGatewayProxyRequest {
+	request.RequestContext.RequestID = requestId
+	return request
+}
+
+func TestRouteErrorsUseEnvelope(t *testing.T) {
+	useMemoryStore(t)
+
+	res, err := JockeyHandler(withRequestId(authedRequest("GET", "/nothing/here", "alice", ""), "req-404"))
+	require.Nil(t, err)
+	require.Equal(t, 404, res.StatusCode)
+	require.Equal(t, "*", res.Headers["Access-Control-Allow-Origin"])
+	body := decodeError(t, res)
+	require.Equal(t, CodeNotFound, body.Code)
+	require.Equal(t, "req-404", body.RequestId)
+
+	res, err = JockeyHandler(authedRequest("DELETE", "/leagues/devetry/themes/2020-01-01/votes", "alice", ""))
+	require.Nil(t, err)
+	require.Equal(t, 405, res.StatusCode)
+	require.Equal(t, "POST", res.Headers["Allow"])
+	require.Equal(t, CodeMethodNotAllowed, decodeError(t, res).Code)
+}
+
+func TestPhaseClosedError(t *testing.T) {
+	store, _ := useMemoryStore(t)
+	require.Nil(t, store.PutTheme("devetry", mxtpdb.Theme{Name: "old", Date: "2020-01-01"}))
+
+	res, err := JockeyHandler(authedRequest("POST", "/leagues/devetry/themes/2020-01-01/songs", "alice", `{"SongUrl": "https://youtu.be/dQw4w9WgXcQ"}`))
+	require.Nil(t, err)
+	require.Equal(t, 409, res.StatusCode)
+
+	var body struct {
+		Code    string
+		Details PhaseDetails
+	}
+	require.Nil(t, json.Unmarshal([]byte(res.Body), &body))
+	require.Equal(t, CodePhaseClosed, body.Code)
+	require.Equal(t, PhaseDetails{ThemeId: "2020-01-01", Required: mxtpdb.PhaseSubmit, Actual: mxtpdb.PhaseClosed}, body.Details)
+}
+
+func TestInternalErrorsHideCause(t *testing.T) {
+	useMemoryStore(t)
+	openStore = func() (mxtpdb.Store, error) { return nil, errors.New("table is on fire") }
+
+	res, err := JockeyHandler(withRequestId(authedRequest("GET", "/leagues/devetry/leaderboard", "alice", ""), "req-500"))
+	require.Nil(t, err)
+	require.Equal(t, 500, res.StatusCode)
+	require.Equal(t, ErrorResponse{Code: CodeInternal, Message: "Internal Server Error", RequestId: "req-500"}, decodeError(t, res))
+	require.NotContains(t, res.Body, "fire")
+}
+
+func TestToAPIError(t *testing.T) {
+	require.Equal(t, errNotFound("Not found"), toAPIError(mxtpdb.ErrNotFound))
+	require.Equal(t, errForbidden("Forbidden"), toAPIError(errForbidden("Forbidden")))
+	require.Equal(t, CodeVoteRules, toAPIError(&mxtpdb.VoteRuleError{}).Code)
+	require.Equal(t, errInternal, toAPIError(errors.New("boom")))
+}
+
+func TestPanicsUseEnvelope(t *testing.T) {
+	useMemoryStore(t)
+	openStore = func() (mxtpdb.Store, error) { panic("table is on fire") }
+
+	res, err := JockeyHandler(authedRequest("GET", "/leagues/devetry/leaderboard", "alice", ""))
+	require.Nil(t, err)
+	require.Equal(t, 500, res.StatusCode)
+	require.Equal(t, "*", res.Headers["Access-Control-Allow-Origin"])
+	require.Equal(t, CodeInternal, decodeError(t, res).Code)
+}
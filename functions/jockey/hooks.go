@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/macintoshpie/mxtp-fx/bouncer"
+	"github.com/macintoshpie/mxtp-fx/mxtpdb"
+	"github.com/macintoshpie/mxtp-fx/notify"
+)
+
+// HookRequest configures where a league's notifications are sent. Kind is one
+// of slack, discord or webhook, and webhooks need a Secret to sign events
+// with. Events lists the event types to send, or every event if empty.
+type HookRequest struct {
+	Kind   string `validate:"required"`
+	Url    string `validate:"required,url"`
+	Secret string `validate:"max=256"`
+	Events []string
+}
+
+// Hook is a league hook without its secret
+type Hook struct {
+	Id     string
+	Kind   string
+	Url    string
+	Events []string
+}
+
+type HooksResponse struct {
+	Hooks []Hook
+}
+
+func getHooksHandler(c *bouncer.Context) (*events.APIGatewayProxyResponse, error) {
+	db, err := storeFor(c)
+	if err != nil {
+		return nil, err
+	}
+
+	hooks, err := db.GetHooks(c.Parameters["leagueName"])
+	if err != nil {
+		return nil, err
+	}
+
+	redacted := []Hook{}
+	for _, hook := range hooks {
+		redacted = append(redacted, Hook{
+			Id:     hook.Id,
+			Kind:   hook.Kind,
+			Url:    hook.Url,
+			Events: hook.Events,
+		})
+	}
+
+	response := jsonResponse{
+		content: HooksResponse{
+			Hooks: redacted,
+		},
+		status: 200,
+	}
+	return response.toAPIGatewayProxyResponse(), nil
+}
+
+func putHookHandler(c *bouncer.Context) (*events.APIGatewayProxyResponse, error) {
+	var hookRequest HookRequest
+	if err := c.Bind(&hookRequest); err != nil {
+		return nil, err
+	}
+
+	hook := mxtpdb.Hook{
+		Id:     c.Parameters["hookId"],
+		Kind:   hookRequest.Kind,
+		Url:    hookRequest.Url,
+		Secret: hookRequest.Secret,
+		Events: hookRequest.Events,
+	}
+	err := notify.ValidateHook(hook)
+	if err != nil {
+		return nil, errBadRequest(err.Error())
+	}
+
+	db, err := storeFor(c)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.PutHook(c.Parameters["leagueName"], hook)
+	if err != nil {
+		fmt.Println("ERROR: failed to put hook: ", err.Error())
+		return nil, errBadRequest("Bad hook")
+	}
+
+	return newMessageResponse(200, "Successfully put hook").toAPIGatewayProxyResponse(), nil
+}
+
+func deleteHookHandler(c *bouncer.Context) (*events.APIGatewayProxyResponse, error) {
+	db, err := storeFor(c)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.DeleteHook(c.Parameters["leagueName"], c.Parameters["hookId"])
+	if err == mxtpdb.ErrNotFound {
+		return nil, errNotFound("Hook not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete hook: %w", err)
+	}
+
+	return newMessageResponse(200, "Successfully deleted hook").toAPIGatewayProxyResponse(), nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/macintoshpie/mxtp-fx/mxtpdb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeagueAdminsManageHooks(t *testing.T) {
+	store, _ := useMemoryStore(t)
+	require.Nil(t, store.PutMember("devetry", "adam", mxtpdb.RoleAdmin))
+	require.Nil(t, store.PutMember("devetry", "mia", mxtpdb.RoleMember))
+
+	res, err := JockeyHandler(authedRequest("PUT", "/leagues/devetry/hooks/bot", "adam", `{"Kind": "webhook", "Url": "https://bot.example.com/mxtp", "Secret": "shh", "Events": ["vote.opened"]}`))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+
+	// members can't manage hooks
+	res, err = JockeyHandler(authedRequest("PUT", "/leagues/devetry/hooks/slack", "mia", `{"Kind": "slack", "Url": "https://hooks.slack.com/services/x"}`))
+	require.Nil(t, err)
+	require.Equal(t, 403, res.StatusCode)
+
+	// webhooks need a secret
+	res, err = JockeyHandler(authedRequest("PUT", "/leagues/devetry/hooks/unsigned", "adam", `{"Kind": "webhook", "Url": "https://bot.example.com/mxtp"}`))
+	require.Nil(t, err)
+	require.Equal(t, 400, res.StatusCode)
+
+	// secrets aren't returned
+	res, err = JockeyHandler(authedRequest("GET", "/leagues/devetry/hooks", "adam", ""))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+	require.NotContains(t, res.Body, "shh")
+	var hooks HooksResponse
+	require.Nil(t, json.Unmarshal([]byte(res.Body), &hooks))
+	require.Equal(t, []Hook{{Id: "bot", Kind: "webhook", Url: "https://bot.example.com/mxtp", Events: []string{"vote.opened"}}}, hooks.Hooks)
+
+	stored, err := store.GetHooks("devetry")
+	require.Nil(t, err)
+	require.Equal(t, "shh", stored[0].Secret)
+
+	res, err = JockeyHandler(authedRequest("DELETE", "/leagues/devetry/hooks/bot", "adam", ""))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+	res, err = JockeyHandler(authedRequest("DELETE", "/leagues/devetry/hooks/bot", "adam", ""))
+	require.Nil(t, err)
+	require.Equal(t, 404, res.StatusCode)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/macintoshpie/mxtp-fx/bouncer"
+	"github.com/macintoshpie/mxtp-fx/mxtpdb"
+)
+
+type LeagueRequest struct {
+	Description string `validate:"max=1000"`
+	// SubmitDays and VoteDays set the league's default phase lengths
+	SubmitDays int `validate:"min=0"`
+	VoteDays   int `validate:"min=0"`
+	// MinVotes and MaxVotes bound how many songs each user votes for, where 0
+	// is unbounded
+	MinVotes       int `validate:"min=0"`
+	MaxVotes       int `validate:"min=0"`
+	AllowSelfVotes bool
+}
+
+// ThemeRequest describes a theme. Unset phase timestamps follow the league's
+// cadence, starting at midnight UTC on the theme's date.
+type ThemeRequest struct {
+	Name        string `validate:"required,max=200"`
+	Description string `validate:"max=2000"`
+	SubmitOpen  time.Time
+	SubmitClose time.Time
+	VoteOpen    time.Time
+	VoteClose   time.Time
+}
+
+type ThemesResponse struct {
+	Themes []mxtpdb.Theme
+}
+
+// ThemePlaylist is the playlist of a theme's submissions
+type ThemePlaylist struct {
+	ThemeId     string
+	Name        string
+	Description string
+	PlaylistId  string
+	Url         string
+}
+
+type PlaylistsResponse struct {
+	Playlists []ThemePlaylist
+}
+
+// putLeagueHandler updates a league's info, or creates a new league owned by
+// the requesting user. Only league admins can update a league and only users
+// with the admin claim can create one.
+func putLeagueHandler(c *bouncer.Context) (*events.APIGatewayProxyResponse, error) {
+	username, err := requireUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	leagueName := c.Parameters["leagueName"]
+	if strings.Contains(leagueName, "#") {
+		return nil, errBadRequest("League name must not contain '#'")
+	}
+
+	var leagueRequest LeagueRequest
+	if err := c.Bind(&leagueRequest); err != nil {
+		return nil, err
+	}
+	if leagueRequest.MaxVotes > 0 && leagueRequest.MinVotes > leagueRequest.MaxVotes {
+		return nil, errBadRequest("MinVotes must not be more than MaxVotes")
+	}
+
+	db, err := storeFor(c)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.GetLeague(leagueName)
+	isNew := err == mxtpdb.ErrNotFound
+	if err != nil && !isNew {
+		return nil, err
+	}
+
+	if isNew {
+		if !c.Principal.Admin {
+			return nil, errForbidden("Forbidden")
+		}
+	} else {
+		role, err := leagueRole(db, c.Parameters)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get league role: %w", err)
+		}
+		if !canAdminister(role) {
+			return nil, errForbidden("Forbidden")
+		}
+	}
+
+	err = db.PutLeague(mxtpdb.League{
+		Name:           leagueName,
+		Description:    leagueRequest.Description,
+		SubmitDays:     leagueRequest.SubmitDays,
+		VoteDays:       leagueRequest.VoteDays,
+		MinVotes:       leagueRequest.MinVotes,
+		MaxVotes:       leagueRequest.MaxVotes,
+		AllowSelfVotes: leagueRequest.AllowSelfVotes,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to put league: %w", err)
+	}
+
+	if isNew {
+		err = db.PutMember(leagueName, username, mxtpdb.RoleOwner)
+		if err != nil {
+			return nil, fmt.Errorf("failed to put league owner: %w", err)
+		}
+	}
+
+	return newMessageResponse(200, "Successfully put league").toAPIGatewayProxyResponse(), nil
+}
+
+// leagueThemes returns the league and its themes with their full schedules
+func leagueThemes(db mxtpdb.Store, leagueName string) (mxtpdb.League, []mxtpdb.Theme, error) {
+	league, err := db.GetLeague(leagueName)
+	if err == mxtpdb.ErrNotFound {
+		return mxtpdb.League{}, nil, errNotFound("League not found")
+	}
+	if err != nil {
+		return mxtpdb.League{}, nil, err
+	}
+
+	themes, err := db.GetThemes(leagueName)
+	if err != nil {
+		return mxtpdb.League{}, nil, err
+	}
+	for i, theme := range themes {
+		themes[i], err = league.ScheduleTheme(theme)
+		if err != nil {
+			return mxtpdb.League{}, nil, fmt.Errorf("failed to schedule theme: %w", err)
+		}
+	}
+	return league, themes, nil
+}
+
+// getThemesHandler returns every theme in the league with its full schedule.
+func getThemesHandler(c *bouncer.Context) (*events.APIGatewayProxyResponse, error) {
+	db, err := storeFor(c)
+	if err != nil {
+		return nil, err
+	}
+
+	_, themes, err := leagueThemes(db, c.Parameters["leagueName"])
+	if err != nil {
+		return nil, err
+	}
+
+	response := jsonResponse{
+		content: ThemesResponse{
+			Themes: themes,
+		},
+		status: 200,
+	}
+	return response.toAPIGatewayProxyResponse(), nil
+}
+
+// getPlaylistsHandler returns the playlists of the league's themes whose
+// submissions have closed, newest first. It doesn't require a user so the
+// site can link to past mixtapes.
+func getPlaylistsHandler(c *bouncer.Context) (*events.APIGatewayProxyResponse, error) {
+	db, err := storeFor(c)
+	if err != nil {
+		return nil, err
+	}
+
+	_, themes, err := leagueThemes(db, c.Parameters["leagueName"])
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	playlists := []ThemePlaylist{}
+	for i := len(themes) - 1; i >= 0; i-- {
+		theme := themes[i]
+		if theme.SpotifyPlaylistId == "" {
+			continue
+		}
+		if phase := theme.PhaseAt(now); phase == mxtpdb.PhaseScheduled || phase == mxtpdb.PhaseSubmit {
+			continue
+		}
+
+		playlists = append(playlists, ThemePlaylist{
+			ThemeId:     theme.Date,
+			Name:        theme.Name,
+			Description: theme.Description,
+			PlaylistId:  theme.SpotifyPlaylistId,
+			Url:         "https://open.spotify.com/playlist/" + theme.SpotifyPlaylistId,
+		})
+	}
+
+	response := jsonResponse{
+		content: PlaylistsResponse{
+			Playlists: playlists,
+		},
+		status: 200,
+	}
+	return response.toAPIGatewayProxyResponse(), nil
+}
+
+func putThemeHandler(c *bouncer.Context) (*events.APIGatewayProxyResponse, error) {
+	leagueName := c.Parameters["leagueName"]
+	themeId := c.Parameters["themeId"]
+	if _, err := time.Parse(mxtpdb.ThemeDateFormat, themeId); err != nil {
+		return nil, errBadRequest("Theme id must be a date formatted as " + mxtpdb.ThemeDateFormat)
+	}
+
+	var themeRequest ThemeRequest
+	if err := c.Bind(&themeRequest); err != nil {
+		return nil, err
+	}
+
+	db, err := storeFor(c)
+	if err != nil {
+		return nil, err
+	}
+
+	league, err := db.GetLeague(leagueName)
+	if err == mxtpdb.ErrNotFound {
+		return nil, errNotFound("League not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// a theme being edited keeps its playlist and transitions
+	theme, err := db.GetTheme(leagueName, themeId)
+	if err != nil && err != mxtpdb.ErrNotFound {
+		return nil, err
+	}
+
+	// only the explicit timestamps are stored so the rest keep following the
+	// league's cadence if it changes
+	theme.Name = themeRequest.Name
+	theme.Description = themeRequest.Description
+	theme.Date = themeId
+	theme.SubmitOpen = themeRequest.SubmitOpen
+	theme.SubmitClose = themeRequest.SubmitClose
+	theme.VoteOpen = themeRequest.VoteOpen
+	theme.VoteClose = themeRequest.VoteClose
+	if _, err := league.ScheduleTheme(theme); err != nil {
+		return nil, errBadRequest(err.Error())
+	}
+
+	err = db.PutTheme(leagueName, theme)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put theme: %w", err)
+	}
+
+	return newMessageResponse(200, "Successfully put theme").toAPIGatewayProxyResponse(), nil
+}
+
+func deleteThemeHandler(c *bouncer.Context) (*events.APIGatewayProxyResponse, error) {
+	db, err := storeFor(c)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.DeleteTheme(c.Parameters["leagueName"], c.Parameters["themeId"])
+	if err == mxtpdb.ErrNotFound {
+		return nil, errNotFound("Theme not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete theme: %w", err)
+	}
+
+	return newMessageResponse(200, "Successfully deleted theme").toAPIGatewayProxyResponse(), nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/macintoshpie/mxtp-fx/bouncer"
+	"github.com/macintoshpie/mxtp-fx/mxtpdb"
+	"github.com/stretchr/testify/require"
+)
+
+func adminRequest(method, path, body string) events.APIGatewayProxyRequest {
+	return bearerRequest(method, path, issueToken("ted@devetry.com", true), body)
+}
+
+func TestLeagueAdministration(t *testing.T) {
+	store := mxtpdb.NewMemoryStore()
+	openStore = func() (mxtpdb.Store, error) { return store, nil }
+
+	res, err := JockeyHandler(adminRequest("PUT", "/leagues/newleague", `{"Description": "a new league"}`))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+
+	today := time.Now().UTC().Format(mxtpdb.ThemeDateFormat)
+	nextWeek := time.Now().UTC().AddDate(0, 0, 7).Format(mxtpdb.ThemeDateFormat)
+	res, err = JockeyHandler(adminRequest("PUT", "/leagues/newleague/themes/"+today, `{"Name": "now", "Description": "current"}`))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+	res, err = JockeyHandler(adminRequest("PUT", "/leagues/newleague/themes/"+nextWeek, `{"Name": "later"}`))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+
+	res, err = JockeyHandler(adminRequest("GET", "/leagues/newleague/themes", ""))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+	var themes ThemesResponse
+	require.Nil(t, json.Unmarshal([]byte(res.Body), &themes))
+	require.Len(t, themes.Themes, 2)
+	require.Equal(t, "now", themes.Themes[0].Name)
+	require.Equal(t, "current", themes.Themes[0].Description)
+	require.Equal(t, today, themes.Themes[0].Date)
+	require.Equal(t, "later", themes.Themes[1].Name)
+	require.Equal(t, nextWeek, themes.Themes[1].Date)
+	// themes are returned with their full schedule
+	require.Equal(t, today, themes.Themes[0].SubmitOpen.Format(mxtpdb.ThemeDateFormat))
+	require.Equal(t, themes.Themes[0].SubmitOpen.AddDate(0, 0, mxtpdb.DefaultSubmitDays), themes.Themes[0].SubmitClose)
+
+	// scheduled themes don't become the submit theme until they start
+	league, err := store.GetLeague("newleague")
+	require.Nil(t, err)
+	require.Equal(t, "a new league", league.Description)
+	require.Equal(t, "now", league.SubmitTheme.Name)
+
+	res, err = JockeyHandler(adminRequest("DELETE", "/leagues/newleague/themes/"+nextWeek, ""))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+	res, err = JockeyHandler(adminRequest("DELETE", "/leagues/newleague/themes/"+nextWeek, ""))
+	require.Nil(t, err)
+	require.Equal(t, 404, res.StatusCode)
+}
+
+func TestThemeSchedule(t *testing.T) {
+	store, _ := useMemoryStore(t)
+
+	res, err := JockeyHandler(adminRequest("PUT", "/leagues/devetry", `{"SubmitDays": 7, "VoteDays": 3}`))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+
+	res, err = JockeyHandler(adminRequest("PUT", "/leagues/devetry/themes/2020-01-01", `{"Name": "custom", "VoteOpen": "2020-01-09T12:00:00Z"}`))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+
+	// only the explicit timestamp is stored
+	theme, err := store.GetTheme("devetry", "2020-01-01")
+	require.Nil(t, err)
+	require.True(t, theme.SubmitClose.IsZero())
+	require.Equal(t, "2020-01-09T12:00:00Z", theme.VoteOpen.Format(time.RFC3339))
+
+	league, err := store.GetLeague("devetry")
+	require.Nil(t, err)
+	theme, err = league.ScheduleTheme(theme)
+	require.Nil(t, err)
+	require.Equal(t, "2020-01-08T00:00:00Z", theme.SubmitClose.Format(time.RFC3339))
+	require.Equal(t, "2020-01-12T12:00:00Z", theme.VoteClose.Format(time.RFC3339))
+
+	// voting can't open before submissions close
+	res, err = JockeyHandler(adminRequest("PUT", "/leagues/devetry/themes/2020-01-01", `{"Name": "custom", "VoteOpen": "2020-01-02T00:00:00Z"}`))
+	require.Nil(t, err)
+	require.Equal(t, 400, res.StatusCode)
+
+	res, err = JockeyHandler(adminRequest("PUT", "/leagues/devetry", `{"SubmitDays": -1}`))
+	require.Nil(t, err)
+	require.Equal(t, 400, res.StatusCode)
+}
+
+func TestGetPlaylists(t *testing.T) {
+	store, today := useMemoryStore(t)
+	lastTheme := time.Now().UTC().AddDate(0, 0, -mxtpdb.DefaultSubmitDays).Format(mxtpdb.ThemeDateFormat)
+	require.Nil(t, store.PutTheme("devetry", mxtpdb.Theme{Name: "first", Date: "2020-01-01", SpotifyPlaylistId: "first-playlist"}))
+	require.Nil(t, store.PutTheme("devetry", mxtpdb.Theme{Name: "skipped", Date: "2020-02-01"}))
+	require.Nil(t, store.SetThemePlaylist("devetry", lastTheme, "vote-playlist"))
+	require.Nil(t, store.SetThemePlaylist("devetry", today, "submit-playlist"))
+
+	// no user is needed
+	res, err := JockeyHandler(events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: jockeyBase + "/leagues/devetry/playlists"})
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+
+	// playlists still taking submissions aren't included
+	var response PlaylistsResponse
+	require.Nil(t, json.Unmarshal([]byte(res.Body), &response))
+	require.Equal(t, []ThemePlaylist{
+		{ThemeId: lastTheme, Name: "vote", PlaylistId: "vote-playlist", Url: "https://open.spotify.com/playlist/vote-playlist"},
+		{ThemeId: "2020-01-01", Name: "first", PlaylistId: "first-playlist", Url: "https://open.spotify.com/playlist/first-playlist"},
+	}, response.Playlists)
+
+	// editing a theme keeps its playlist
+	res, err = JockeyHandler(adminRequest("PUT", "/leagues/devetry/themes/2020-01-01", `{"Name": "renamed"}`))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+	theme, err := store.GetTheme("devetry", "2020-01-01")
+	require.Nil(t, err)
+	require.Equal(t, "first-playlist", theme.SpotifyPlaylistId)
+
+	res, err = JockeyHandler(events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: jockeyBase + "/leagues/missing/playlists"})
+	require.Nil(t, err)
+	require.Equal(t, 404, res.StatusCode)
+}
+
+func TestThemeValidation(t *testing.T) {
+	useMemoryStore(t)
+
+	res, err := JockeyHandler(adminRequest("PUT", "/leagues/devetry/themes/tomorrow", `{"Name": "bad date"}`))
+	require.Nil(t, err)
+	require.Equal(t, 400, res.StatusCode)
+
+	res, err = JockeyHandler(adminRequest("PUT", "/leagues/devetry/themes/2020-01-01", `{"Description": "no name"}`))
+	require.Nil(t, err)
+	require.Equal(t, 400, res.StatusCode)
+	var fieldErrors struct {
+		Code    string
+		Details []bouncer.FieldError
+	}
+	require.Nil(t, json.Unmarshal([]byte(res.Body), &fieldErrors))
+	require.Equal(t, CodeInvalidRequest, fieldErrors.Code)
+	require.Equal(t, []bouncer.FieldError{{Field: "Name", Message: "Name is required"}}, fieldErrors.Details)
+
+	res, err = JockeyHandler(adminRequest("PUT", "/leagues/devetry/themes/2020-01-01", `{"Name": 2020}`))
+	require.Nil(t, err)
+	require.Equal(t, 400, res.StatusCode)
+	require.Nil(t, json.Unmarshal([]byte(res.Body), &fieldErrors))
+	require.Equal(t, []bouncer.FieldError{{Field: "Name", Message: "Name must be a string"}}, fieldErrors.Details)
+
+	res, err = JockeyHandler(adminRequest("PUT", "/leagues/missing/themes/2020-01-01", `{"Name": "no league"}`))
+	require.Nil(t, err)
+	require.Equal(t, 404, res.StatusCode)
+}
+
+func TestLeagueAdministrationRequiresAdmin(t *testing.T) {
+	useMemoryStore(t)
+
+	res, err := JockeyHandler(authedRequest("PUT", "/leagues/devetry", "alice", `{}`))
+	require.Nil(t, err)
+	require.Equal(t, 403, res.StatusCode)
+
+	res, err = JockeyHandler(postJSON("/leagues/devetry/themes", ""))
+	require.Nil(t, err)
+	require.Equal(t, 405, res.StatusCode)
+
+	res, err = JockeyHandler(bearerRequest("GET", "/leagues/devetry/themes", "", ""))
+	require.Nil(t, err)
+	require.Equal(t, 401, res.StatusCode)
+}
@@ -1,21 +1,26 @@
 package main
 
 import (
-	"encoding/base64"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"math/rand"
-	"net/url"
+	"net/http"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/google/uuid"
 	"github.com/macintoshpie/mxtp-fx/bouncer"
+	"github.com/macintoshpie/mxtp-fx/gateway"
+	"github.com/macintoshpie/mxtp-fx/mixtape"
+	"github.com/macintoshpie/mxtp-fx/music"
+	"github.com/macintoshpie/mxtp-fx/musiclink"
 	"github.com/macintoshpie/mxtp-fx/mxtpdb"
-	"github.com/zmb3/spotify"
+	"golang.org/x/oauth2"
 )
 
 var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
@@ -29,11 +34,34 @@ func randSeq(n int) string {
 	return string(b)
 }
 
+// openStore returns the store used by the handlers. Tests replace it with an
+// in-memory store.
+var openStore = func() (mxtpdb.Store, error) {
+	db, err := mxtpdb.New()
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// storeFor opens the store for the request, so its calls stop when the
+// request's context is done
+func storeFor(c *bouncer.Context) (mxtpdb.Store, error) {
+	db, err := openStore()
+	if err != nil {
+		return nil, err
+	}
+	return db.WithContext(c), nil
+}
+
 type Game struct {
 	League           mxtpdb.League
 	SubmitThemeItems mxtpdb.ThemeItems
 	VoteThemeItems   mxtpdb.ThemeItems
 	SpotifyAuthUrl   string
+	// SpotifyReauthRequired is set for admins when the league owner's Spotify
+	// access was revoked
+	SpotifyReauthRequired bool `json:",omitempty"`
 }
 
 type jsonResponse struct {
@@ -41,10 +69,39 @@ type jsonResponse struct {
 	status  int
 }
 
+// BuildPlaylistRequest picks the theme whose playlist is built. It's the
+// theme open for submissions unless ThemeId is given, e.g. for a voting theme
+// whose playlist the conductor couldn't build.
+type BuildPlaylistRequest struct {
+	ThemeId string `validate:"max=100"`
+}
+
+// PlaylistSyncResponse reports the changes made to a theme's playlist
+type PlaylistSyncResponse struct {
+	PlaylistId string
+	Report     music.SyncReport
+}
+
 type MessageResponse struct {
 	Message string
 }
 
+// SongRequest submits a song by its Spotify, YouTube, Apple Music,
+// SoundCloud or Bandcamp link, as accepted by musiclink.ParseTrack
+type SongRequest struct {
+	SongUrl string `validate:"required,max=2048"`
+}
+
+type VotesRequest struct {
+	SubmissionIds []string
+}
+
+type TokenResponse struct {
+	Token     string
+	Username  string
+	ExpiresAt time.Time
+}
+
 func newMessageResponse(status int, message string) *jsonResponse {
 	return &jsonResponse{
 		content: MessageResponse{
@@ -55,17 +112,12 @@ func newMessageResponse(status int, message string) *jsonResponse {
 }
 
 func (response *jsonResponse) toAPIGatewayProxyResponse() *events.APIGatewayProxyResponse {
-	// TODO: restrict origins
 	defaultHeaders := make(map[string]string)
-	defaultHeaders["Access-Control-Allow-Origin"] = "*"
-	defaultHeaders["Access-Control-Allow-Headers"] = "*"
-	defaultHeaders["Access-Control-Allow-Methods"] = "POST, GET, OPTIONS, DELETE"
-	defaultHeaders["Access-Control-Max-Age"] = "86400"
 	bytes, err := json.MarshalIndent(response.content, "", "    ")
 	if err != nil {
 		fmt.Println("ERROR: failed to marshal content: ", err.Error())
 		return &events.APIGatewayProxyResponse{
-			Body:       "Internal server error",
+			Body:       internalErrorBody,
 			StatusCode: 500,
 			Headers:    defaultHeaders,
 		}
@@ -78,127 +130,78 @@ func (response *jsonResponse) toAPIGatewayProxyResponse() *events.APIGatewayProx
 	}
 }
 
-func authMiddleware(handler bouncer.ApiHandler) bouncer.ApiHandler {
-	return func(parameters map[string]string, request events.APIGatewayProxyRequest) *events.APIGatewayProxyResponse {
-		authHeader := strings.TrimSpace(request.Headers["authorization"])
-		if authHeader == "" {
-			authHeader = strings.TrimSpace(request.Headers["Authorization"])
-		}
-		authParts := strings.Fields(authHeader)
-		fmt.Println("Auth header: ", authHeader)
-		fmt.Println("Auth parts: ", authParts)
-		if len(authParts) != 2 || strings.ToLower(authParts[0]) != "bearer" {
-			fmt.Println("WARNING: auth header was not what was expected")
-			return handler(parameters, request)
-		}
-		authHeaderDecodedBytes, err := base64.StdEncoding.DecodeString(authParts[1])
-		if err != nil {
-			fmt.Println("ERROR: failed to decode authorization header: ", err.Error())
-			return handler(parameters, request)
-		}
-
-		authHeader = string(authHeaderDecodedBytes)
-		if authHeader == "" {
-			return handler(parameters, request)
-		}
-
-		parameters["username"] = authHeader
-		// TODO: remove this hack once proper auth tokens are implemented
-		if authHeader != "" && authHeader == os.Getenv("JOCKEY_SECRET") {
-			parameters["ADMIN"] = "indeed"
-		}
-		return handler(parameters, request)
+// scheduledTheme gets a theme with any unset phase timestamps filled in from
+// its league's cadence
+func scheduledTheme(db mxtpdb.Store, leagueName string, themeId string) (mxtpdb.Theme, error) {
+	league, err := db.GetLeague(leagueName)
+	if err != nil {
+		return mxtpdb.Theme{}, err
 	}
-}
-
-const oneWeekTime = time.Hour * 24 * 7
-const themeDuration = oneWeekTime * 2
-
-// themeInSubmitPhase determines if a theme is in the song submission phase
-func themeInSubmitPhase(themeId string) bool {
-	themeStartDate, err := time.Parse("2006-01-02", themeId)
+	theme, err := db.GetTheme(leagueName, themeId)
 	if err != nil {
-		return false
+		return mxtpdb.Theme{}, err
 	}
-
-	themeEndDate := themeStartDate.Add(themeDuration)
-	now := time.Now()
-	return (now.After(themeStartDate) || now.Equal(themeStartDate)) &&
-		(now.Before(themeEndDate) || now.Equal(themeEndDate))
+	return league.ScheduleTheme(theme)
 }
 
-func postSongsHandler(parameters map[string]string, request events.APIGatewayProxyRequest) *events.APIGatewayProxyResponse {
-	username := parameters["username"]
-	if username == "" {
-		return newMessageResponse(400, "Invalid Authorization header").toAPIGatewayProxyResponse()
+// requirePhase returns an error if the theme doesn't exist, or a
+// *mxtpdb.PhaseError if it isn't in the given phase
+func requirePhase(db mxtpdb.Store, leagueName string, themeId string, phase mxtpdb.Phase) error {
+	theme, err := scheduledTheme(db, leagueName, themeId)
+	if err == mxtpdb.ErrNotFound {
+		return errNotFound("Theme not found")
 	}
-
-	leagueName := parameters["leagueName"]
-	if leagueName == "" {
-		fmt.Println("ERROR: Parameter 'leagueName' not found")
-		return newMessageResponse(500, "Internal Server Error").toAPIGatewayProxyResponse()
+	if err != nil {
+		return err
 	}
 
-	themeId := parameters["themeId"]
-	if themeId == "" {
-		fmt.Println("ERROR: Parameter 'themeId' not found")
-		return newMessageResponse(500, "Internal Server Error").toAPIGatewayProxyResponse()
-	}
+	return theme.RequirePhase(phase, time.Now())
+}
+
+// skipTrackLookup determines if a song can be saved without its track details
+// because the owner's Spotify account can't be used
+func skipTrackLookup(err error) bool {
+	return errors.Is(err, ErrSpotifyReauth) || errors.Is(err, ErrNoSpotifyAccount)
+}
 
+// submitSong puts the user's song for the theme, looking up Spotify tracks'
+// details with the league owner's account. It is shared by the API and Slack
+// commands.
+func submitSong(ctx context.Context, db mxtpdb.Store, leagueName, themeId, username, songUrl string) error {
 	// verify the song can still be updated
-	if !themeInSubmitPhase(themeId) {
-		return newMessageResponse(400, "Submission phase for theme is over").toAPIGatewayProxyResponse()
+	if err := requirePhase(db, leagueName, themeId, mxtpdb.PhaseSubmit); err != nil {
+		return err
 	}
 
-	db, err := mxtpdb.New()
+	link, err := musiclink.ParseTrack(songUrl)
 	if err != nil {
-		fmt.Println("ERROR: ", err.Error())
-		return newMessageResponse(500, "Internal Server Error").toAPIGatewayProxyResponse()
+		return errBadRequest(err.Error())
 	}
 
-	var song mxtpdb.Song
-	err = json.Unmarshal([]byte(request.Body), &song)
-	if err != nil {
-		fmt.Println("ERROR: failed to unmarshal song: ", err.Error())
-		return newMessageResponse(400, "Bad song").toAPIGatewayProxyResponse()
-	}
+	song := mxtpdb.Song{SongUrl: link.URL}
+	if link.Provider == musiclink.Spotify {
+		song.SpotifyTrackId = link.Id
 
-	parsedUrl, err := url.Parse(song.SongUrl)
-	if err != nil {
-		fmt.Println("ERROR: failed to parse song url: ", err.Error())
-		return newMessageResponse(400, "Bad song").toAPIGatewayProxyResponse()
-	}
-
-	// TODO: use a regex to grab the track ID (and make sure it's a track resource)
-	// TODO: consider making request to spotify to verify track ID
-	song.SpotifyTrackId = ""
-	if parsedUrl.Host == "open.spotify.com" {
-		pathParts := strings.Split(parsedUrl.Path, "/")
-		if len(pathParts) > 0 {
-			song.SpotifyTrackId = pathParts[len(pathParts)-1]
-
-			// get track info from spotify
-			spotifyTrackId := spotify.ID(song.SpotifyTrackId)
-			client, err := NewClient(db, username)
-			if err != nil {
-				fmt.Println("ERROR: failed to initialize spotify client: ", err.Error())
-				return newMessageResponse(500, "Internal Server Error").toAPIGatewayProxyResponse()
-			}
-			track, err := client.GetTrack(spotifyTrackId)
-			if err != nil {
-				if err != nil {
-					fmt.Println("ERROR: failed to get track: ", err.Error())
-					return newMessageResponse(400, "Failed to get spotify track id "+song.SpotifyTrackId).toAPIGatewayProxyResponse()
-				}
-			}
-
-			song.Name = track.Name
-			var artistNames []string
-			for _, artist := range track.Artists {
-				artistNames = append(artistNames, artist.Name)
-			}
-			song.Artists = artistNames
+		// get track info from spotify using the league owner's account
+		provider, err := openLeagueMusic(ctx, db, leagueName)
+		if err != nil && !skipTrackLookup(err) {
+			return fmt.Errorf("failed to initialize spotify client: %w", err)
+		}
+		var track music.Track
+		if err == nil {
+			track, err = provider.GetTrack(song.SpotifyTrackId)
+		}
+		if skipTrackLookup(err) {
+			// don't block submissions on the owner's Spotify account, the
+			// song just won't have its details
+			fmt.Println("WARNING: skipping track lookup: ", err.Error())
+		} else if err != nil {
+			fmt.Println("ERROR: failed to get track: ", err.Error())
+			return errBadRequest("Failed to get spotify track id " + song.SpotifyTrackId)
 		}
+
+		song.Name = track.Name
+		song.Artists = track.Artists
 	}
 
 	err = db.UpdateSong(
@@ -212,108 +215,164 @@ func postSongsHandler(parameters map[string]string, request events.APIGatewayPro
 		song.Artists,
 	)
 	if err != nil {
-		fmt.Println("ERROR: failed to put submission: ", err.Error())
-		return newMessageResponse(500, "Internal Server Error").toAPIGatewayProxyResponse()
+		return fmt.Errorf("failed to put submission: %w", err)
 	}
-
-	return newMessageResponse(200, "Successfully put submission").toAPIGatewayProxyResponse()
+	return nil
 }
 
-func postVotesHandler(parameters map[string]string, request events.APIGatewayProxyRequest) *events.APIGatewayProxyResponse {
-	username := parameters["username"]
-	if username == "" {
-		return newMessageResponse(400, "Invalid Authorization header").toAPIGatewayProxyResponse()
+// submitVotes replaces the user's votes for the theme if they follow the
+// league's voting rules, returning a *mxtpdb.VoteRuleError if they don't. Like
+// submitSong it is shared by the API and Slack commands.
+func submitVotes(db mxtpdb.Store, leagueName, themeId, username string, submissionIds []string) error {
+	if err := requirePhase(db, leagueName, themeId, mxtpdb.PhaseVote); err != nil {
+		return err
 	}
 
-	leagueName := parameters["leagueName"]
-	if leagueName == "" {
-		fmt.Println("ERROR: Parameter 'leagueName' not found")
-		return newMessageResponse(500, "Internal Server Error").toAPIGatewayProxyResponse()
+	league, err := db.GetLeague(leagueName)
+	if err != nil {
+		return err
+	}
+	themeItems, err := db.GetThemeItems(leagueName, themeId)
+	if err != nil {
+		return err
 	}
 
-	themeId := parameters["themeId"]
-	if themeId == "" {
-		fmt.Println("ERROR: Parameter 'themeId' not found")
-		return newMessageResponse(500, "Internal Server Error").toAPIGatewayProxyResponse()
+	err = league.CheckVotes(themeItems, username, submissionIds)
+	if err != nil {
+		return err
 	}
 
-	db, err := mxtpdb.New()
+	err = db.UpdateVotes(leagueName, themeId, username, submissionIds)
 	if err != nil {
-		fmt.Println("ERROR: ", err.Error())
-		return newMessageResponse(500, "Internal Server Error").toAPIGatewayProxyResponse()
+		return fmt.Errorf("failed to put votes: %w", err)
 	}
+	return nil
+}
 
-	var votes mxtpdb.Votes
-	err = json.Unmarshal([]byte(request.Body), &votes)
+// requireUser returns the requesting user, or an error if the request is
+// anonymous
+func requireUser(c *bouncer.Context) (string, error) {
+	if c.Principal.Username == "" {
+		return "", errUnauthorized("Invalid Authorization header")
+	}
+	return c.Principal.Username, nil
+}
+
+func postSongsHandler(c *bouncer.Context) (*events.APIGatewayProxyResponse, error) {
+	username, err := requireUser(c)
 	if err != nil {
-		fmt.Println("ERROR: failed to unmarshal votes: ", err.Error())
-		return newMessageResponse(400, "Bad votes").toAPIGatewayProxyResponse()
+		return nil, err
+	}
+
+	var song SongRequest
+	if err := c.Bind(&song); err != nil {
+		return nil, err
 	}
 
-	err = db.UpdateVotes(leagueName, themeId, username, votes.SubmissionIds)
+	db, err := storeFor(c)
 	if err != nil {
-		fmt.Println("ERROR: failed to put votes: ", err.Error())
-		return newMessageResponse(500, "Internal Server Error").toAPIGatewayProxyResponse()
+		return nil, err
 	}
 
-	return newMessageResponse(200, "Successfully updated votes").toAPIGatewayProxyResponse()
+	err = submitSong(c, db, c.Parameters["leagueName"], c.Parameters["themeId"], username, song.SongUrl)
+	if err != nil {
+		return nil, err
+	}
+	return newMessageResponse(200, "Successfully put submission").toAPIGatewayProxyResponse(), nil
 }
 
-func getGamesHandler(parameters map[string]string, request events.APIGatewayProxyRequest) *events.APIGatewayProxyResponse {
-	username := parameters["username"]
-	if username == "" {
-		return newMessageResponse(400, "Invalid Authorization header").toAPIGatewayProxyResponse()
+func postVotesHandler(c *bouncer.Context) (*events.APIGatewayProxyResponse, error) {
+	username, err := requireUser(c)
+	if err != nil {
+		return nil, err
 	}
 
-	leagueName := parameters["leagueName"]
-	if leagueName == "" {
-		fmt.Println("ERROR: Parameter 'leagueName' not found")
-		return newMessageResponse(500, "Internal Server Error").toAPIGatewayProxyResponse()
+	var votes VotesRequest
+	if err := c.Bind(&votes); err != nil {
+		return nil, err
 	}
 
-	// This is ignored for now, just getting current game
-	gameId := parameters["gameId"]
-	if gameId == "" {
-		fmt.Println("ERROR: Parameter 'gameId' not found")
-		return newMessageResponse(500, "Internal Server Error").toAPIGatewayProxyResponse()
+	db, err := storeFor(c)
+	if err != nil {
+		return nil, err
 	}
 
-	db, err := mxtpdb.New()
+	err = submitVotes(db, c.Parameters["leagueName"], c.Parameters["themeId"], username, votes.SubmissionIds)
+	if err != nil {
+		return nil, err
+	}
+	return newMessageResponse(200, "Successfully updated votes").toAPIGatewayProxyResponse(), nil
+}
+
+func getGamesHandler(c *bouncer.Context) (*events.APIGatewayProxyResponse, error) {
+	username, err := requireUser(c)
+	if err != nil {
+		return nil, err
+	}
+	leagueName := c.Parameters["leagueName"]
+	// gameId is ignored for now, just getting current game
+
+	db, err := storeFor(c)
 	if err != nil {
-		fmt.Println("ERROR: ", err.Error())
-		return newMessageResponse(500, "Internal Server Error").toAPIGatewayProxyResponse()
+		return nil, err
 	}
 
 	league, err := db.GetLeague(leagueName)
+	if err == mxtpdb.ErrNotFound {
+		return nil, errNotFound("League not found")
+	}
 	if err != nil {
-		fmt.Println("ERROR: ", err.Error())
-		return newMessageResponse(500, "Internal Server Error").toAPIGatewayProxyResponse()
+		return nil, err
 	}
 
 	// get the submit theme data
 	submitThemeItems, err := db.GetThemeItems(leagueName, league.SubmitTheme.Date)
 	if err != nil {
-		fmt.Println("ERROR: ", err.Error())
-		return newMessageResponse(500, "Internal Server Error").toAPIGatewayProxyResponse()
+		return nil, err
 	}
 	// Get the vote theme data
 	voteThemeItems, err := db.GetThemeItems(leagueName, league.VoteTheme.Date)
 	if err != nil {
-		fmt.Println("ERROR: ", err.Error())
-		return newMessageResponse(500, "Internal Server Error").toAPIGatewayProxyResponse()
+		return nil, err
+	}
+
+	role, err := leagueRole(db, c.Parameters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get league role: %w", err)
+	}
+
+	// the owner's spotify account manages the league's playlists, so give them
+	// a way to (re)connect it. This shouldn't really be a part of the Games response...
+	spotifyAuthUrl := ""
+	if role == mxtpdb.RoleOwner {
+		// generate an auth url
+		state := randSeq(30)
+		err = db.UpdateUserState(username, state)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update user state: %w", err)
+		}
+
+		spotifyAuthUrl = Auth.AuthURL(state)
 	}
 
 	// return everything if this is an admin request
-	if _, ok := parameters["ADMIN"]; ok {
+	if canAdminister(role) {
+		reauthRequired, err := ownerSpotifyReauthRequired(db, leagueName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get spotify status: %w", err)
+		}
+
 		response := jsonResponse{
 			content: Game{
-				League:           league,
-				SubmitThemeItems: submitThemeItems,
-				VoteThemeItems:   voteThemeItems,
+				League:                league,
+				SubmitThemeItems:      submitThemeItems,
+				VoteThemeItems:        voteThemeItems,
+				SpotifyAuthUrl:        spotifyAuthUrl,
+				SpotifyReauthRequired: reauthRequired,
 			},
 			status: 200,
 		}
-		return response.toAPIGatewayProxyResponse()
+		return response.toAPIGatewayProxyResponse(), nil
 	}
 
 	// make sure votes aren't included
@@ -342,173 +401,216 @@ func getGamesHandler(parameters map[string]string, request events.APIGatewayProx
 	}
 	voteThemeItems.Votes = []mxtpdb.Votes{userVotes}
 
-	// TODO: change this to check if the requesting user is admin of league? Also this shouldn't be a part of the Games response...
-	// maybe not necessary if they auth when creating league, but helpful for now I suppose
-	spotifyAuthUrl := ""
-	if username == "ted@devetry.com" {
-		// generate an auth url
-		state := randSeq(30)
-		err = db.UpdateUserState(username, state)
-		if err != nil {
-			fmt.Println("ERROR: failed to update user state: ", err.Error())
-			return newMessageResponse(500, "Internal Server Error").toAPIGatewayProxyResponse()
-		}
-
-		spotifyAuthUrl = Auth.AuthURL(state)
-	}
-
 	response := jsonResponse{
 		content: Game{
 			League:           league,
 			SubmitThemeItems: submitThemeItems,
 			VoteThemeItems:   voteThemeItems,
-			SpotifyAuthUrl:   spotifyAuthUrl,
 		},
 		status: 200,
 	}
 
-	return response.toAPIGatewayProxyResponse()
+	return response.toAPIGatewayProxyResponse(), nil
 }
 
-func callbackHandler(parameters map[string]string, request events.APIGatewayProxyRequest) *events.APIGatewayProxyResponse {
-	code := request.QueryStringParameters["code"]
-	state := request.QueryStringParameters["state"]
-	if code == "" || state == "" {
-		return newMessageResponse(500, "Missing code or state in request query params").toAPIGatewayProxyResponse()
+// CallbackRequest is Spotify's redirect after the league owner connects their
+// account
+type CallbackRequest struct {
+	Code  string `query:"code" validate:"required"`
+	State string `query:"state" validate:"required"`
+}
+
+func callbackHandler(c *bouncer.Context) (*events.APIGatewayProxyResponse, error) {
+	var callback CallbackRequest
+	if err := c.Bind(&callback); err != nil {
+		return nil, err
 	}
 
 	// get the user associated with the state
-	db, err := mxtpdb.New()
+	db, err := storeFor(c)
 	if err != nil {
-		fmt.Println("ERROR: ", err.Error())
-		return newMessageResponse(500, "Internal Server Error").toAPIGatewayProxyResponse()
+		return nil, err
 	}
 
-	username, err := db.GetUserFromState(state)
+	username, err := db.GetUserFromState(callback.State)
 	if err != nil || username == "" {
-		fmt.Println("ERROR: failed to get user: ", err.Error())
-		return newMessageResponse(400, "Unknown state provided").toAPIGatewayProxyResponse()
+		fmt.Println("ERROR: failed to get user: ", err)
+		return nil, errBadRequest("Unknown state provided")
 	}
 
 	// exchange the code for a token and put it in the database
-	token, err := Auth.Exchange(code)
+	token, err := spotifyConfig.Exchange(context.WithValue(c, oauth2.HTTPClient, spotifyHTTPClient), callback.Code)
+	if err != nil {
+		fmt.Println("ERROR: failed to exchange code: ", err.Error())
+		return nil, errBadRequest("Failed to connect spotify account")
+	}
+	// saving the new token also clears any reauth flag
 	err = db.UpdateSpotifyToken(token, username)
 	if err != nil {
-		fmt.Println("ERROR: failed to update token: ", err.Error())
-		return newMessageResponse(500, "Internal Server Error").toAPIGatewayProxyResponse()
+		return nil, fmt.Errorf("failed to update token: %w", err)
 	}
 
-	return newMessageResponse(200, "Success").toAPIGatewayProxyResponse()
+	return newMessageResponse(200, "Success").toAPIGatewayProxyResponse(), nil
 }
 
-func postBuildPlaylistHandler(parameters map[string]string, request events.APIGatewayProxyRequest) *events.APIGatewayProxyResponse {
-	if _, ok := parameters["ADMIN"]; !ok {
-		return &events.APIGatewayProxyResponse{
-			StatusCode: 400,
-			Body:       "Unauthorized",
-		}
-	}
+var errOwnerReauth = errConflict("The league owner needs to reconnect their Spotify account")
 
-	// get the playlist id
-	leagueName := parameters["leagueName"]
-	if leagueName == "" {
-		fmt.Println("ERROR: Parameter 'leagueName' not found")
-		return newMessageResponse(500, "Internal Server Error").toAPIGatewayProxyResponse()
+var errNoOwnerSpotify = errConflict("The league owner needs to connect a Spotify account")
+
+func postBuildPlaylistHandler(c *bouncer.Context) (*events.APIGatewayProxyResponse, error) {
+	var buildRequest BuildPlaylistRequest
+	if err := c.Bind(&buildRequest); err != nil {
+		return nil, err
 	}
-	db, err := mxtpdb.New()
+
+	leagueName := c.Parameters["leagueName"]
+	db, err := storeFor(c)
 	if err != nil {
-		fmt.Println("ERROR: ", err.Error())
-		return newMessageResponse(500, "Internal Server Error").toAPIGatewayProxyResponse()
+		return nil, err
 	}
 	league, err := db.GetLeague(leagueName)
-	if err != nil {
-		fmt.Println("ERROR: ", err.Error())
-		return newMessageResponse(500, "Internal Server Error").toAPIGatewayProxyResponse()
-	}
-	if league.SpotifyPlaylistId == "" {
-		fmt.Println("ERROR: league's spotify playlist id does not exist")
-		return newMessageResponse(500, "Internal Server Error").toAPIGatewayProxyResponse()
+	if err == mxtpdb.ErrNotFound {
+		return nil, errNotFound("League not found")
 	}
-	playlistId := spotify.ID(league.SpotifyPlaylistId)
-
-	// setup our spotify client
-	// TODO: hardcoded my id, once using user roles we can actually get clients by users
-	client, err := NewClient(db, "ted@devetry.com")
 	if err != nil {
-		fmt.Println("ERROR: ", err.Error())
-		return newMessageResponse(500, "Internal Server Error").toAPIGatewayProxyResponse()
+		return nil, err
 	}
 
-	// update the playlist description
-	themeDescription := fmt.Sprintf("%v - %v", league.SubmitTheme.Name, league.SubmitTheme.Description)
-	err = client.ChangePlaylistDescription(playlistId, themeDescription)
-	if err != nil {
-		fmt.Println("ERROR: ", err.Error())
-		return newMessageResponse(500, "Internal Server Error").toAPIGatewayProxyResponse()
+	// get the requested theme, or the one open for submissions
+	theme := league.SubmitTheme
+	if buildRequest.ThemeId != "" {
+		theme, err = db.GetTheme(leagueName, buildRequest.ThemeId)
+		if err == mxtpdb.ErrNotFound {
+			return nil, errNotFound("Theme not found")
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else if theme.Date == "" {
+		return nil, errConflict("No theme is open for submissions")
 	}
 
-	// get existing track IDs so we can remove them from the playlist
-	trackPage, err := client.GetPlaylistTracks(playlistId)
-	if err != nil {
-		fmt.Println("ERROR: ", err.Error())
-		return newMessageResponse(500, "Internal Server Error").toAPIGatewayProxyResponse()
+	// setup our music provider with the owner's account
+	provider, err := openLeagueMusic(c, db, leagueName)
+	if errors.Is(err, ErrSpotifyReauth) {
+		return nil, errOwnerReauth
 	}
-
-	var existingTrackIDs []spotify.ID
-	for _, playlistTrack := range trackPage.Tracks {
-		existingTrackIDs = append(existingTrackIDs, playlistTrack.Track.SimpleTrack.ID)
+	if err == ErrNoSpotifyAccount {
+		return nil, errNoOwnerSpotify
 	}
-
-	if len(existingTrackIDs) > 0 {
-		_, err := client.RemoveTracksFromPlaylist(playlistId, existingTrackIDs...)
-		if err != nil {
-			fmt.Println("ERROR: ", err.Error())
-			return newMessageResponse(500, "Internal Server Error").toAPIGatewayProxyResponse()
-		}
+	if err != nil {
+		return nil, err
 	}
 
-	// add new tracks to the playlist
-	themeItems, err := db.GetThemeItems(league.Name, league.SubmitTheme.Date)
+	playlistId, report, err := mixtape.BuildPlaylist(db, provider, league.Name, theme)
+	if errors.Is(err, ErrSpotifyReauth) {
+		return nil, errOwnerReauth
+	}
 	if err != nil {
-		fmt.Println("ERROR: ", err.Error())
-		return newMessageResponse(500, "Internal Server Error").toAPIGatewayProxyResponse()
+		return nil, err
 	}
-	for _, song := range themeItems.Songs {
-		if song.SpotifyTrackId == "" {
-			continue
-		}
 
-		_, err := client.AddTracksToPlaylist(playlistId, spotify.ID(song.SpotifyTrackId))
-		if err != nil {
-			fmt.Printf("WARNING: skipping SubmissionId %v due to error: %v\n", song.SubmissionId, err.Error())
-		}
+	response := jsonResponse{
+		content: PlaylistSyncResponse{
+			PlaylistId: playlistId,
+			Report:     report,
+		},
+		status: 200,
 	}
+	return response.toAPIGatewayProxyResponse(), nil
+}
 
-	return newMessageResponse(200, "Successfully updated playlist").toAPIGatewayProxyResponse()
+func newRouter() *bouncer.Bouncer {
+	b := bouncer.New("/.netlify/functions/jockey")
+	b.ErrorResponse = errorResponse
+	b.PrincipalFrom = principal
+	b.Use(corsMiddleware, logMiddleware, recoverMiddleware, authMiddleware)
+
+	b.HandleContext(bouncer.Get, "/callback", callbackHandler)
+
+	auth := b.Group("/auth")
+	auth.HandleContext(bouncer.Post, "/login", postLoginHandler)
+	auth.HandleContext(bouncer.Post, "/session", postSessionHandler)
+	auth.HandleContext(bouncer.Post, "/refresh", postRefreshTokenHandler)
+
+	leagues := b.Group("/leagues/{leagueName}")
+	leagues.HandleContext(bouncer.Put, "", putLeagueHandler)
+	leagues.HandleContext(bouncer.Post, "/buildPlaylist", leagueAdmin(postBuildPlaylistHandler))
+	leagues.HandleContext(bouncer.Get, "/games/{gameId}", leagueMember(getGamesHandler))
+	leagues.HandleContext(bouncer.Get, "/leaderboard", leagueMember(getLeaderboardHandler))
+	leagues.HandleContext(bouncer.Get, "/playlists", getPlaylistsHandler)
+	leagues.HandleContext(bouncer.Get, "/themes", leagueAdmin(getThemesHandler))
+	leagues.HandleContext(bouncer.Get, "/members", leagueAdmin(getMembersHandler))
+	leagues.HandleContext(bouncer.Post, "/claim", leagueAdmin(postClaimHandler))
+
+	leagues.HandleContext(bouncer.Get, "/hooks", leagueAdmin(getHooksHandler))
+	leagues.HandleContext(bouncer.Post, "/slack", postSlackCommandHandler)
+	leagues.HandleContext(bouncer.Put, "/slack/users/{slackUserId}", leagueAdmin(putSlackUserHandler))
+
+	hooks := leagues.Group("/hooks/{hookId}")
+	hooks.HandleContext(bouncer.Put, "", leagueAdmin(putHookHandler))
+	hooks.HandleContext(bouncer.Delete, "", leagueAdmin(deleteHookHandler))
+
+	members := leagues.Group("/members/{userId}")
+	members.HandleContext(bouncer.Put, "", leagueAdmin(putMemberHandler))
+	members.HandleContext(bouncer.Delete, "", leagueAdmin(deleteMemberHandler))
+
+	themes := leagues.Group("/themes/{themeId}")
+	themes.HandleContext(bouncer.Put, "", leagueAdmin(putThemeHandler))
+	themes.HandleContext(bouncer.Delete, "", leagueAdmin(deleteThemeHandler))
+	themes.HandleContext(bouncer.Post, "/songs", leagueMember(postSongsHandler))
+	themes.HandleContext(bouncer.Post, "/votes", leagueMember(postVotesHandler))
+	themes.HandleContext(bouncer.Get, "/results", leagueMember(getResultsHandler))
+
+	// the routes are fixed, so a bad one is a bug
+	if err := b.Err(); err != nil {
+		panic(err)
+	}
+	return b
 }
 
+var router = newRouter()
+
+// JockeyHandler serves a request without a deadline or cancellation
 func JockeyHandler(request events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
-	// handle OPTIONS preflight request
-	if request.HTTPMethod == bouncer.Options {
-		response := newMessageResponse(204, "").toAPIGatewayProxyResponse()
-		response.Headers["Access-Control-Allow-Origin"] = "*"
-		response.Headers["Access-Control-Allow-Headers"] = "*"
-		response.Headers["Access-Control-Allow-Methods"] = "POST, GET, OPTIONS, DELETE"
-		response.Headers["Access-Control-Max-Age"] = "86400"
+	return JockeyHandlerContext(context.Background(), request)
+}
+
+func JockeyHandlerContext(ctx context.Context, request events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
+	response, err := router.RouteContext(ctx, request)
+	if _, ok := err.(*bouncer.RouteError); ok {
+		// unmatched routes still come with a 404 or 405 response for the client
 		return response, nil
 	}
+	return response, err
+}
 
-	b := bouncer.New("/.netlify/functions/jockey")
+// main runs jockey as a lambda function, or as a plain HTTP server when the
+// -http flag is set, e.g.
+//
+//	go run ./jockey -http :8000 -store memory
+func main() {
+	httpAddr := flag.String("http", "", "serve the API over HTTP on this address instead of running as a lambda")
+	storeName := flag.String("store", "dynamo", "store to use with -http, either dynamo or memory")
+	flag.Parse()
 
-	b.Handle(bouncer.Post, "/leagues/{leagueName}/buildPlaylist", authMiddleware(postBuildPlaylistHandler))
-	b.Handle(bouncer.Post, "/leagues/{leagueName}/themes/{themeId}/songs", authMiddleware(postSongsHandler))
-	b.Handle(bouncer.Post, "/leagues/{leagueName}/themes/{themeId}/votes", authMiddleware(postVotesHandler))
-	b.Handle(bouncer.Get, "/leagues/{leagueName}/games/{gameId}", authMiddleware(getGamesHandler))
-	b.Handle(bouncer.Get, "/callback", authMiddleware(callbackHandler))
+	if *httpAddr == "" {
+		lambda.Start(JockeyHandlerContext)
+		return
+	}
 
-	return b.Route(request)
-}
+	switch *storeName {
+	case "dynamo":
+	case "memory":
+		store := mxtpdb.NewMemoryStore()
+		openStore = func() (mxtpdb.Store, error) { return store, nil }
+	default:
+		fmt.Fprintln(os.Stderr, "ERROR: unknown store ", *storeName)
+		os.Exit(2)
+	}
 
-func main() {
-	lambda.Start(JockeyHandler)
+	fmt.Printf("Serving jockey on %v with the %v store\n", *httpAddr, *storeName)
+	err := http.ListenAndServe(*httpAddr, gateway.NewHandler(JockeyHandlerContext))
+	fmt.Fprintln(os.Stderr, "ERROR: ", err.Error())
+	os.Exit(1)
 }
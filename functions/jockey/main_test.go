@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/macintoshpie/mxtp-fx/authtoken"
+	"github.com/macintoshpie/mxtp-fx/mxtpdb"
+	"github.com/stretchr/testify/require"
+)
+
+const jockeyBase = "/.netlify/functions/jockey"
+
+var testSigner *authtoken.Signer
+
+func TestMain(m *testing.M) {
+	var err error
+	testSigner, err = authtoken.NewSigner(time.Hour, authtoken.Key{Id: "test", Secret: []byte("test secret")})
+	if err != nil {
+		panic(err)
+	}
+	openSigner = func() (*authtoken.Signer, error) { return testSigner, nil }
+
+	os.Exit(m.Run())
+}
+
+func issueToken(username string, admin bool) string {
+	token, _, err := testSigner.Issue(username, admin)
+	if err != nil {
+		panic(err)
+	}
+	return token
+}
+
+// useMemoryStore points the handlers at a fresh in-memory store seeded with a
+// league whose submit theme started today, and whose previous theme is in its
+// vote phase. Every test that hits a handler should call it so no test can
+// reach DynamoDB.
+func useMemoryStore(t *testing.T) (*mxtpdb.MemoryStore, string) {
+	store := mxtpdb.NewMemoryStore()
+	today := time.Now().UTC().Format(mxtpdb.ThemeDateFormat)
+	lastTheme := time.Now().UTC().AddDate(0, 0, -mxtpdb.DefaultSubmitDays).Format(mxtpdb.ThemeDateFormat)
+	store.Put(mxtpdb.MxtpItem{PK: "league#devetry", SK: "~meta", Name: "devetry"})
+	store.Put(mxtpdb.MxtpItem{PK: "league#devetry", SK: "theme#" + lastTheme, Name: "vote", Date: lastTheme})
+	store.Put(mxtpdb.MxtpItem{PK: "league#devetry", SK: "theme#" + today, Name: "submit", Date: today})
+	if err := store.PutMember("devetry", "alice", mxtpdb.RoleMember); err != nil {
+		t.Fatal(err)
+	}
+
+	openStore = func() (mxtpdb.Store, error) { return store, nil }
+
+	return store, today
+}
+
+func bearerRequest(method, path, token, body string) events.APIGatewayProxyRequest {
+	return events.APIGatewayProxyRequest{
+		HTTPMethod: method,
+		Path:       jockeyBase + path,
+		Headers: map[string]string{
+			"Authorization": "Bearer " + token,
+		},
+		Body: body,
+	}
+}
+
+func authedRequest(method, path, username, body string) events.APIGatewayProxyRequest {
+	return bearerRequest(method, path, issueToken(username, false), body)
+}
+
+func TestPostSongs(t *testing.T) {
+	store, today := useMemoryStore(t)
+
+	res, err := JockeyHandler(authedRequest("POST", "/leagues/devetry/themes/"+today+"/songs", "alice", `{"SongUrl": "https://youtu.be/dQw4w9WgXcQ"}`))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+
+	song, err := store.GetSong("devetry", today, "alice")
+	require.Nil(t, err)
+	require.Equal(t, "https://www.youtube.com/watch?v=dQw4w9WgXcQ", song.SongUrl)
+	require.NotEmpty(t, song.SubmissionId)
+}
+
+func TestPostSongsBase64Body(t *testing.T) {
+	store, today := useMemoryStore(t)
+
+	request := authedRequest("POST", "/leagues/devetry/themes/"+today+"/songs", "alice", base64.StdEncoding.EncodeToString([]byte(`{"SongUrl": "https://youtu.be/dQw4w9WgXcQ"}`)))
+	request.IsBase64Encoded = true
+	res, err := JockeyHandler(request)
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+
+	song, err := store.GetSong("devetry", today, "alice")
+	require.Nil(t, err)
+	require.Equal(t, "https://www.youtube.com/watch?v=dQw4w9WgXcQ", song.SongUrl)
+}
+
+func TestPostSongsRejectsBadLinks(t *testing.T) {
+	_, today := useMemoryStore(t)
+
+	res, err := JockeyHandler(authedRequest("POST", "/leagues/devetry/themes/"+today+"/songs", "alice", `{"SongUrl": "https://example.com/song"}`))
+	require.Nil(t, err)
+	require.Equal(t, 400, res.StatusCode)
+
+	res, err = JockeyHandler(authedRequest("POST", "/leagues/devetry/themes/"+today+"/songs", "alice", `{"SongUrl": "https://open.spotify.com/album/1DFixLWuPkv3KT3TnV35m3"}`))
+	require.Nil(t, err)
+	require.Equal(t, 400, res.StatusCode)
+	var message MessageResponse
+	require.Nil(t, json.Unmarshal([]byte(res.Body), &message))
+	require.Equal(t, "Spotify album links aren't supported, please link a single track", message.Message)
+}
+
+func TestPostSongsOutsideSubmitPhase(t *testing.T) {
+	store, _ := useMemoryStore(t)
+	require.Nil(t, store.PutTheme("devetry", mxtpdb.Theme{Name: "old", Date: "2020-01-01"}))
+
+	res, err := JockeyHandler(authedRequest("POST", "/leagues/devetry/themes/2020-01-01/songs", "alice", `{"SongUrl": "https://example.com/song"}`))
+	require.Nil(t, err)
+	require.Equal(t, 409, res.StatusCode)
+	var message MessageResponse
+	require.Nil(t, json.Unmarshal([]byte(res.Body), &message))
+	require.Contains(t, message.Message, "closed")
+
+	res, err = JockeyHandler(authedRequest("POST", "/leagues/devetry/themes/2019-01-01/songs", "alice", `{"SongUrl": "https://example.com/song"}`))
+	require.Nil(t, err)
+	require.Equal(t, 404, res.StatusCode)
+}
+
+func TestPostVotes(t *testing.T) {
+	store, today := useMemoryStore(t)
+	league, err := store.GetLeague("devetry")
+	require.Nil(t, err)
+	voteTheme := league.VoteTheme.Date
+	require.Nil(t, store.UpdateSong("devetry", voteTheme, "bob", "https://youtu.be/b", "a", "", "", nil))
+	require.Nil(t, store.UpdateSong("devetry", voteTheme, "carl", "https://youtu.be/c", "b", "", "", nil))
+
+	res, err := JockeyHandler(authedRequest("POST", "/leagues/devetry/themes/"+voteTheme+"/votes", "alice", `{"SubmissionIds": ["a", "b"]}`))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+
+	items, err := store.GetThemeItems("devetry", voteTheme)
+	require.Nil(t, err)
+	require.Equal(t, []mxtpdb.Votes{{UserId: "alice", SubmissionIds: []string{"a", "b"}}}, items.Votes)
+
+	// the submit theme isn't open for voting yet
+	res, err = JockeyHandler(authedRequest("POST", "/leagues/devetry/themes/"+today+"/votes", "alice", `{"SubmissionIds": ["a"]}`))
+	require.Nil(t, err)
+	require.Equal(t, 409, res.StatusCode)
+}
+
+func TestPostVotesFollowsRules(t *testing.T) {
+	store, _ := useMemoryStore(t)
+	require.Nil(t, store.PutLeague(mxtpdb.League{Name: "devetry", MinVotes: 2, MaxVotes: 2}))
+	league, err := store.GetLeague("devetry")
+	require.Nil(t, err)
+	voteTheme := league.VoteTheme.Date
+	path := "/leagues/devetry/themes/" + voteTheme + "/votes"
+	for _, submitter := range []string{"alice", "bob", "carl"} {
+		require.Nil(t, store.UpdateSong("devetry", voteTheme, submitter, "https://youtu.be/"+submitter, submitter+"-song", "", "", nil))
+	}
+
+	res, err := JockeyHandler(authedRequest("POST", path, "alice", `{"SubmissionIds": ["alice-song", "bob-song", "bob-song", "other-song"]}`))
+	require.Nil(t, err)
+	require.Equal(t, 400, res.StatusCode)
+	var rules struct {
+		Code    string
+		Message string
+		Details []mxtpdb.VoteViolation
+	}
+	require.Nil(t, json.Unmarshal([]byte(res.Body), &rules))
+	require.Equal(t, CodeVoteRules, rules.Code)
+	var broken []string
+	for _, violation := range rules.Details {
+		broken = append(broken, violation.Rule)
+	}
+	require.Equal(t, []string{mxtpdb.RuleDuplicateVote, mxtpdb.RuleUnknownSubmission, mxtpdb.RuleSelfVote, mxtpdb.RuleMaxVotes}, broken)
+	require.Contains(t, rules.Message, "You can't vote for your own song")
+
+	res, err = JockeyHandler(authedRequest("POST", path, "alice", `{"SubmissionIds": ["bob-song"]}`))
+	require.Nil(t, err)
+	require.Equal(t, 400, res.StatusCode)
+	require.Contains(t, res.Body, "Vote for at least 2 songs")
+
+	// nothing is saved until the votes follow the rules
+	items, err := store.GetThemeItems("devetry", voteTheme)
+	require.Nil(t, err)
+	require.Empty(t, items.Votes)
+
+	res, err = JockeyHandler(authedRequest("POST", path, "alice", `{"SubmissionIds": ["bob-song", "carl-song"]}`))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+
+	// leagues can allow self votes
+	require.Nil(t, store.PutLeague(mxtpdb.League{Name: "devetry", AllowSelfVotes: true}))
+	res, err = JockeyHandler(authedRequest("POST", path, "alice", `{"SubmissionIds": ["alice-song"]}`))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+}
+
+func TestPostVotesRequiresUser(t *testing.T) {
+	useMemoryStore(t)
+
+	res, err := JockeyHandler(events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Path:       jockeyBase + "/leagues/devetry/themes/2020-01-01/votes",
+		Body:       `{"SubmissionIds": ["a"]}`,
+	})
+	require.Nil(t, err)
+	require.Equal(t, 401, res.StatusCode)
+	require.Equal(t, CodeUnauthorized, decodeError(t, res).Code)
+}
+
+func TestGetGamesHidesOtherUsers(t *testing.T) {
+	store, today := useMemoryStore(t)
+	require.Nil(t, store.UpdateSong("devetry", today, "alice", "url-a", "sub-a", "", "", nil))
+	require.Nil(t, store.UpdateSong("devetry", today, "bob", "url-b", "sub-b", "", "", nil))
+
+	res, err := JockeyHandler(authedRequest("GET", "/leagues/devetry/games/current", "alice", ""))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+
+	var game Game
+	require.Nil(t, json.Unmarshal([]byte(res.Body), &game))
+	require.Equal(t, "submit", game.League.SubmitTheme.Name)
+	require.Equal(t, "vote", game.League.VoteTheme.Name)
+	require.Len(t, game.SubmitThemeItems.Songs, 1)
+	require.Equal(t, "url-a", game.SubmitThemeItems.Songs[0].SongUrl)
+	require.Empty(t, game.SpotifyAuthUrl)
+}
+
+func TestUnknownRoutes(t *testing.T) {
+	useMemoryStore(t)
+
+	res, err := JockeyHandler(authedRequest("GET", "/nothing/here", "alice", ""))
+	require.Nil(t, err)
+	require.Equal(t, 404, res.StatusCode)
+
+	res, err = JockeyHandler(authedRequest("DELETE", "/leagues/devetry/themes/2020-01-01/votes", "alice", ""))
+	require.Nil(t, err)
+	require.Equal(t, 405, res.StatusCode)
+	require.Equal(t, "POST", res.Headers["Allow"])
+}
+
+func TestPreflight(t *testing.T) {
+	res, err := JockeyHandler(events.APIGatewayProxyRequest{
+		HTTPMethod: "OPTIONS",
+		Path:       jockeyBase + "/leagues/devetry/themes/2020-01-01/votes",
+	})
+	require.Nil(t, err)
+	require.Equal(t, 204, res.StatusCode)
+	require.Equal(t, "*", res.Headers["Access-Control-Allow-Origin"])
+
+	res, err = JockeyHandler(events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: jockeyBase + "/nothing"})
+	require.Nil(t, err)
+	require.Equal(t, 404, res.StatusCode)
+	require.Equal(t, "*", res.Headers["Access-Control-Allow-Origin"])
+}
+
+func TestPanicsKeepCorsHeaders(t *testing.T) {
+	useMemoryStore(t)
+	openStore = func() (mxtpdb.Store, error) { panic("table is on fire") }
+
+	res, err := JockeyHandler(authedRequest("GET", "/leagues/devetry/games/current", "alice", ""))
+	require.Nil(t, err)
+	require.Equal(t, 500, res.StatusCode)
+	require.Equal(t, "*", res.Headers["Access-Control-Allow-Origin"])
+}
+
+func TestAuthRejectsUnsignedTokens(t *testing.T) {
+	useMemoryStore(t)
+
+	// the old scheme of base64 encoding the username must not work anymore
+	res, err := JockeyHandler(bearerRequest("GET", "/leagues/devetry/games/current", "YWxpY2U=", ""))
+	require.Nil(t, err)
+	require.Equal(t, 401, res.StatusCode)
+
+	other, err := authtoken.NewSigner(time.Hour, authtoken.Key{Id: "test", Secret: []byte("other secret")})
+	require.Nil(t, err)
+	forged, _, err := other.Issue("alice", true)
+	require.Nil(t, err)
+	res, err = JockeyHandler(bearerRequest("POST", "/leagues/devetry/buildPlaylist", forged, ""))
+	require.Nil(t, err)
+	require.Equal(t, 401, res.StatusCode)
+
+	// public routes treat them as anonymous
+	res, err = JockeyHandler(bearerRequest("GET", "/leagues/devetry/playlists", forged, ""))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+	captureMail()
+	res, err = JockeyHandler(bearerRequest("POST", "/auth/login", forged, `{"Email": "alice@example.com"}`))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+}
+
+func TestBuildPlaylistRequiresLeagueAdmin(t *testing.T) {
+	useMemoryStore(t)
+
+	res, err := JockeyHandler(authedRequest("POST", "/leagues/devetry/buildPlaylist", "alice", ""))
+	require.Nil(t, err)
+	require.Equal(t, 403, res.StatusCode)
+}
+
+func TestRefreshToken(t *testing.T) {
+	os.Setenv("JOCKEY_ADMINS", "alice")
+	defer os.Unsetenv("JOCKEY_ADMINS")
+	res, err := JockeyHandler(bearerRequest("POST", "/auth/refresh", issueToken("alice", false), ""))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+
+	var tokenResponse TokenResponse
+	require.Nil(t, json.Unmarshal([]byte(res.Body), &tokenResponse))
+	claims, err := testSigner.Verify(tokenResponse.Token)
+	require.Nil(t, err)
+	require.Equal(t, "alice", claims.Subject)
+	require.True(t, claims.Admin)
+
+	// admins removed from JOCKEY_ADMINS lose the claim on refresh
+	os.Unsetenv("JOCKEY_ADMINS")
+	res, err = JockeyHandler(bearerRequest("POST", "/auth/refresh", tokenResponse.Token, ""))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+	require.Nil(t, json.Unmarshal([]byte(res.Body), &tokenResponse))
+	claims, err = testSigner.Verify(tokenResponse.Token)
+	require.Nil(t, err)
+	require.False(t, claims.Admin)
+
+	res, err = JockeyHandler(events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: jockeyBase + "/auth/refresh"})
+	require.Nil(t, err)
+	require.Equal(t, 401, res.StatusCode)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/macintoshpie/mxtp-fx/bouncer"
+	"github.com/macintoshpie/mxtp-fx/mxtpdb"
+)
+
+type MemberRequest struct {
+	Role string `validate:"oneof=member admin owner"`
+}
+
+type MembersResponse struct {
+	Members []mxtpdb.Member
+}
+
+// leagueRole returns the requesting user's role in the league, or "" if they
+// are not a member. Users with the admin claim are treated as owners of every
+// league.
+func leagueRole(db mxtpdb.Store, parameters map[string]string) (string, error) {
+	if _, ok := parameters["ADMIN"]; ok {
+		return mxtpdb.RoleOwner, nil
+	}
+
+	username := parameters["username"]
+	if username == "" {
+		return "", nil
+	}
+
+	member, err := db.GetMember(parameters["leagueName"], username)
+	if err == mxtpdb.ErrNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return member.Role, nil
+}
+
+func canAdminister(role string) bool {
+	return role == mxtpdb.RoleAdmin || role == mxtpdb.RoleOwner
+}
+
+// leagueMember wraps a handler so it rejects requests from users who are not
+// members of the league in the path.
+func leagueMember(handler bouncer.Handler) bouncer.Handler {
+	return requireLeagueRole(handler, func(role string) bool { return role != "" })
+}
+
+// leagueAdmin wraps a handler so it rejects requests from users who are not
+// admins or owners of the league in the path.
+func leagueAdmin(handler bouncer.Handler) bouncer.Handler {
+	return requireLeagueRole(handler, canAdminister)
+}
+
+// requireLeagueRole rejects requests from users whose role in the league
+// isn't allowed, and sets Parameters["leagueRole"] for the others. It wraps
+// handlers rather than being a middleware so it has the request's context.
+func requireLeagueRole(handler bouncer.Handler, allowed func(role string) bool) bouncer.Handler {
+	return func(c *bouncer.Context) (*events.APIGatewayProxyResponse, error) {
+		if c.Parameters["username"] == "" {
+			return nil, errUnauthorized("Invalid Authorization header")
+		}
+
+		db, err := storeFor(c)
+		if err != nil {
+			return nil, err
+		}
+
+		role, err := leagueRole(db, c.Parameters)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get league role: %w", err)
+		}
+		if !allowed(role) {
+			return nil, errForbidden("Forbidden")
+		}
+
+		c.Parameters["leagueRole"] = role
+		return handler(c)
+	}
+}
+
+func getMembersHandler(c *bouncer.Context) (*events.APIGatewayProxyResponse, error) {
+	db, err := storeFor(c)
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := db.GetMembers(c.Parameters["leagueName"])
+	if err != nil {
+		return nil, err
+	}
+
+	response := jsonResponse{
+		content: MembersResponse{
+			Members: members,
+		},
+		status: 200,
+	}
+	return response.toAPIGatewayProxyResponse(), nil
+}
+
+// postClaimHandler makes the requesting user the owner of a league without
+// one, and adds everyone who submitted songs or voted in its themes as
+// members. Leagues created before membership was stored have neither, so an
+// admin claims them once to open them to their players again.
+func postClaimHandler(c *bouncer.Context) (*events.APIGatewayProxyResponse, error) {
+	leagueName := c.Parameters["leagueName"]
+	db, err := storeFor(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.GetLeague(leagueName); err == mxtpdb.ErrNotFound {
+		return nil, errNotFound("League not found")
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = mxtpdb.BackfillMembers(db, leagueName, c.Parameters["username"])
+	if err != nil {
+		return nil, fmt.Errorf("failed to backfill members: %w", err)
+	}
+
+	return getMembersHandler(c)
+}
+
+// ownerCount returns the number of owners in the league, and whether userId
+// is one of them.
+func ownerCount(db mxtpdb.Store, leagueName, userId string) (int, bool, error) {
+	members, err := db.GetMembers(leagueName)
+	if err != nil {
+		return 0, false, err
+	}
+
+	count := 0
+	isOwner := false
+	for _, member := range members {
+		if member.Role == mxtpdb.RoleOwner {
+			count += 1
+			isOwner = isOwner || member.UserId == userId
+		}
+	}
+	return count, isOwner, nil
+}
+
+// checkOwnerChange returns an error if the requesting user may not change the
+// membership of userId to newRole ("" when removing them). Only owners may
+// change owners, and the last owner cannot be removed or demoted.
+func checkOwnerChange(db mxtpdb.Store, parameters map[string]string, userId, newRole string) error {
+	owners, targetIsOwner, err := ownerCount(db, parameters["leagueName"], userId)
+	if err != nil {
+		return err
+	}
+
+	if (targetIsOwner || newRole == mxtpdb.RoleOwner) && parameters["leagueRole"] != mxtpdb.RoleOwner {
+		return errForbidden("Only owners can change owners")
+	}
+	if targetIsOwner && newRole != mxtpdb.RoleOwner && owners == 1 {
+		return errBadRequest("A league must have an owner")
+	}
+	return nil
+}
+
+func putMemberHandler(c *bouncer.Context) (*events.APIGatewayProxyResponse, error) {
+	userId := strings.ToLower(c.Parameters["userId"])
+	if strings.Contains(userId, "#") {
+		return nil, errBadRequest("Invalid user id")
+	}
+
+	var memberRequest MemberRequest
+	if err := c.Bind(&memberRequest); err != nil {
+		return nil, err
+	}
+	if memberRequest.Role == "" {
+		memberRequest.Role = mxtpdb.RoleMember
+	}
+
+	db, err := storeFor(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkOwnerChange(db, c.Parameters, userId, memberRequest.Role); err != nil {
+		return nil, err
+	}
+
+	err = db.PutMember(c.Parameters["leagueName"], userId, memberRequest.Role)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put member: %w", err)
+	}
+
+	return newMessageResponse(200, "Successfully put member").toAPIGatewayProxyResponse(), nil
+}
+
+func deleteMemberHandler(c *bouncer.Context) (*events.APIGatewayProxyResponse, error) {
+	userId := strings.ToLower(c.Parameters["userId"])
+
+	db, err := storeFor(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkOwnerChange(db, c.Parameters, userId, ""); err != nil {
+		return nil, err
+	}
+
+	err = db.RemoveMember(c.Parameters["leagueName"], userId)
+	if err == mxtpdb.ErrNotFound {
+		return nil, errNotFound("Member not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove member: %w", err)
+	}
+
+	return newMessageResponse(200, "Successfully removed member").toAPIGatewayProxyResponse(), nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/macintoshpie/mxtp-fx/mxtpdb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreatingLeagueMakesOwner(t *testing.T) {
+	store := mxtpdb.NewMemoryStore()
+	openStore = func() (mxtpdb.Store, error) { return store, nil }
+
+	// only users with the admin claim may create leagues
+	res, err := JockeyHandler(authedRequest("PUT", "/leagues/newleague", "alice", `{}`))
+	require.Nil(t, err)
+	require.Equal(t, 403, res.StatusCode)
+
+	res, err = JockeyHandler(adminRequest("PUT", "/leagues/newleague", `{}`))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+
+	member, err := store.GetMember("newleague", "ted@devetry.com")
+	require.Nil(t, err)
+	require.Equal(t, mxtpdb.RoleOwner, member.Role)
+}
+
+func TestLeagueAdminsManageMembers(t *testing.T) {
+	store, _ := useMemoryStore(t)
+	require.Nil(t, store.PutMember("devetry", "olive", mxtpdb.RoleOwner))
+	require.Nil(t, store.PutMember("devetry", "adam", mxtpdb.RoleAdmin))
+
+	res, err := JockeyHandler(authedRequest("PUT", "/leagues/devetry/members/mia", "adam", `{"Role": "member"}`))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+
+	// members can't manage members
+	res, err = JockeyHandler(authedRequest("PUT", "/leagues/devetry/members/mark", "mia", `{}`))
+	require.Nil(t, err)
+	require.Equal(t, 403, res.StatusCode)
+
+	// admins can't create or remove owners
+	res, err = JockeyHandler(authedRequest("PUT", "/leagues/devetry/members/mia", "adam", `{"Role": "owner"}`))
+	require.Nil(t, err)
+	require.Equal(t, 403, res.StatusCode)
+	res, err = JockeyHandler(authedRequest("DELETE", "/leagues/devetry/members/olive", "adam", ""))
+	require.Nil(t, err)
+	require.Equal(t, 403, res.StatusCode)
+
+	// the last owner can't leave
+	res, err = JockeyHandler(authedRequest("PUT", "/leagues/devetry/members/olive", "olive", `{"Role": "admin"}`))
+	require.Nil(t, err)
+	require.Equal(t, 400, res.StatusCode)
+
+	res, err = JockeyHandler(authedRequest("PUT", "/leagues/devetry/members/mia", "adam", `{"Role": "dictator"}`))
+	require.Nil(t, err)
+	require.Equal(t, 400, res.StatusCode)
+
+	res, err = JockeyHandler(authedRequest("GET", "/leagues/devetry/members", "adam", ""))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+	var members MembersResponse
+	require.Nil(t, json.Unmarshal([]byte(res.Body), &members))
+	require.Equal(t, []mxtpdb.Member{
+		{UserId: "adam", Role: mxtpdb.RoleAdmin},
+		{UserId: "alice", Role: mxtpdb.RoleMember},
+		{UserId: "mia", Role: mxtpdb.RoleMember},
+		{UserId: "olive", Role: mxtpdb.RoleOwner},
+	}, members.Members)
+
+	res, err = JockeyHandler(authedRequest("DELETE", "/leagues/devetry/members/mia", "adam", ""))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+	res, err = JockeyHandler(authedRequest("DELETE", "/leagues/devetry/members/mia", "adam", ""))
+	require.Nil(t, err)
+	require.Equal(t, 404, res.StatusCode)
+}
+
+func TestGamesForLeagueRoles(t *testing.T) {
+	store, today := useMemoryStore(t)
+	require.Nil(t, store.PutMember("devetry", "olive", mxtpdb.RoleOwner))
+	require.Nil(t, store.PutMember("devetry", "adam", mxtpdb.RoleAdmin))
+	require.Nil(t, store.UpdateSong("devetry", today, "alice", "url-a", "sub-a", "", "", nil))
+	require.Nil(t, store.UpdateSong("devetry", today, "bob", "url-b", "sub-b", "", "", nil))
+
+	getGame := func(username string) Game {
+		res, err := JockeyHandler(authedRequest("GET", "/leagues/devetry/games/current", username, ""))
+		require.Nil(t, err)
+		require.Equal(t, 200, res.StatusCode)
+		var game Game
+		require.Nil(t, json.Unmarshal([]byte(res.Body), &game))
+		return game
+	}
+
+	owner := getGame("olive")
+	require.NotEmpty(t, owner.SpotifyAuthUrl)
+	require.Len(t, owner.SubmitThemeItems.Songs, 2)
+
+	admin := getGame("adam")
+	require.Empty(t, admin.SpotifyAuthUrl)
+	require.Len(t, admin.SubmitThemeItems.Songs, 2)
+}
+
+func TestPlayingRequiresMembership(t *testing.T) {
+	_, today := useMemoryStore(t)
+
+	requests := []events.APIGatewayProxyRequest{
+		authedRequest("POST", "/leagues/devetry/themes/"+today+"/songs", "stranger", `{"SongUrl": "https://youtu.be/dQw4w9WgXcQ"}`),
+		authedRequest("POST", "/leagues/devetry/themes/"+today+"/votes", "stranger", `{"SubmissionIds": ["a"]}`),
+		authedRequest("GET", "/leagues/devetry/games/current", "stranger", ""),
+		authedRequest("GET", "/leagues/devetry/themes/"+today+"/results", "stranger", ""),
+		authedRequest("GET", "/leagues/devetry/leaderboard", "stranger", ""),
+	}
+	for _, request := range requests {
+		res, err := JockeyHandler(request)
+		require.Nil(t, err)
+		require.Equal(t, 403, res.StatusCode, request.Path)
+	}
+
+	// anonymous requests must sign in first
+	res, err := JockeyHandler(events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: jockeyBase + "/leagues/devetry/games/current"})
+	require.Nil(t, err)
+	require.Equal(t, 401, res.StatusCode)
+}
+
+func TestClaimingLeagueBackfillsMembers(t *testing.T) {
+	store, today := useMemoryStore(t)
+	require.Nil(t, store.RemoveMember("devetry", "alice"))
+	require.Nil(t, store.UpdateSong("devetry", today, "alice", "url-a", "sub-a", "", "", nil))
+	require.Nil(t, store.UpdateVotes("devetry", today, "bob", []string{"sub-a"}))
+
+	// players of a league from before membership was stored can't play
+	res, err := JockeyHandler(authedRequest("GET", "/leagues/devetry/games/current", "alice", ""))
+	require.Nil(t, err)
+	require.Equal(t, 403, res.StatusCode)
+
+	res, err = JockeyHandler(adminRequest("POST", "/leagues/devetry/claim", ""))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+	var members MembersResponse
+	require.Nil(t, json.Unmarshal([]byte(res.Body), &members))
+	require.Equal(t, []mxtpdb.Member{
+		{UserId: "alice", Role: mxtpdb.RoleMember},
+		{UserId: "bob", Role: mxtpdb.RoleMember},
+		{UserId: "ted@devetry.com", Role: mxtpdb.RoleOwner},
+	}, members.Members)
+
+	res, err = JockeyHandler(authedRequest("GET", "/leagues/devetry/games/current", "alice", ""))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+
+	// members can't claim leagues
+	res, err = JockeyHandler(authedRequest("POST", "/leagues/devetry/claim", "alice", ""))
+	require.Nil(t, err)
+	require.Equal(t, 403, res.StatusCode)
+
+	res, err = JockeyHandler(adminRequest("POST", "/leagues/nope/claim", ""))
+	require.Nil(t, err)
+	require.Equal(t, 404, res.StatusCode)
+}
+
+// contextStore records the contexts its store is used with
+type contextStore struct {
+	mxtpdb.Store
+	contexts []context.Context
+}
+
+func (s *contextStore) WithContext(ctx context.Context) mxtpdb.Store {
+	s.contexts = append(s.contexts, ctx)
+	return s.Store
+}
+
+func TestLeagueRolesUseRequestContext(t *testing.T) {
+	store, _ := useMemoryStore(t)
+	recording := &contextStore{Store: store}
+	openStore = func() (mxtpdb.Store, error) { return recording, nil }
+
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "request")
+	res, err := JockeyHandlerContext(ctx, authedRequest("GET", "/leagues/devetry/games/current", "alice", ""))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+
+	// the role lookup and the handler both use the request's context
+	require.Len(t, recording.contexts, 2)
+	for _, used := range recording.contexts {
+		require.Equal(t, "request", used.Value(key{}))
+	}
+}
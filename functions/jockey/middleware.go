@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/macintoshpie/mxtp-fx/authtoken"
+	"github.com/macintoshpie/mxtp-fx/bouncer"
+)
+
+// recoverMiddleware turns a panicking handler into a 500 response.
+func recoverMiddleware(handler bouncer.ApiHandler) bouncer.ApiHandler {
+	return func(parameters map[string]string, request events.APIGatewayProxyRequest) (response *events.APIGatewayProxyResponse) {
+		defer func() {
+			if r := recover(); r != nil {
+				err := fmt.Errorf("recovered from panic handling %v %v: %v", request.HTTPMethod, request.Path, r)
+				response = newErrorResponse(request, err)
+			}
+		}()
+		return handler(parameters, request)
+	}
+}
+
+func logMiddleware(handler bouncer.ApiHandler) bouncer.ApiHandler {
+	return func(parameters map[string]string, request events.APIGatewayProxyRequest) *events.APIGatewayProxyResponse {
+		response := handler(parameters, request)
+		status := 0
+		if response != nil {
+			status = response.StatusCode
+		}
+		fmt.Printf("%v %v %v\n", request.HTTPMethod, request.Path, status)
+		return response
+	}
+}
+
+// corsMiddleware answers preflight requests and adds CORS headers to every
+// response.
+func corsMiddleware(handler bouncer.ApiHandler) bouncer.ApiHandler {
+	return func(parameters map[string]string, request events.APIGatewayProxyRequest) *events.APIGatewayProxyResponse {
+		var response *events.APIGatewayProxyResponse
+		if request.HTTPMethod == bouncer.Options {
+			response = newMessageResponse(204, "").toAPIGatewayProxyResponse()
+		} else {
+			response = handler(parameters, request)
+		}
+		if response == nil {
+			return nil
+		}
+
+		if response.Headers == nil {
+			response.Headers = make(map[string]string)
+		}
+		// TODO: restrict origins
+		response.Headers["Access-Control-Allow-Origin"] = "*"
+		response.Headers["Access-Control-Allow-Headers"] = "*"
+		response.Headers["Access-Control-Allow-Methods"] = "POST, GET, HEAD, PUT, PATCH, DELETE, OPTIONS"
+		response.Headers["Access-Control-Max-Age"] = "86400"
+		return response
+	}
+}
+
+// openSigner returns the signer used to issue and verify auth tokens. Tests
+// replace it with a signer using a fixed key.
+var openSigner = func() (*authtoken.Signer, error) {
+	return authtoken.FromEnv()
+}
+
+// bearerToken returns the token from the request's Authorization header.
+func bearerToken(request events.APIGatewayProxyRequest) (string, bool) {
+	authHeader := strings.TrimSpace(request.Headers["authorization"])
+	if authHeader == "" {
+		authHeader = strings.TrimSpace(request.Headers["Authorization"])
+	}
+	authParts := strings.Fields(authHeader)
+	if len(authParts) != 2 || strings.ToLower(authParts[0]) != "bearer" {
+		return "", false
+	}
+	return authParts[1], true
+}
+
+// authMiddleware verifies the bearer token and sets parameters["username"] to
+// the token's subject, and parameters["ADMIN"] if it has the admin claim.
+// Requests without a valid token are passed on anonymously, so public routes
+// still work with an expired one, and routes that need a user reject them.
+func authMiddleware(handler bouncer.ApiHandler) bouncer.ApiHandler {
+	return func(parameters map[string]string, request events.APIGatewayProxyRequest) *events.APIGatewayProxyResponse {
+		// these must only ever come from a verified token
+		delete(parameters, "username")
+		delete(parameters, "ADMIN")
+		delete(parameters, "leagueRole")
+
+		token, ok := bearerToken(request)
+		if !ok {
+			return handler(parameters, request)
+		}
+
+		signer, err := openSigner()
+		if err != nil {
+			return newErrorResponse(request, fmt.Errorf("failed to load token signer: %w", err))
+		}
+
+		claims, err := signer.Verify(token)
+		if err != nil {
+			fmt.Println("WARNING: ignoring auth token: ", err.Error())
+			return handler(parameters, request)
+		}
+
+		parameters["username"] = claims.Subject
+		if claims.Admin {
+			parameters["ADMIN"] = "indeed"
+		}
+		return handler(parameters, request)
+	}
+}
+
+// principal is who authMiddleware authenticated the request as
+func principal(parameters map[string]string) bouncer.Principal {
+	return bouncer.Principal{
+		Username: parameters["username"],
+		Admin:    parameters["ADMIN"] != "",
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/macintoshpie/mxtp-fx/music"
+	"github.com/macintoshpie/mxtp-fx/music/spotifytest"
+	"github.com/macintoshpie/mxtp-fx/mxtpdb"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+// useSpotifyServer points the handlers' Spotify clients at a fake Spotify
+// server and makes ted the league owner, with a connected account whose
+// access token has expired. Close the server when done with it.
+func useSpotifyServer(t *testing.T, store *mxtpdb.MemoryStore) *spotifytest.Server {
+	server := spotifytest.NewServer()
+	spotifyHTTPClient = &http.Client{Transport: server.Transport()}
+
+	require.Nil(t, store.PutMember("devetry", "ted", mxtpdb.RoleOwner))
+	require.Nil(t, store.UpdateSpotifyToken(&oauth2.Token{
+		AccessToken:  "expired",
+		TokenType:    "Bearer",
+		RefreshToken: "refresh",
+		Expiry:       time.Now().Add(-time.Hour),
+	}, "ted"))
+	return server
+}
+
+func TestPostSpotifySong(t *testing.T) {
+	store, today := useMemoryStore(t)
+	server := useSpotifyServer(t, store)
+	defer server.Close()
+	server.AddTrack(music.Track{Id: "4uLU6hMCjMI75M1A2tKUQC", Name: "Never Gonna Give You Up", Artists: []string{"Rick Astley"}})
+
+	res, err := JockeyHandler(authedRequest("POST", "/leagues/devetry/themes/"+today+"/songs", "alice", `{"SongUrl": "https://open.spotify.com/track/4uLU6hMCjMI75M1A2tKUQC?si=abc"}`))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+
+	song, err := store.GetSong("devetry", today, "alice")
+	require.Nil(t, err)
+	require.Equal(t, "https://open.spotify.com/track/4uLU6hMCjMI75M1A2tKUQC", song.SongUrl)
+	require.Equal(t, "4uLU6hMCjMI75M1A2tKUQC", song.SpotifyTrackId)
+	require.Equal(t, "Never Gonna Give You Up", song.Name)
+	require.Equal(t, []string{"Rick Astley"}, song.Artists)
+
+	// unknown tracks are rejected
+	res, err = JockeyHandler(authedRequest("POST", "/leagues/devetry/themes/"+today+"/songs", "alice", `{"SongUrl": "spotify:track:1DFixLWuPkv3KT3TnV35m3"}`))
+	require.Nil(t, err)
+	require.Equal(t, 400, res.StatusCode)
+}
+
+func TestSpotifyRequestsUseRequestContext(t *testing.T) {
+	store, today := useMemoryStore(t)
+	server := useSpotifyServer(t, store)
+	defer server.Close()
+	server.AddTrack(music.Track{Id: "4uLU6hMCjMI75M1A2tKUQC", Name: "Never Gonna Give You Up"})
+
+	// the client went away, so the track isn't looked up
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res, err := JockeyHandlerContext(ctx, authedRequest("POST", "/leagues/devetry/themes/"+today+"/songs", "alice", `{"SongUrl": "https://open.spotify.com/track/4uLU6hMCjMI75M1A2tKUQC"}`))
+	require.Nil(t, err)
+	require.Equal(t, 400, res.StatusCode)
+	require.Empty(t, server.Calls())
+}
+
+func TestBuildPlaylist(t *testing.T) {
+	store, today := useMemoryStore(t)
+	require.Nil(t, store.UpdateSong("devetry", today, "alice", "url-a", "sub-a", "track-a", "A", nil))
+	require.Nil(t, store.UpdateSong("devetry", today, "bob", "url-b", "sub-b", "", "", nil))
+	require.Nil(t, store.UpdateSong("devetry", today, "carl", "url-c", "sub-c", "track-c", "C", nil))
+	server := useSpotifyServer(t, store)
+	defer server.Close()
+
+	// the theme gets a new playlist
+	res, err := JockeyHandler(bearerRequest("POST", "/leagues/devetry/buildPlaylist", issueToken("ted", false), ""))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+	var response PlaylistSyncResponse
+	require.Nil(t, json.Unmarshal([]byte(res.Body), &response))
+	require.Equal(t, "playlist-1", response.PlaylistId)
+	require.Equal(t, []string{"track-a", "track-c"}, response.Report.Added)
+	require.Empty(t, response.Report.Failed)
+
+	theme, err := store.GetTheme("devetry", today)
+	require.Nil(t, err)
+	require.Equal(t, "playlist-1", theme.SpotifyPlaylistId)
+
+	// songs without a spotify track are skipped
+	playlist, ok := server.Playlist("playlist-1")
+	require.True(t, ok)
+	require.Equal(t, "submit", playlist.Name)
+	require.Equal(t, []string{"track-a", "track-c"}, playlist.TrackIds)
+
+	// later builds sync the same playlist, retrying rate limited requests
+	require.Nil(t, store.UpdateSong("devetry", today, "carl", "url-b", "sub-c", "track-b", "B", nil))
+	server.RateLimit(1)
+	res, err = JockeyHandler(bearerRequest("POST", "/leagues/devetry/buildPlaylist", issueToken("ted", false), ""))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+	response = PlaylistSyncResponse{}
+	require.Nil(t, json.Unmarshal([]byte(res.Body), &response))
+	require.Equal(t, "playlist-1", response.PlaylistId)
+	require.Equal(t, []string{"track-c"}, response.Report.Removed)
+	require.Equal(t, []string{"track-b"}, response.Report.Added)
+	playlist, _ = server.Playlist("playlist-1")
+	require.Equal(t, []string{"track-a", "track-b"}, playlist.TrackIds)
+
+	// an up to date playlist isn't changed
+	res, err = JockeyHandler(bearerRequest("POST", "/leagues/devetry/buildPlaylist", issueToken("ted", false), ""))
+	require.Nil(t, err)
+	response = PlaylistSyncResponse{}
+	require.Nil(t, json.Unmarshal([]byte(res.Body), &response))
+	require.Equal(t, music.SyncReport{}, response.Report)
+}
+
+func TestSpotifyTokenRefreshIsSaved(t *testing.T) {
+	store, _ := useMemoryStore(t)
+	server := useSpotifyServer(t, store)
+	defer server.Close()
+
+	for i := 0; i < 2; i++ {
+		res, err := JockeyHandler(bearerRequest("POST", "/leagues/devetry/buildPlaylist", issueToken("ted", false), ""))
+		require.Nil(t, err)
+		require.Equal(t, 200, res.StatusCode)
+	}
+
+	token, err := store.GetSpotifyToken("ted")
+	require.Nil(t, err)
+	require.Equal(t, "access-1", token.AccessToken)
+	require.Equal(t, "refresh", token.RefreshToken)
+	require.True(t, token.Expiry.After(time.Now()))
+
+	// the saved token is reused by the second request
+	refreshes := 0
+	for _, call := range server.Calls() {
+		if call.Path == "api/token" {
+			refreshes++
+		} else {
+			require.Equal(t, "Bearer access-1", call.Authorization)
+		}
+	}
+	require.Equal(t, 1, refreshes)
+}
+
+func TestSpotifyRevokedToken(t *testing.T) {
+	store, today := useMemoryStore(t)
+	server := useSpotifyServer(t, store)
+	defer server.Close()
+	server.RevokeRefreshToken("refresh")
+
+	res, err := JockeyHandler(bearerRequest("POST", "/leagues/devetry/buildPlaylist", issueToken("ted", false), ""))
+	require.Nil(t, err)
+	require.Equal(t, 409, res.StatusCode)
+	required, err := store.SpotifyReauthRequired("ted")
+	require.Nil(t, err)
+	require.True(t, required)
+
+	// admins are told, everyone else isn't
+	res, err = JockeyHandler(bearerRequest("GET", "/leagues/devetry/games/current", issueToken("ted", false), ""))
+	require.Nil(t, err)
+	var game Game
+	require.Nil(t, json.Unmarshal([]byte(res.Body), &game))
+	require.True(t, game.SpotifyReauthRequired)
+	require.NotEmpty(t, game.SpotifyAuthUrl)
+
+	res, err = JockeyHandler(authedRequest("GET", "/leagues/devetry/games/current", "alice", ""))
+	require.Nil(t, err)
+	game = Game{}
+	require.Nil(t, json.Unmarshal([]byte(res.Body), &game))
+	require.False(t, game.SpotifyReauthRequired)
+
+	// songs are still accepted, without their details
+	res, err = JockeyHandler(authedRequest("POST", "/leagues/devetry/themes/"+today+"/songs", "alice", `{"SongUrl": "spotify:track:4uLU6hMCjMI75M1A2tKUQC"}`))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+	song, err := store.GetSong("devetry", today, "alice")
+	require.Nil(t, err)
+	require.Equal(t, "4uLU6hMCjMI75M1A2tKUQC", song.SpotifyTrackId)
+	require.Empty(t, song.Name)
+
+	// connecting again clears the flag
+	require.Nil(t, store.UpdateSpotifyToken(&oauth2.Token{AccessToken: "new", RefreshToken: "new-refresh", Expiry: time.Now().Add(time.Hour)}, "ted"))
+	res, err = JockeyHandler(bearerRequest("POST", "/leagues/devetry/buildPlaylist", issueToken("ted", false), ""))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+}
+
+func TestSpotifyAccountMissing(t *testing.T) {
+	store, today := useMemoryStore(t)
+	songUrl := `{"SongUrl": "spotify:track:4uLU6hMCjMI75M1A2tKUQC"}`
+
+	// songs are accepted without their details when the league has no owner
+	res, err := JockeyHandler(authedRequest("POST", "/leagues/devetry/themes/"+today+"/songs", "alice", songUrl))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+
+	// or its owner never connected Spotify
+	require.Nil(t, store.PutMember("devetry", "ted", mxtpdb.RoleOwner))
+	res, err = JockeyHandler(authedRequest("POST", "/leagues/devetry/themes/"+today+"/songs", "alice", songUrl))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+	song, err := store.GetSong("devetry", today, "alice")
+	require.Nil(t, err)
+	require.Equal(t, "4uLU6hMCjMI75M1A2tKUQC", song.SpotifyTrackId)
+	require.Empty(t, song.Name)
+
+	// but playlists can't be built
+	res, err = JockeyHandler(bearerRequest("POST", "/leagues/devetry/buildPlaylist", issueToken("ted", false), ""))
+	require.Nil(t, err)
+	require.Equal(t, 409, res.StatusCode)
+}
+
+func TestBuildVotingThemePlaylist(t *testing.T) {
+	store, _ := useMemoryStore(t)
+	league, err := store.GetLeague("devetry")
+	require.Nil(t, err)
+	voteTheme := league.VoteTheme.Date
+	require.Nil(t, store.UpdateSong("devetry", voteTheme, "alice", "url-a", "sub-a", "track-a", "A", nil))
+	server := useSpotifyServer(t, store)
+	defer server.Close()
+
+	res, err := JockeyHandler(bearerRequest("POST", "/leagues/devetry/buildPlaylist", issueToken("ted", false), `{"ThemeId": "`+voteTheme+`"}`))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+	theme, err := store.GetTheme("devetry", voteTheme)
+	require.Nil(t, err)
+	playlist, ok := server.Playlist(theme.SpotifyPlaylistId)
+	require.True(t, ok)
+	require.Equal(t, "vote", playlist.Name)
+	require.Equal(t, []string{"track-a"}, playlist.TrackIds)
+
+	res, err = JockeyHandler(bearerRequest("POST", "/leagues/devetry/buildPlaylist", issueToken("ted", false), `{"ThemeId": "2001-01-01"}`))
+	require.Nil(t, err)
+	require.Equal(t, 404, res.StatusCode)
+}
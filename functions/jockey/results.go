@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/macintoshpie/mxtp-fx/bouncer"
+	"github.com/macintoshpie/mxtp-fx/mxtpdb"
+	"github.com/macintoshpie/mxtp-fx/scoring"
+)
+
+type LeaderboardResponse struct {
+	// From and To are the season's bounds on theme dates, if any
+	From        string `json:",omitempty"`
+	To          string `json:",omitempty"`
+	Leaderboard scoring.Leaderboard
+}
+
+type ResultsResponse struct {
+	Theme   mxtpdb.Theme
+	Results scoring.Results
+}
+
+// getResultsHandler returns the tallied votes for a theme once its vote phase
+// has closed.
+func getResultsHandler(c *bouncer.Context) (*events.APIGatewayProxyResponse, error) {
+	if _, err := requireUser(c); err != nil {
+		return nil, err
+	}
+	leagueName := c.Parameters["leagueName"]
+	themeId := c.Parameters["themeId"]
+
+	db, err := storeFor(c)
+	if err != nil {
+		return nil, err
+	}
+
+	theme, err := scheduledTheme(db, leagueName, themeId)
+	if err == mxtpdb.ErrNotFound {
+		return nil, errNotFound("Theme not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if theme.PhaseAt(time.Now()) != mxtpdb.PhaseClosed {
+		return nil, errForbidden("Results are hidden until voting closes")
+	}
+
+	themeItems, err := db.GetThemeItems(leagueName, themeId)
+	if err != nil {
+		return nil, err
+	}
+
+	results := scoring.Tally(themeItems)
+	results.ThemeId = themeId
+	response := jsonResponse{
+		content: ResultsResponse{
+			Theme:   theme,
+			Results: results,
+		},
+		status: 200,
+	}
+	return response.toAPIGatewayProxyResponse(), nil
+}
+
+// SeasonRequest bounds a season by theme date (inclusive)
+type SeasonRequest struct {
+	From string `query:"from"`
+	To   string `query:"to"`
+}
+
+// getLeaderboardHandler aggregates the results of every theme in the league
+// whose voting has closed. The optional "from" and "to" query parameters bound
+// the season by theme date (inclusive).
+func getLeaderboardHandler(c *bouncer.Context) (*events.APIGatewayProxyResponse, error) {
+	if _, err := requireUser(c); err != nil {
+		return nil, err
+	}
+	leagueName := c.Parameters["leagueName"]
+
+	var season SeasonRequest
+	if err := c.Bind(&season); err != nil {
+		return nil, err
+	}
+	from, to := season.From, season.To
+	for _, bound := range []string{from, to} {
+		if bound == "" {
+			continue
+		}
+		if _, err := time.Parse(mxtpdb.ThemeDateFormat, bound); err != nil {
+			return nil, errBadRequest("Season bounds must be dates formatted as " + mxtpdb.ThemeDateFormat)
+		}
+	}
+
+	db, err := storeFor(c)
+	if err != nil {
+		return nil, err
+	}
+
+	league, err := db.GetLeague(leagueName)
+	if err == mxtpdb.ErrNotFound {
+		return nil, errNotFound("League not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	themes, err := db.GetThemes(leagueName)
+	if err != nil {
+		return nil, err
+	}
+
+	// themes are ordered by date, which the leaderboard needs for streaks
+	now := time.Now()
+	var results []scoring.Results
+	for _, theme := range themes {
+		if (from != "" && theme.Date < from) || (to != "" && theme.Date > to) {
+			continue
+		}
+		theme, err = league.ScheduleTheme(theme)
+		if err != nil {
+			return nil, fmt.Errorf("failed to schedule theme: %w", err)
+		}
+		if theme.PhaseAt(now) != mxtpdb.PhaseClosed {
+			continue
+		}
+
+		themeItems, err := db.GetThemeItems(leagueName, theme.Date)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, scoring.Tally(themeItems))
+	}
+
+	response := jsonResponse{
+		content: LeaderboardResponse{
+			From:        from,
+			To:          to,
+			Leaderboard: scoring.NewLeaderboard(results),
+		},
+		status: 200,
+	}
+	return response.toAPIGatewayProxyResponse(), nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/macintoshpie/mxtp-fx/mxtpdb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResults(t *testing.T) {
+	store, today := useMemoryStore(t)
+	require.Nil(t, store.PutTheme("devetry", mxtpdb.Theme{Name: "old", Date: "2020-01-01"}))
+	require.Nil(t, store.UpdateSong("devetry", "2020-01-01", "alice", "url-a", "sub-a", "", "", nil))
+	require.Nil(t, store.UpdateSong("devetry", "2020-01-01", "bob", "url-b", "sub-b", "", "", nil))
+	require.Nil(t, store.UpdateVotes("devetry", "2020-01-01", "alice", []string{"sub-b"}))
+	require.Nil(t, store.UpdateVotes("devetry", "2020-01-01", "bob", []string{"sub-b"}))
+
+	res, err := JockeyHandler(authedRequest("GET", "/leagues/devetry/themes/2020-01-01/results", "alice", ""))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+
+	var results ResultsResponse
+	require.Nil(t, json.Unmarshal([]byte(res.Body), &results))
+	require.Equal(t, "old", results.Theme.Name)
+	require.Equal(t, 2, results.Results.Voters)
+	require.Len(t, results.Results.Standings, 2)
+	require.Equal(t, "bob", results.Results.Standings[0].UserId)
+	require.Equal(t, 2, results.Results.Standings[0].Votes)
+
+	// the current theme is still open
+	res, err = JockeyHandler(authedRequest("GET", "/leagues/devetry/themes/"+today+"/results", "alice", ""))
+	require.Nil(t, err)
+	require.Equal(t, 403, res.StatusCode)
+
+	res, err = JockeyHandler(authedRequest("GET", "/leagues/devetry/themes/2019-01-01/results", "alice", ""))
+	require.Nil(t, err)
+	require.Equal(t, 404, res.StatusCode)
+}
+
+func TestLeaderboard(t *testing.T) {
+	store, today := useMemoryStore(t)
+	for _, themeId := range []string{"2020-01-01", "2020-01-15", "2020-02-01"} {
+		require.Nil(t, store.PutTheme("devetry", mxtpdb.Theme{Name: themeId, Date: themeId}))
+		require.Nil(t, store.UpdateSong("devetry", themeId, "alice", "url-a", "a"+themeId, "", "", nil))
+		require.Nil(t, store.UpdateSong("devetry", themeId, "bob", "url-b", "b"+themeId, "", "", nil))
+		require.Nil(t, store.UpdateVotes("devetry", themeId, "carl", []string{"a" + themeId}))
+	}
+	// carl changes his vote so bob wins the last theme, and the open theme isn't counted
+	require.Nil(t, store.UpdateVotes("devetry", "2020-02-01", "alice", []string{"b2020-02-01"}))
+	require.Nil(t, store.UpdateVotes("devetry", "2020-02-01", "carl", []string{"b2020-02-01"}))
+	require.Nil(t, store.UpdateSong("devetry", today, "bob", "url-b", "b-today", "", "", nil))
+	require.Nil(t, store.UpdateVotes("devetry", today, "alice", []string{"b-today"}))
+
+	res, err := JockeyHandler(authedRequest("GET", "/leagues/devetry/leaderboard", "alice", ""))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+	var leaderboard LeaderboardResponse
+	require.Nil(t, json.Unmarshal([]byte(res.Body), &leaderboard))
+	require.Equal(t, 3, leaderboard.Leaderboard.Themes)
+	require.Equal(t, "alice", leaderboard.Leaderboard.Entries[0].UserId)
+	require.Equal(t, 2, leaderboard.Leaderboard.Entries[0].Points)
+	require.Equal(t, 2, leaderboard.Leaderboard.Entries[0].Wins)
+	require.Equal(t, "bob", leaderboard.Leaderboard.Entries[1].UserId)
+	require.Equal(t, 2, leaderboard.Leaderboard.Entries[1].Points)
+	require.Equal(t, 1, leaderboard.Leaderboard.Entries[1].Wins)
+
+	request := authedRequest("GET", "/leagues/devetry/leaderboard", "alice", "")
+	request.QueryStringParameters = map[string]string{"from": "2020-01-10", "to": "2020-01-31"}
+	res, err = JockeyHandler(request)
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+	leaderboard = LeaderboardResponse{}
+	require.Nil(t, json.Unmarshal([]byte(res.Body), &leaderboard))
+	require.Equal(t, "2020-01-10", leaderboard.From)
+	require.Equal(t, 1, leaderboard.Leaderboard.Themes)
+	require.Equal(t, "alice", leaderboard.Leaderboard.Entries[0].UserId)
+
+	request.QueryStringParameters = map[string]string{"from": "last year"}
+	res, err = JockeyHandler(request)
+	require.Nil(t, err)
+	require.Equal(t, 400, res.StatusCode)
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/macintoshpie/mxtp-fx/bouncer"
+	"github.com/macintoshpie/mxtp-fx/mxtpdb"
+)
+
+// slackSigningSecret is the Slack app's signing secret, used to verify that
+// slash commands come from Slack. Tests replace it.
+var slackSigningSecret = os.Getenv("SLACK_SIGNING_SECRET")
+
+// slackMaxAge is how far a request's timestamp may be from now before it is
+// rejected as a possible replay
+const slackMaxAge = 5 * time.Minute
+
+var errSlackSignature = errors.New("Invalid Slack signature")
+
+// SlackUserRequest links a Slack user to a league user
+type SlackUserRequest struct {
+	UserId string `validate:"required,max=100"`
+}
+
+// SlackResponse is the reply to a slash command. Ephemeral replies are only
+// shown to the user who ran the command.
+type SlackResponse struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+// headerValue returns the named header, ignoring the case of its name
+func headerValue(headers map[string]string, name string) string {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// verifySlackRequest checks the request's signature, which is "v0=" followed
+// by the hex HMAC-SHA256 of "v0:{timestamp}:{body}" keyed with the signing
+// secret.
+func verifySlackRequest(secret string, headers map[string]string, body string, now time.Time) error {
+	timestamp := headerValue(headers, "X-Slack-Request-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errSlackSignature
+	}
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > slackMaxAge || age < -slackMaxAge {
+		return fmt.Errorf("Slack request timestamp %v is too old", timestamp)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":" + body))
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(headerValue(headers, "X-Slack-Signature"))) {
+		return errSlackSignature
+	}
+	return nil
+}
+
+func slackReply(text string) *events.APIGatewayProxyResponse {
+	response := jsonResponse{
+		content: SlackResponse{
+			ResponseType: "ephemeral",
+			Text:         text,
+		},
+		status: 200,
+	}
+	apiResponse := response.toAPIGatewayProxyResponse()
+	// Slack shows the body as plain text without it
+	apiResponse.Headers["Content-Type"] = "application/json"
+	return apiResponse
+}
+
+// slackMessage tells the Slack user how submitSong or submitVotes went
+func slackMessage(err error, success string) string {
+	if err == nil {
+		return success
+	}
+	apiErr := toAPIError(err)
+	if apiErr.Status >= 500 {
+		fmt.Println("ERROR: ", err.Error())
+		return "Something went wrong"
+	}
+	return apiErr.Message
+}
+
+func slackHelp(command string) string {
+	return strings.Join([]string{
+		command + " theme: show the themes open for submissions and votes",
+		command + " submit <link>: submit a song for the current theme",
+		command + " vote: list the songs you can vote for",
+		command + " vote <numbers>: vote for songs by their numbers in the list",
+	}, "\n")
+}
+
+func slackThemeText(league mxtpdb.League) string {
+	const deadlineFormat = "Mon Jan 2 15:04 MST"
+	var lines []string
+	if theme := league.SubmitTheme; theme.Date != "" {
+		lines = append(lines, fmt.Sprintf("Submit a song for %v by %v.", theme.Name, theme.SubmitClose.UTC().Format(deadlineFormat)))
+		if theme.Description != "" {
+			lines = append(lines, theme.Description)
+		}
+	}
+	if theme := league.VoteTheme; theme.Date != "" {
+		lines = append(lines, fmt.Sprintf("Vote for %v by %v.", theme.Name, theme.VoteClose.UTC().Format(deadlineFormat)))
+	}
+	if len(lines) == 0 {
+		return "No themes are open right now."
+	}
+	return strings.Join(lines, "\n")
+}
+
+// slackLink returns the url from a link as Slack escapes it, e.g.
+// <https://example.com|example.com>
+func slackLink(arg string) string {
+	link := strings.TrimSuffix(strings.TrimPrefix(arg, "<"), ">")
+	return strings.SplitN(link, "|", 2)[0]
+}
+
+// ballot returns the theme's songs in the order they are numbered for voting
+// from Slack. Songs are ordered by their random submission ids so the order
+// says nothing about who submitted them.
+func ballot(db mxtpdb.Store, leagueName, themeId string) ([]mxtpdb.Song, error) {
+	items, err := db.GetThemeItems(leagueName, themeId)
+	if err != nil {
+		return nil, err
+	}
+
+	songs := items.Songs
+	sort.Slice(songs, func(i, j int) bool {
+		return songs[i].SubmissionId < songs[j].SubmissionId
+	})
+	return songs, nil
+}
+
+func slackVote(db mxtpdb.Store, league mxtpdb.League, username string, args []string) string {
+	theme := league.VoteTheme
+	if theme.Date == "" {
+		return "No theme is open for voting."
+	}
+
+	songs, err := ballot(db, league.Name, theme.Date)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return "Something went wrong"
+	}
+	if len(songs) == 0 {
+		return fmt.Sprintf("Nobody submitted a song for %v.", theme.Name)
+	}
+
+	if len(args) == 0 {
+		lines := []string{fmt.Sprintf("Songs for %v:", theme.Name)}
+		for i, song := range songs {
+			description := song.SongUrl
+			if song.Name != "" {
+				description = fmt.Sprintf("%v by %v %v", song.Name, strings.Join(song.Artists, ", "), song.SongUrl)
+			}
+			lines = append(lines, fmt.Sprintf("%v. %v", i+1, description))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	var submissionIds []string
+	for _, arg := range args {
+		number, err := strconv.Atoi(strings.Trim(arg, ","))
+		if err != nil || number < 1 || number > len(songs) {
+			return fmt.Sprintf("%v isn't a song number, pick from 1 to %v.", arg, len(songs))
+		}
+		submissionIds = append(submissionIds, songs[number-1].SubmissionId)
+	}
+
+	err = submitVotes(db, league.Name, theme.Date, username, submissionIds)
+	return slackMessage(err, "Successfully updated votes")
+}
+
+func slackSubmit(ctx context.Context, db mxtpdb.Store, league mxtpdb.League, username string, args []string) string {
+	theme := league.SubmitTheme
+	if theme.Date == "" {
+		return "No theme is open for submissions."
+	}
+	if len(args) != 1 {
+		return "Submit a single link to your song."
+	}
+
+	err := submitSong(ctx, db, league.Name, theme.Date, username, slackLink(args[0]))
+	return slackMessage(err, "Successfully put submission")
+}
+
+// postSlackCommandHandler handles a league's slash command. Submitting and
+// voting act as the league user the Slack user was linked to.
+func postSlackCommandHandler(c *bouncer.Context) (*events.APIGatewayProxyResponse, error) {
+	if slackSigningSecret == "" {
+		return nil, errors.New("SLACK_SIGNING_SECRET is not set")
+	}
+
+	body := c.Request.Body
+	if c.Request.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return nil, errBadRequest("Bad slash command")
+		}
+		body = string(decoded)
+	}
+
+	err := verifySlackRequest(slackSigningSecret, c.Request.Headers, body, time.Now())
+	if err != nil {
+		fmt.Println("WARNING: rejected slack request: ", err.Error())
+		return nil, errUnauthorized(errSlackSignature.Error())
+	}
+
+	form, err := url.ParseQuery(body)
+	if err != nil {
+		return nil, errBadRequest("Bad slash command")
+	}
+	command := form.Get("command")
+	if command == "" {
+		command = "/mxtp"
+	}
+
+	db, err := storeFor(c)
+	if err != nil {
+		return nil, err
+	}
+
+	league, err := db.GetLeague(c.Parameters["leagueName"])
+	if err == mxtpdb.ErrNotFound {
+		return slackReply("League not found."), nil
+	}
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return slackReply("Something went wrong"), nil
+	}
+
+	args := strings.Fields(form.Get("text"))
+	if len(args) == 0 {
+		return slackReply(slackHelp(command)), nil
+	}
+	switch args[0] {
+	case "theme":
+		return slackReply(slackThemeText(league)), nil
+	case "submit", "vote":
+	default:
+		return slackReply(slackHelp(command)), nil
+	}
+
+	slackUserId := form.Get("user_id")
+	username, err := db.GetSlackUser(league.Name, slackUserId)
+	if err == mxtpdb.ErrNotFound {
+		return slackReply(fmt.Sprintf("Your Slack account isn't linked to %v yet. Ask a league admin to link Slack user %v.", league.Name, slackUserId)), nil
+	}
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return slackReply("Something went wrong"), nil
+	}
+
+	// the linked user may have left the league since
+	_, err = db.GetMember(league.Name, username)
+	if err == mxtpdb.ErrNotFound {
+		return slackReply(fmt.Sprintf("You're not a member of %v.", league.Name)), nil
+	}
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return slackReply("Something went wrong"), nil
+	}
+
+	if args[0] == "submit" {
+		return slackReply(slackSubmit(c, db, league, username, args[1:])), nil
+	}
+	return slackReply(slackVote(db, league, username, args[1:])), nil
+}
+
+func putSlackUserHandler(c *bouncer.Context) (*events.APIGatewayProxyResponse, error) {
+	var slackUserRequest SlackUserRequest
+	if err := c.Bind(&slackUserRequest); err != nil {
+		return nil, err
+	}
+	userId := strings.ToLower(slackUserRequest.UserId)
+	if strings.Contains(userId, "#") {
+		return nil, errBadRequest("Invalid user id")
+	}
+
+	db, err := storeFor(c)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.PutSlackUser(c.Parameters["leagueName"], c.Parameters["slackUserId"], userId)
+	if err != nil {
+		fmt.Println("ERROR: failed to put slack user: ", err.Error())
+		return nil, errBadRequest("Bad Slack user")
+	}
+
+	return newMessageResponse(200, "Successfully linked Slack user").toAPIGatewayProxyResponse(), nil
+}
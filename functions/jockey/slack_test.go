@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/macintoshpie/mxtp-fx/mxtpdb"
+	"github.com/stretchr/testify/require"
+)
+
+const testSlackSecret = "slack secret"
+
+// slackRequest returns a slash command request signed at the given time
+func slackRequest(slackUserId, text string, signedAt time.Time) events.APIGatewayProxyRequest {
+	body := url.Values{
+		"command": {"/mxtp"},
+		"team_id": {"T1"},
+		"user_id": {slackUserId},
+		"text":    {text},
+	}.Encode()
+	timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(testSlackSecret))
+	mac.Write([]byte("v0:" + timestamp + ":" + body))
+
+	return events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Path:       jockeyBase + "/leagues/devetry/slack",
+		Headers: map[string]string{
+			"Content-Type":              "application/x-www-form-urlencoded",
+			"X-Slack-Request-Timestamp": timestamp,
+			"X-Slack-Signature":         "v0=" + hex.EncodeToString(mac.Sum(nil)),
+		},
+		Body: body,
+	}
+}
+
+// slackCommand runs the command as the Slack user and returns the reply text
+func slackCommand(t *testing.T, slackUserId, text string) string {
+	res, err := JockeyHandler(slackRequest(slackUserId, text, time.Now()))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+	require.Equal(t, "application/json", res.Headers["Content-Type"])
+
+	var reply SlackResponse
+	require.Nil(t, json.Unmarshal([]byte(res.Body), &reply))
+	require.Equal(t, "ephemeral", reply.ResponseType)
+	return reply.Text
+}
+
+func TestSlackSignatures(t *testing.T) {
+	useMemoryStore(t)
+	slackSigningSecret = testSlackSecret
+
+	res, err := JockeyHandler(slackRequest("U1", "theme", time.Now()))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+
+	// replayed requests are rejected
+	res, err = JockeyHandler(slackRequest("U1", "theme", time.Now().Add(-10*time.Minute)))
+	require.Nil(t, err)
+	require.Equal(t, 401, res.StatusCode)
+
+	request := slackRequest("U1", "theme", time.Now())
+	request.Body += "&extra=1"
+	res, err = JockeyHandler(request)
+	require.Nil(t, err)
+	require.Equal(t, 401, res.StatusCode)
+
+	slackSigningSecret = "other secret"
+	res, err = JockeyHandler(slackRequest("U1", "theme", time.Now()))
+	require.Nil(t, err)
+	require.Equal(t, 401, res.StatusCode)
+}
+
+func TestSlackCommands(t *testing.T) {
+	store, today := useMemoryStore(t)
+	slackSigningSecret = testSlackSecret
+	require.Nil(t, store.PutMember("devetry", "adam", mxtpdb.RoleAdmin))
+	league, err := store.GetLeague("devetry")
+	require.Nil(t, err)
+	voteTheme := league.VoteTheme.Date
+
+	require.Contains(t, slackCommand(t, "U1", ""), "/mxtp submit <link>")
+	text := slackCommand(t, "U1", "theme")
+	require.Contains(t, text, "Submit a song for submit by")
+	require.Contains(t, text, "Vote for vote by")
+
+	// slack users must be linked before submitting
+	require.Contains(t, slackCommand(t, "U1", "submit https://youtu.be/dQw4w9WgXcQ"), "Ask a league admin to link Slack user U1")
+
+	res, err := JockeyHandler(authedRequest("PUT", "/leagues/devetry/slack/users/U1", "mia", `{"UserId": "alice"}`))
+	require.Nil(t, err)
+	require.Equal(t, 403, res.StatusCode)
+	res, err = JockeyHandler(authedRequest("PUT", "/leagues/devetry/slack/users/U1", "adam", `{"UserId": "Alice"}`))
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+
+	require.Equal(t, "Successfully put submission", slackCommand(t, "U1", "submit <https://youtu.be/dQw4w9WgXcQ>"))
+	song, err := store.GetSong("devetry", today, "alice")
+	require.Nil(t, err)
+	require.Equal(t, "https://www.youtube.com/watch?v=dQw4w9WgXcQ", song.SongUrl)
+
+	// bad links get the same errors as the site
+	require.Contains(t, slackCommand(t, "U1", "submit https://example.com/song"), "Unsupported music link")
+
+	// votes are for songs numbered in the listing
+	require.Nil(t, store.UpdateSong("devetry", voteTheme, "bob", "https://youtu.be/b", "sub-b", "", "", nil))
+	require.Nil(t, store.UpdateSong("devetry", voteTheme, "carl", "https://youtu.be/c", "sub-a", "", "Song C", []string{"Carl"}))
+	text = slackCommand(t, "U1", "vote")
+	require.Contains(t, text, "1. Song C by Carl https://youtu.be/c")
+	require.Contains(t, text, "2. https://youtu.be/b")
+
+	require.Contains(t, slackCommand(t, "U1", "vote 3"), "pick from 1 to 2")
+	require.Equal(t, "Successfully updated votes", slackCommand(t, "U1", "vote 2, 1"))
+	items, err := store.GetThemeItems("devetry", voteTheme)
+	require.Nil(t, err)
+	require.Equal(t, []mxtpdb.Votes{{UserId: "alice", SubmissionIds: []string{"sub-b", "sub-a"}}}, items.Votes)
+
+	// linked users must still be members
+	require.Nil(t, store.PutSlackUser("devetry", "U2", "stranger"))
+	require.Equal(t, "You're not a member of devetry.", slackCommand(t, "U2", "submit https://youtu.be/dQw4w9WgXcQ"))
+	require.Equal(t, "You're not a member of devetry.", slackCommand(t, "U2", "vote 1"))
+	_, err = store.GetSong("devetry", today, "stranger")
+	require.Equal(t, mxtpdb.ErrNotFound, err)
+}
@@ -1,6 +1,11 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/macintoshpie/mxtp-fx/music"
 	"github.com/macintoshpie/mxtp-fx/mxtpdb"
 	"github.com/zmb3/spotify"
 )
@@ -9,13 +14,63 @@ const redirectURI = "https://www.mxtp.xyz/.netlify/functions/jockey/callback"
 
 var Auth = spotify.NewAuthenticator(redirectURI, spotify.ScopePlaylistModifyPublic)
 
-func NewClient(db *mxtpdb.DB, userId string) (*spotify.Client, error) {
-	tok, err := db.GetSpotifyToken(userId)
+var spotifyConfig = music.SpotifyConfig(redirectURI)
+
+// spotifyHTTPClient sends the requests of Spotify clients, including token
+// refreshes. Tests replace it to talk to a fake Spotify server.
+var spotifyHTTPClient = http.DefaultClient
+
+// ErrSpotifyReauth is returned when a user's Spotify access was revoked and
+// they need to connect their account again.
+var ErrSpotifyReauth = music.ErrSpotifyReauth
+
+// ErrNoSpotifyAccount is returned when a league has no owner, or its owner
+// never connected a Spotify account.
+var ErrNoSpotifyAccount = errors.New("League owner has no Spotify account")
+
+// ownerSpotifyReauthRequired determines if the league owner needs to
+// reconnect their Spotify account. Owners who never connected one aren't
+// flagged.
+func ownerSpotifyReauthRequired(db mxtpdb.Store, leagueName string) (bool, error) {
+	owner, err := mxtpdb.LeagueOwner(db, leagueName)
+	if err == mxtpdb.ErrNoOwner {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	required, err := db.SpotifyReauthRequired(owner)
+	if err == mxtpdb.ErrNotFound {
+		return false, nil
+	}
+	return required, err
+}
+
+// openMusic returns the music provider acting as the given user, whose
+// requests stop when ctx is done
+func openMusic(ctx context.Context, db mxtpdb.Store, userId string) (music.Provider, error) {
+	provider, err := music.OpenSpotify(ctx, db, userId, spotifyConfig, spotifyHTTPClient)
 	if err != nil {
 		return nil, err
 	}
+	return provider, nil
+}
 
-	client := Auth.NewClient(tok)
+// openLeagueMusic returns the music provider acting as the league's owner,
+// whose requests stop when ctx is done
+func openLeagueMusic(ctx context.Context, db mxtpdb.Store, leagueName string) (music.Provider, error) {
+	owner, err := mxtpdb.LeagueOwner(db, leagueName)
+	if err == mxtpdb.ErrNoOwner {
+		return nil, ErrNoSpotifyAccount
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	return &client, nil
+	provider, err := openMusic(ctx, db, owner)
+	if err == mxtpdb.ErrNotFound {
+		return nil, ErrNoSpotifyAccount
+	}
+	return provider, err
 }
@@ -0,0 +1,119 @@
+// Package mailer sends plain text email, either over SMTP or, when running
+// locally, by writing messages to stdout or a file.
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(message Message) error
+}
+
+// SMTPMailer sends messages through an SMTP server using PLAIN auth.
+type SMTPMailer struct {
+	// Addr is the host:port of the server
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(message Message) error {
+	host, _, err := net.SplitHostPort(m.Addr)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	return smtp.SendMail(m.Addr, auth, m.From, []string{message.To}, format(m.From, message))
+}
+
+// WriterMailer writes messages to W instead of sending them.
+type WriterMailer struct {
+	mu sync.Mutex
+	W  io.Writer
+}
+
+func (m *WriterMailer) Send(message Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := fmt.Fprintf(m.W, "%s\n", format("mxtp@localhost", message))
+	return err
+}
+
+// FileMailer appends messages to the file at Path.
+type FileMailer struct {
+	Path string
+}
+
+func (m *FileMailer) Send(message Message) error {
+	f, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(f, "%s\n", format("mxtp@localhost", message))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// FromEnv creates a Mailer from MXTP_MAILER, which is one of "smtp",
+// "stdout" or "file:<path>". The SMTP mailer is configured with SMTP_ADDR,
+// SMTP_USERNAME, SMTP_PASSWORD and MAIL_FROM.
+func FromEnv() (Mailer, error) {
+	kind := os.Getenv("MXTP_MAILER")
+	switch {
+	case kind == "smtp":
+		m := &SMTPMailer{
+			Addr:     os.Getenv("SMTP_ADDR"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}
+		if m.Addr == "" || m.From == "" {
+			return nil, errors.New("Missing required environment vars for smtp mailer")
+		}
+		return m, nil
+	case kind == "stdout":
+		return &WriterMailer{W: os.Stdout}, nil
+	case strings.HasPrefix(kind, "file:"):
+		return &FileMailer{Path: strings.TrimPrefix(kind, "file:")}, nil
+	default:
+		return nil, fmt.Errorf("Unknown MXTP_MAILER %q", kind)
+	}
+}
+
+// format renders the message as an RFC 5322 email.
+func format(from string, message Message) []byte {
+	headers := []string{
+		"From: " + from,
+		"To: " + message.To,
+		"Subject: " + message.Subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+	}
+	return []byte(strings.Join(headers, "\r\n") + "\r\n\r\n" + message.Body)
+}
@@ -0,0 +1,51 @@
+package mailer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterMailer(t *testing.T) {
+	var buf bytes.Buffer
+	m := &WriterMailer{W: &buf}
+	require.Nil(t, m.Send(Message{To: "alice@devetry.com", Subject: "hi", Body: "hello there"}))
+	require.Contains(t, buf.String(), "To: alice@devetry.com\r\n")
+	require.Contains(t, buf.String(), "Subject: hi\r\n")
+	require.Contains(t, buf.String(), "\r\n\r\nhello there")
+}
+
+func TestFileMailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mailer")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	m := &FileMailer{Path: filepath.Join(dir, "mail.txt")}
+	require.Nil(t, m.Send(Message{To: "alice@devetry.com", Body: "first"}))
+	require.Nil(t, m.Send(Message{To: "bob@devetry.com", Body: "second"}))
+
+	contents, err := ioutil.ReadFile(m.Path)
+	require.Nil(t, err)
+	require.Contains(t, string(contents), "first")
+	require.Contains(t, string(contents), "second")
+}
+
+func TestFromEnv(t *testing.T) {
+	os.Setenv("MXTP_MAILER", "file:/tmp/mail.txt")
+	defer os.Unsetenv("MXTP_MAILER")
+	m, err := FromEnv()
+	require.Nil(t, err)
+	require.Equal(t, &FileMailer{Path: "/tmp/mail.txt"}, m)
+
+	os.Setenv("MXTP_MAILER", "smtp")
+	_, err = FromEnv()
+	require.NotNil(t, err)
+
+	os.Setenv("MXTP_MAILER", "pigeon")
+	_, err = FromEnv()
+	require.NotNil(t, err)
+}
@@ -0,0 +1,52 @@
+// Package mixtape builds a theme's playlist from its submissions.
+package mixtape
+
+import (
+	"fmt"
+
+	"github.com/macintoshpie/mxtp-fx/music"
+	"github.com/macintoshpie/mxtp-fx/mxtpdb"
+)
+
+// BuildPlaylist syncs the theme's playlist with its submitted songs and
+// returns the playlist id. Each theme gets its own playlist, created the first
+// time it's built, so past mixtapes are kept. Existing playlists get the
+// theme's latest description. Songs without a track on the provider are
+// skipped.
+func BuildPlaylist(db mxtpdb.Store, provider music.Provider, leagueName string, theme mxtpdb.Theme) (string, music.SyncReport, error) {
+	var err error
+	playlistId := theme.SpotifyPlaylistId
+	if playlistId == "" {
+		playlistId, err = provider.CreatePlaylist(theme.Name, theme.Description)
+		if err != nil {
+			return "", music.SyncReport{}, err
+		}
+		err = db.SetThemePlaylist(leagueName, theme.Date, playlistId)
+	} else {
+		err = provider.SetPlaylistDescription(playlistId, theme.Description)
+	}
+	if err != nil {
+		return playlistId, music.SyncReport{}, err
+	}
+
+	themeItems, err := db.GetThemeItems(leagueName, theme.Date)
+	if err != nil {
+		return playlistId, music.SyncReport{}, err
+	}
+	var trackIds []string
+	for _, song := range themeItems.Songs {
+		if song.SpotifyTrackId == "" {
+			continue
+		}
+		trackIds = append(trackIds, song.SpotifyTrackId)
+	}
+
+	report, err := music.Sync(provider, playlistId, trackIds)
+	if err != nil {
+		return playlistId, report, err
+	}
+	for _, failure := range report.Failed {
+		fmt.Printf("WARNING: failed to %v track %v: %v\n", failure.Op, failure.TrackId, failure.Error)
+	}
+	return playlistId, report, nil
+}
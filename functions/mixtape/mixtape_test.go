@@ -0,0 +1,47 @@
+package mixtape
+
+import (
+	"testing"
+
+	"github.com/macintoshpie/mxtp-fx/music"
+	"github.com/macintoshpie/mxtp-fx/music/spotifytest"
+	"github.com/macintoshpie/mxtp-fx/mxtpdb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildPlaylist(t *testing.T) {
+	store := mxtpdb.NewMemoryStore()
+	theme := mxtpdb.Theme{Name: "covers", Description: "songs by someone else", Date: "2020-01-01"}
+	require.Nil(t, store.PutTheme("devetry", theme))
+	require.Nil(t, store.UpdateSong("devetry", theme.Date, "alice", "url-a", "sub-a", "track-a", "A", nil))
+	require.Nil(t, store.UpdateSong("devetry", theme.Date, "bob", "url-b", "sub-b", "", "", nil))
+
+	server := spotifytest.NewServer()
+	defer server.Close()
+	provider := music.NewSpotify(server.Client())
+
+	// the first build creates the theme's playlist
+	playlistId, report, err := BuildPlaylist(store, provider, "devetry", theme)
+	require.Nil(t, err)
+	require.Equal(t, []string{"track-a"}, report.Added)
+	playlist, ok := server.Playlist(playlistId)
+	require.True(t, ok)
+	require.Equal(t, "covers", playlist.Name)
+	require.Equal(t, "songs by someone else", playlist.Description)
+	require.Equal(t, []string{"track-a"}, playlist.TrackIds)
+
+	theme, err = store.GetTheme("devetry", theme.Date)
+	require.Nil(t, err)
+	require.Equal(t, playlistId, theme.SpotifyPlaylistId)
+
+	// later builds update it
+	require.Nil(t, store.UpdateSong("devetry", theme.Date, "bob", "url-b", "sub-b", "track-b", "B", nil))
+	theme.Description = "new description"
+	rebuiltId, report, err := BuildPlaylist(store, provider, "devetry", theme)
+	require.Nil(t, err)
+	require.Equal(t, playlistId, rebuiltId)
+	require.Equal(t, []string{"track-b"}, report.Added)
+	playlist, _ = server.Playlist(playlistId)
+	require.Equal(t, "new description", playlist.Description)
+	require.Equal(t, []string{"track-a", "track-b"}, playlist.TrackIds)
+}
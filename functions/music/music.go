@@ -0,0 +1,33 @@
+// Package music abstracts the music service jockey looks up tracks and builds
+// league playlists with.
+package music
+
+// Track is a song on a music service
+type Track struct {
+	Id      string
+	Name    string
+	Artists []string
+}
+
+// Provider is a music service account that can look up tracks and manage its
+// playlists. Ids are the provider's own track and playlist ids.
+type Provider interface {
+	// GetTrack looks up a track by id
+	GetTrack(trackId string) (Track, error)
+	// CreatePlaylist creates a public playlist and returns its id
+	CreatePlaylist(name string, description string) (string, error)
+	// SetPlaylistDescription changes the description of a playlist
+	SetPlaylistDescription(playlistId string, description string) error
+	// GetPlaylistTracks returns the ids of every track in a playlist, in order
+	GetPlaylistTracks(playlistId string) ([]string, error)
+	// ReplacePlaylistTracks replaces every track in a playlist with the given
+	// tracks, in order
+	ReplacePlaylistTracks(playlistId string, trackIds []string) error
+	// AddPlaylistTracks appends at most SyncBatchSize tracks to a playlist
+	AddPlaylistTracks(playlistId string, trackIds []string) error
+	// RemovePlaylistTracks removes every occurrence of at most SyncBatchSize
+	// tracks from a playlist
+	RemovePlaylistTracks(playlistId string, trackIds []string) error
+	// MovePlaylistTrack moves the track at index from so it ends up at index to
+	MovePlaylistTrack(playlistId string, from int, to int) error
+}
@@ -0,0 +1,113 @@
+package music
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultRetryAfter is how long to wait when a rate limited response doesn't
+// say
+const defaultRetryAfter = time.Second
+
+// Netlify stops functions after about 10 seconds, so by default requests give
+// up on waiting well before that
+const (
+	defaultMaxWait    = 3 * time.Second
+	defaultWaitBudget = 5 * time.Second
+)
+
+// RetryTransport retries requests that were rate limited (429 Too Many
+// Requests) after waiting as long as the response's Retry-After header asks.
+// The Spotify client's own AutoRetry retries forever and can't resend request
+// bodies, so use this instead.
+//
+// A request isn't retried, and the rate limited response is returned, when
+// the wait is longer than MaxWait, would take the request's total wait past
+// WaitBudget or would end after the request context's deadline.
+type RetryTransport struct {
+	Base http.RoundTripper
+	// MaxRetries is the most times a request is retried before the rate
+	// limited response is returned
+	MaxRetries int
+	// MaxWait is the longest Retry-After that is waited for
+	MaxWait time.Duration
+	// WaitBudget is the most time spent waiting to retry one request
+	WaitBudget time.Duration
+
+	sleep func(time.Duration)
+}
+
+// NewRetryTransport returns a RetryTransport that sends requests with base
+func NewRetryTransport(base http.RoundTripper) *RetryTransport {
+	return &RetryTransport{Base: base, MaxRetries: 3, MaxWait: defaultMaxWait, WaitBudget: defaultWaitBudget}
+}
+
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var waited time.Duration
+	for retries := 0; ; retries++ {
+		attempt := req
+		if retries > 0 {
+			attempt = req.Clone(req.Context())
+			if req.Body != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				attempt.Body = body
+			}
+		}
+
+		resp, err := t.Base.RoundTrip(attempt)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || retries >= t.MaxRetries {
+			return resp, err
+		}
+		// requests whose body can't be read again can't be retried
+		if req.Body != nil && req.GetBody == nil {
+			return resp, nil
+		}
+
+		wait := retryAfter(resp)
+		if !t.canWait(req, wait, waited) {
+			return resp, nil
+		}
+		waited += wait
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+
+		if t.sleep != nil {
+			t.sleep(wait)
+			continue
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// canWait determines if the request can wait before being retried, given how
+// long it already waited.
+func (t *RetryTransport) canWait(req *http.Request, wait, waited time.Duration) bool {
+	if t.MaxWait > 0 && wait > t.MaxWait {
+		return false
+	}
+	if t.WaitBudget > 0 && waited+wait > t.WaitBudget {
+		return false
+	}
+	deadline, ok := req.Context().Deadline()
+	return !ok || time.Now().Add(wait).Before(deadline)
+}
+
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return defaultRetryAfter
+	}
+	return time.Duration(seconds) * time.Second
+}
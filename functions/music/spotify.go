@@ -0,0 +1,132 @@
+package music
+
+import (
+	"github.com/zmb3/spotify"
+)
+
+// Spotify is a Provider backed by the Spotify Web API
+type Spotify struct {
+	client *spotify.Client
+}
+
+var _ Provider = (*Spotify)(nil)
+
+// NewSpotify returns a Provider that uses the client's Spotify account
+func NewSpotify(client *spotify.Client) *Spotify {
+	return &Spotify{client: client}
+}
+
+func (s *Spotify) GetTrack(trackId string) (Track, error) {
+	track, err := s.client.GetTrack(spotify.ID(trackId))
+	if err != nil {
+		return Track{}, err
+	}
+
+	var artists []string
+	for _, artist := range track.Artists {
+		artists = append(artists, artist.Name)
+	}
+	return Track{
+		Id:      track.ID.String(),
+		Name:    track.Name,
+		Artists: artists,
+	}, nil
+}
+
+// CreatePlaylist creates the playlist for the client's own user
+func (s *Spotify) CreatePlaylist(name string, description string) (string, error) {
+	user, err := s.client.CurrentUser()
+	if err != nil {
+		return "", err
+	}
+	playlist, err := s.client.CreatePlaylistForUser(user.ID, name, description, true)
+	if err != nil {
+		return "", err
+	}
+	return playlist.ID.String(), nil
+}
+
+func (s *Spotify) SetPlaylistDescription(playlistId string, description string) error {
+	return s.client.ChangePlaylistDescription(spotify.ID(playlistId), description)
+}
+
+func (s *Spotify) GetPlaylistTracks(playlistId string) ([]string, error) {
+	page, err := s.client.GetPlaylistTracks(spotify.ID(playlistId))
+	if err != nil {
+		return nil, err
+	}
+
+	var trackIds []string
+	for {
+		for _, playlistTrack := range page.Tracks {
+			trackIds = append(trackIds, playlistTrack.Track.ID.String())
+		}
+
+		err = s.client.NextPage(page)
+		if err == spotify.ErrNoMorePages {
+			return trackIds, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func toSpotifyIds(trackIds []string) []spotify.ID {
+	ids := make([]spotify.ID, len(trackIds))
+	for i, trackId := range trackIds {
+		ids[i] = spotify.ID(trackId)
+	}
+	return ids
+}
+
+// ReplacePlaylistTracks replaces the playlist with the first batch of tracks,
+// then appends the rest a batch at a time.
+func (s *Spotify) ReplacePlaylistTracks(playlistId string, trackIds []string) error {
+	ids := toSpotifyIds(trackIds)
+
+	first := ids
+	if len(first) > SyncBatchSize {
+		first = first[:SyncBatchSize]
+	}
+	err := s.client.ReplacePlaylistTracks(spotify.ID(playlistId), first...)
+	if err != nil {
+		return err
+	}
+
+	for start := len(first); start < len(ids); start += SyncBatchSize {
+		end := start + SyncBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		_, err := s.client.AddTracksToPlaylist(spotify.ID(playlistId), ids[start:end]...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Spotify) AddPlaylistTracks(playlistId string, trackIds []string) error {
+	_, err := s.client.AddTracksToPlaylist(spotify.ID(playlistId), toSpotifyIds(trackIds)...)
+	return err
+}
+
+func (s *Spotify) RemovePlaylistTracks(playlistId string, trackIds []string) error {
+	_, err := s.client.RemoveTracksFromPlaylist(spotify.ID(playlistId), toSpotifyIds(trackIds)...)
+	return err
+}
+
+func (s *Spotify) MovePlaylistTrack(playlistId string, from int, to int) error {
+	// Spotify inserts before the index in the playlist before the track is
+	// moved, so moving a track later needs to insert after its destination
+	insertBefore := to
+	if to > from {
+		insertBefore = to + 1
+	}
+	_, err := s.client.ReorderPlaylistTracks(spotify.ID(playlistId), spotify.PlaylistReorderOptions{
+		RangeStart:   from,
+		InsertBefore: insertBefore,
+	})
+	return err
+}
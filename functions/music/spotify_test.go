@@ -0,0 +1,77 @@
+package music_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/macintoshpie/mxtp-fx/music"
+	"github.com/macintoshpie/mxtp-fx/music/spotifytest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpotifyGetTrack(t *testing.T) {
+	server := spotifytest.NewServer()
+	defer server.Close()
+	server.AddTrack(music.Track{Id: "track1", Name: "Song", Artists: []string{"A", "B"}})
+
+	provider := music.NewSpotify(server.Client())
+	track, err := provider.GetTrack("track1")
+	require.Nil(t, err)
+	require.Equal(t, music.Track{Id: "track1", Name: "Song", Artists: []string{"A", "B"}}, track)
+
+	_, err = provider.GetTrack("missing")
+	require.NotNil(t, err)
+}
+
+func TestSpotifyPlaylists(t *testing.T) {
+	server := spotifytest.NewServer()
+	defer server.Close()
+	server.PageSize = 2
+	server.AddPlaylist("playlist", "old1", "old2", "old3")
+
+	provider := music.NewSpotify(server.Client())
+	require.Nil(t, provider.SetPlaylistDescription("playlist", "theme - description"))
+
+	// tracks are read across pages
+	trackIds, err := provider.GetPlaylistTracks("playlist")
+	require.Nil(t, err)
+	require.Equal(t, []string{"old1", "old2", "old3"}, trackIds)
+
+	require.Nil(t, provider.ReplacePlaylistTracks("playlist", []string{"new1", "new2"}))
+	playlist, ok := server.Playlist("playlist")
+	require.True(t, ok)
+	require.Equal(t, "theme - description", playlist.Description)
+	require.Equal(t, []string{"new1", "new2"}, playlist.TrackIds)
+
+	require.NotNil(t, provider.SetPlaylistDescription("missing", "description"))
+
+	playlistId, err := provider.CreatePlaylist("theme", "description")
+	require.Nil(t, err)
+	created, ok := server.Playlist(playlistId)
+	require.True(t, ok)
+	require.Equal(t, "theme", created.Name)
+	require.Equal(t, "description", created.Description)
+}
+
+func TestSpotifyReplaceManyTracks(t *testing.T) {
+	server := spotifytest.NewServer()
+	defer server.Close()
+	server.AddPlaylist("playlist")
+
+	var trackIds []string
+	for i := 0; i < 250; i++ {
+		trackIds = append(trackIds, fmt.Sprintf("track%v", i))
+	}
+
+	provider := music.NewSpotify(server.Client())
+	require.Nil(t, provider.ReplacePlaylistTracks("playlist", trackIds))
+	playlist, _ := server.Playlist("playlist")
+	require.Equal(t, trackIds, playlist.TrackIds)
+
+	// one replace, then two appends
+	var methods []string
+	for _, call := range server.Calls() {
+		methods = append(methods, call.Method)
+	}
+	require.Equal(t, []string{"PUT", "POST", "POST"}, methods)
+}
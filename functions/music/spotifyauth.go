@@ -0,0 +1,138 @@
+package music
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/zmb3/spotify"
+	"golang.org/x/oauth2"
+)
+
+// ErrSpotifyReauth is returned when a user's Spotify access was revoked and
+// they need to connect their account again.
+var ErrSpotifyReauth = errors.New("Spotify account needs to be reconnected")
+
+// TokenStore keeps users' Spotify tokens. mxtpdb.Store implements it.
+type TokenStore interface {
+	GetSpotifyToken(userId string) (*oauth2.Token, error)
+	UpdateSpotifyToken(token *oauth2.Token, userId string) error
+	FlagSpotifyReauth(userId string) error
+	SpotifyReauthRequired(userId string) (bool, error)
+}
+
+// SpotifyConfig returns the OAuth config for the app's Spotify credentials,
+// read from SPOTIFY_ID and SPOTIFY_SECRET. It matches the config of
+// spotify.NewAuthenticator, which the authenticator doesn't expose.
+func SpotifyConfig(redirectURL string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     os.Getenv("SPOTIFY_ID"),
+		ClientSecret: os.Getenv("SPOTIFY_SECRET"),
+		RedirectURL:  redirectURL,
+		Scopes:       []string{spotify.ScopePlaylistModifyPublic},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  spotify.AuthURL,
+			TokenURL: spotify.TokenURL,
+		},
+	}
+}
+
+// persistingTokenSource refreshes tokens with its base source and saves any
+// new token, so refreshed tokens outlive the lambda invocation. If the refresh
+// token was revoked it flags the user as needing to reconnect.
+type persistingTokenSource struct {
+	tokens  TokenStore
+	userId  string
+	base    oauth2.TokenSource
+	mu      sync.Mutex
+	current *oauth2.Token
+}
+
+func (s *persistingTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, err := s.base.Token()
+	if err != nil {
+		if isRevoked(err) {
+			err = s.tokens.FlagSpotifyReauth(s.userId)
+			if err != nil {
+				fmt.Println("ERROR: failed to flag spotify reauth: ", err.Error())
+			}
+			return nil, ErrSpotifyReauth
+		}
+		return nil, err
+	}
+
+	if s.current == nil || token.AccessToken != s.current.AccessToken {
+		err = s.tokens.UpdateSpotifyToken(token, s.userId)
+		if err != nil {
+			// the token still works for this invocation
+			fmt.Println("WARNING: failed to save refreshed spotify token: ", err.Error())
+		}
+		s.current = token
+	}
+	return token, nil
+}
+
+// isRevoked determines if a token refresh failed because the refresh token is
+// no longer valid
+func isRevoked(err error) bool {
+	retrieveErr, ok := err.(*oauth2.RetrieveError)
+	if !ok {
+		return false
+	}
+	return strings.Contains(string(retrieveErr.Body), "invalid_grant")
+}
+
+// OpenSpotify returns a Provider acting as the user with their saved token.
+// Refreshed tokens are saved and rate limited requests are retried. Requests,
+// including token refreshes, are sent with httpClient's transport and ctx, so
+// they stop when it's done.
+func OpenSpotify(ctx context.Context, tokens TokenStore, userId string, config *oauth2.Config, httpClient *http.Client) (*Spotify, error) {
+	reauth, err := tokens.SpotifyReauthRequired(userId)
+	if err != nil {
+		return nil, err
+	}
+	if reauth {
+		return nil, ErrSpotifyReauth
+	}
+
+	tok, err := tokens.GetSpotifyToken(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	base := httpClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	retrying := &http.Client{Transport: NewRetryTransport(base)}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, retrying)
+	source := &persistingTokenSource{
+		tokens:  tokens,
+		userId:  userId,
+		base:    config.TokenSource(ctx, tok),
+		current: tok,
+	}
+	authed := oauth2.NewClient(ctx, source)
+	// the Spotify client doesn't take a context, so add it to its requests
+	authed.Transport = &contextTransport{ctx: ctx, base: authed.Transport}
+	client := spotify.NewClient(authed)
+
+	return NewSpotify(&client), nil
+}
+
+// contextTransport sends requests with its context
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(t.ctx))
+}
@@ -0,0 +1,469 @@
+// Package spotifytest provides a fake Spotify Web API server for tests. It
+// keeps tracks and playlists in memory and records every call it receives.
+package spotifytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/macintoshpie/mxtp-fx/music"
+	"github.com/zmb3/spotify"
+)
+
+// Call is a request received by the server
+type Call struct {
+	Method string
+	// Path excludes the leading / and the Web API's v1/ prefix, e.g.
+	// playlists/abc/tracks or api/token
+	Path          string
+	Query         url.Values
+	Body          string
+	Authorization string
+}
+
+// UserId is the id of the server's only user, who owns every playlist
+const UserId = "user"
+
+type Playlist struct {
+	Name        string
+	Description string
+	TrackIds    []string
+}
+
+type Server struct {
+	// PageSize is the number of playlist tracks returned per page
+	PageSize int
+
+	server    *httptest.Server
+	mu        sync.Mutex
+	tracks    map[string]music.Track
+	playlists map[string]*Playlist
+	revoked   map[string]bool
+	rejected  map[string]bool
+	limited   int
+	refreshes int
+	calls     []Call
+}
+
+// NewServer starts a fake Spotify server. Call Close when done with it.
+func NewServer() *Server {
+	s := &Server{
+		PageSize:  100,
+		tracks:    map[string]music.Track{},
+		playlists: map[string]*Playlist{},
+		revoked:   map[string]bool{},
+		rejected:  map[string]bool{},
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// URL is the base URL of the server
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+// AddTrack adds a track that can be looked up and added to playlists
+func (s *Server) AddTrack(track music.Track) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tracks[track.Id] = track
+}
+
+// AddPlaylist adds a playlist with the given tracks
+func (s *Server) AddPlaylist(playlistId string, trackIds ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.playlists[playlistId] = &Playlist{TrackIds: append([]string{}, trackIds...)}
+}
+
+// Playlist returns a copy of the playlist, if it exists
+func (s *Server) Playlist(playlistId string) (Playlist, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	playlist, ok := s.playlists[playlistId]
+	if !ok {
+		return Playlist{}, false
+	}
+	return Playlist{
+		Name:        playlist.Name,
+		Description: playlist.Description,
+		TrackIds:    append([]string{}, playlist.TrackIds...),
+	}, true
+}
+
+// RevokeRefreshToken makes refreshing with the token fail like it does for an
+// account that removed the app's access
+func (s *Server) RevokeRefreshToken(refreshToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoked[refreshToken] = true
+}
+
+// RejectTrack makes adding the track to a playlist fail, failing the whole
+// request it's in
+func (s *Server) RejectTrack(trackId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rejected[trackId] = true
+}
+
+// RateLimit makes the next n requests fail with 429 Too Many Requests and a
+// Retry-After of 0 seconds
+func (s *Server) RateLimit(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limited = n
+}
+
+// Calls returns the calls received so far, in order
+func (s *Server) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call{}, s.calls...)
+}
+
+// Transport returns a transport that sends requests for the Spotify Web API
+// and Accounts service to the server instead.
+func (s *Server) Transport() http.RoundTripper {
+	target, _ := url.Parse(s.server.URL)
+	return &rewriteTransport{target: target, base: http.DefaultTransport}
+}
+
+// Client returns a Spotify client that talks to the server
+func (s *Server) Client() *spotify.Client {
+	client := spotify.NewClient(&http.Client{Transport: s.Transport()})
+	return &client
+}
+
+type rewriteTransport struct {
+	target *url.URL
+	base   http.RoundTripper
+}
+
+func (t *rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rewritten := req.Clone(req.Context())
+	rewritten.URL.Scheme = t.target.Scheme
+	rewritten.URL.Host = t.target.Host
+	rewritten.Host = t.target.Host
+	return t.base.RoundTrip(rewritten)
+}
+
+func writeJSON(w http.ResponseWriter, status int, content interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(content)
+}
+
+// writeError writes an error in the Web API's format
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]interface{}{
+			"status":  status,
+			"message": message,
+		},
+	})
+}
+
+func trackURIs(uris []string) []string {
+	var trackIds []string
+	for _, uri := range uris {
+		trackIds = append(trackIds, strings.TrimPrefix(uri, "spotify:track:"))
+	}
+	return trackIds
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	path := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"), "v1/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, Call{
+		Method:        r.Method,
+		Path:          path,
+		Query:         r.URL.Query(),
+		Body:          string(body),
+		Authorization: r.Header.Get("Authorization"),
+	})
+
+	if s.limited > 0 {
+		s.limited--
+		w.Header().Set("Retry-After", "0")
+		writeError(w, http.StatusTooManyRequests, "API rate limit exceeded")
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	switch {
+	case path == "api/token" && r.Method == "POST":
+		s.refreshToken(w, body)
+	case path == "me" && r.Method == "GET":
+		writeJSON(w, http.StatusOK, map[string]string{"id": UserId})
+	case len(parts) == 3 && parts[0] == "users" && parts[2] == "playlists" && r.Method == "POST":
+		s.createPlaylist(w, body)
+	case len(parts) == 2 && parts[0] == "tracks" && r.Method == "GET":
+		s.getTrack(w, parts[1])
+	case len(parts) == 2 && parts[0] == "playlists" && r.Method == "PUT":
+		s.changePlaylist(w, parts[1], body)
+	case len(parts) == 3 && parts[0] == "playlists" && parts[2] == "tracks":
+		s.playlistTracks(w, r, parts[1], body)
+	default:
+		writeError(w, http.StatusNotFound, "Service not found")
+	}
+}
+
+// refreshToken handles refresh token grants, issuing a new access token each
+// time
+func (s *Server) refreshToken(w http.ResponseWriter, body []byte) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil || form.Get("grant_type") != "refresh_token" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+	if s.revoked[form.Get("refresh_token")] {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error":             "invalid_grant",
+			"error_description": "Refresh token revoked",
+		})
+		return
+	}
+
+	s.refreshes++
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": fmt.Sprintf("access-%v", s.refreshes),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+	})
+}
+
+func (s *Server) getTrack(w http.ResponseWriter, trackId string) {
+	track, ok := s.tracks[trackId]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	var artists []spotify.SimpleArtist
+	for _, name := range track.Artists {
+		artists = append(artists, spotify.SimpleArtist{Name: name})
+	}
+	writeJSON(w, http.StatusOK, spotify.FullTrack{
+		SimpleTrack: spotify.SimpleTrack{
+			ID:      spotify.ID(track.Id),
+			Name:    track.Name,
+			Artists: artists,
+			URI:     spotify.URI("spotify:track:" + track.Id),
+		},
+	})
+}
+
+// createPlaylist creates a playlist, with ids playlist-1, playlist-2 etc.
+func (s *Server) createPlaylist(w http.ResponseWriter, body []byte) {
+	var details struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(body, &details); err != nil || details.Name == "" {
+		writeError(w, http.StatusBadRequest, "Missing required field: name")
+		return
+	}
+
+	playlistId := fmt.Sprintf("playlist-%v", len(s.playlists)+1)
+	s.playlists[playlistId] = &Playlist{Name: details.Name, Description: details.Description}
+	writeJSON(w, http.StatusCreated, spotify.FullPlaylist{
+		SimplePlaylist: spotify.SimplePlaylist{
+			ID:   spotify.ID(playlistId),
+			Name: details.Name,
+		},
+		Description: details.Description,
+	})
+}
+
+func (s *Server) changePlaylist(w http.ResponseWriter, playlistId string, body []byte) {
+	playlist, ok := s.playlists[playlistId]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found.")
+		return
+	}
+
+	var details struct {
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(body, &details); err != nil {
+		writeError(w, http.StatusBadRequest, "Error parsing JSON.")
+		return
+	}
+	if details.Description != "" {
+		playlist.Description = details.Description
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) playlistTracks(w http.ResponseWriter, r *http.Request, playlistId string, body []byte) {
+	playlist, ok := s.playlists[playlistId]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found.")
+		return
+	}
+	snapshot := map[string]string{"snapshot_id": strconv.Itoa(len(s.calls))}
+
+	switch r.Method {
+	case "GET":
+		s.getPlaylistTracks(w, r, playlistId, playlist)
+	case "PUT":
+		if len(body) > 0 {
+			s.reorderPlaylistTracks(w, playlist, body)
+			return
+		}
+		var trackIds []string
+		if uris := r.URL.Query().Get("uris"); uris != "" {
+			trackIds = trackURIs(strings.Split(uris, ","))
+		}
+		if len(trackIds) > 100 {
+			writeError(w, http.StatusBadRequest, "Too many ids requested")
+			return
+		}
+		playlist.TrackIds = trackIds
+		writeJSON(w, http.StatusCreated, snapshot)
+	case "POST":
+		var add struct {
+			URIs []string `json:"uris"`
+		}
+		if err := json.Unmarshal(body, &add); err != nil {
+			writeError(w, http.StatusBadRequest, "Error parsing JSON.")
+			return
+		}
+		if len(add.URIs) > 100 {
+			writeError(w, http.StatusBadRequest, "Too many ids requested")
+			return
+		}
+		for _, trackId := range trackURIs(add.URIs) {
+			if s.rejected[trackId] {
+				writeError(w, http.StatusBadRequest, "Invalid track uri: spotify:track:"+trackId)
+				return
+			}
+		}
+		playlist.TrackIds = append(playlist.TrackIds, trackURIs(add.URIs)...)
+		writeJSON(w, http.StatusCreated, snapshot)
+	case "DELETE":
+		var remove struct {
+			Tracks []struct {
+				URI string `json:"uri"`
+			} `json:"tracks"`
+		}
+		if err := json.Unmarshal(body, &remove); err != nil {
+			writeError(w, http.StatusBadRequest, "Error parsing JSON.")
+			return
+		}
+		if len(remove.Tracks) > 100 {
+			writeError(w, http.StatusBadRequest, "Too many ids requested")
+			return
+		}
+		removed := map[string]bool{}
+		for _, track := range remove.Tracks {
+			removed[strings.TrimPrefix(track.URI, "spotify:track:")] = true
+		}
+		var kept []string
+		for _, trackId := range playlist.TrackIds {
+			if !removed[trackId] {
+				kept = append(kept, trackId)
+			}
+		}
+		playlist.TrackIds = kept
+		writeJSON(w, http.StatusOK, snapshot)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// reorderPlaylistTracks moves range_length tracks from range_start to before
+// the track at insert_before, where insert_before is an index in the playlist
+// before the move
+func (s *Server) reorderPlaylistTracks(w http.ResponseWriter, playlist *Playlist, body []byte) {
+	reorder := struct {
+		RangeStart   int `json:"range_start"`
+		RangeLength  int `json:"range_length"`
+		InsertBefore int `json:"insert_before"`
+	}{RangeLength: 1}
+	if err := json.Unmarshal(body, &reorder); err != nil {
+		writeError(w, http.StatusBadRequest, "Error parsing JSON.")
+		return
+	}
+	if reorder.RangeLength < 1 {
+		reorder.RangeLength = 1
+	}
+	start, end, before := reorder.RangeStart, reorder.RangeStart+reorder.RangeLength, reorder.InsertBefore
+	if start < 0 || end > len(playlist.TrackIds) || before < 0 || before > len(playlist.TrackIds) {
+		writeError(w, http.StatusBadRequest, "Index out of bounds")
+		return
+	}
+
+	moving := append([]string{}, playlist.TrackIds[start:end]...)
+	var reordered []string
+	for i, trackId := range playlist.TrackIds {
+		if i == before {
+			reordered = append(reordered, moving...)
+		}
+		if i < start || i >= end {
+			reordered = append(reordered, trackId)
+		}
+	}
+	if before == len(playlist.TrackIds) {
+		reordered = append(reordered, moving...)
+	}
+	playlist.TrackIds = reordered
+	writeJSON(w, http.StatusOK, map[string]string{"snapshot_id": "reordered"})
+}
+
+func (s *Server) getPlaylistTracks(w http.ResponseWriter, r *http.Request, playlistId string, playlist *Playlist) {
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 || limit > s.PageSize {
+		limit = s.PageSize
+	}
+	if offset < 0 || offset > len(playlist.TrackIds) {
+		offset = len(playlist.TrackIds)
+	}
+	end := offset + limit
+	if end > len(playlist.TrackIds) {
+		end = len(playlist.TrackIds)
+	}
+
+	items := []spotify.PlaylistTrack{}
+	for _, trackId := range playlist.TrackIds[offset:end] {
+		track := s.tracks[trackId]
+		items = append(items, spotify.PlaylistTrack{
+			Track: spotify.FullTrack{
+				SimpleTrack: spotify.SimpleTrack{
+					ID:   spotify.ID(trackId),
+					Name: track.Name,
+					URI:  spotify.URI("spotify:track:" + trackId),
+				},
+			},
+		})
+	}
+
+	content := map[string]interface{}{
+		"items":  items,
+		"limit":  limit,
+		"offset": offset,
+		"total":  len(playlist.TrackIds),
+		"next":   nil,
+	}
+	if end < len(playlist.TrackIds) {
+		content["next"] = fmt.Sprintf("https://api.spotify.com/v1/playlists/%v/tracks?offset=%v&limit=%v", playlistId, end, limit)
+	}
+	writeJSON(w, http.StatusOK, content)
+}
@@ -0,0 +1,167 @@
+package music
+
+// SyncBatchSize is the most tracks added or removed in one call, which is
+// Spotify's limit
+const SyncBatchSize = 100
+
+// Sync operations, as reported in a SyncFailure
+const (
+	SyncAdd    = "add"
+	SyncRemove = "remove"
+	SyncMove   = "move"
+)
+
+type SyncFailure struct {
+	Op      string
+	TrackId string
+	Error   string
+}
+
+// SyncReport describes the changes Sync made to a playlist
+type SyncReport struct {
+	Added   []string
+	Removed []string
+	Moved   []string
+	Failed  []SyncFailure
+}
+
+// Sync makes a playlist contain exactly the desired tracks, in order, with as
+// few calls as it can. Tracks that aren't desired or are duplicated in the
+// playlist are removed, missing tracks are appended and then tracks are moved
+// into place, so syncing an up to date playlist changes nothing. Failures are
+// reported rather than stopping the sync. An error is only returned if the
+// playlist can't be read.
+func Sync(provider Provider, playlistId string, desired []string) (SyncReport, error) {
+	report := SyncReport{}
+
+	current, err := provider.GetPlaylistTracks(playlistId)
+	if err != nil {
+		return report, err
+	}
+
+	// a track can only be in the playlist once
+	var target []string
+	wanted := map[string]bool{}
+	for _, trackId := range desired {
+		if !wanted[trackId] {
+			wanted[trackId] = true
+			target = append(target, trackId)
+		}
+	}
+
+	// remove unwanted and duplicated tracks. Removing a track removes every
+	// occurrence, so duplicates are added back afterwards.
+	counts := map[string]int{}
+	for _, trackId := range current {
+		counts[trackId]++
+	}
+	var remove []string
+	for _, trackId := range current {
+		if (!wanted[trackId] || counts[trackId] > 1) && counts[trackId] > 0 {
+			remove = append(remove, trackId)
+			counts[trackId] = 0
+		}
+	}
+	removed := map[string]bool{}
+	for _, batch := range batches(remove) {
+		err := provider.RemovePlaylistTracks(playlistId, batch)
+		for _, trackId := range batch {
+			if err != nil {
+				report.Failed = append(report.Failed, SyncFailure{Op: SyncRemove, TrackId: trackId, Error: err.Error()})
+				continue
+			}
+			removed[trackId] = true
+			report.Removed = append(report.Removed, trackId)
+		}
+	}
+
+	// playlist is our model of the playlist's tracks as changes are made
+	var playlist []string
+	present := map[string]bool{}
+	for _, trackId := range current {
+		if !removed[trackId] {
+			playlist = append(playlist, trackId)
+			present[trackId] = true
+		}
+	}
+
+	// append missing tracks. If a batch fails its tracks are added one at a
+	// time so a single bad track doesn't hold up the rest.
+	var add []string
+	for _, trackId := range target {
+		if !present[trackId] {
+			add = append(add, trackId)
+		}
+	}
+	for _, batch := range batches(add) {
+		if err := provider.AddPlaylistTracks(playlistId, batch); err == nil {
+			playlist = append(playlist, batch...)
+			report.Added = append(report.Added, batch...)
+			continue
+		}
+
+		for _, trackId := range batch {
+			err := provider.AddPlaylistTracks(playlistId, []string{trackId})
+			if err != nil {
+				report.Failed = append(report.Failed, SyncFailure{Op: SyncAdd, TrackId: trackId, Error: err.Error()})
+				continue
+			}
+			playlist = append(playlist, trackId)
+			report.Added = append(report.Added, trackId)
+		}
+	}
+
+	// move tracks into the desired order, leaving anything that couldn't be
+	// removed at the end
+	position := 0
+	for _, trackId := range target {
+		from := indexOf(playlist, trackId, position)
+		if from < 0 {
+			// failed to add it
+			continue
+		}
+		if from != position {
+			err := provider.MovePlaylistTrack(playlistId, from, position)
+			if err != nil {
+				report.Failed = append(report.Failed, SyncFailure{Op: SyncMove, TrackId: trackId, Error: err.Error()})
+				// the rest of the playlist is still in order relative to this track
+				continue
+			}
+			playlist = move(playlist, from, position)
+			report.Moved = append(report.Moved, trackId)
+		}
+		position++
+	}
+
+	return report, nil
+}
+
+func batches(trackIds []string) [][]string {
+	var result [][]string
+	for start := 0; start < len(trackIds); start += SyncBatchSize {
+		end := start + SyncBatchSize
+		if end > len(trackIds) {
+			end = len(trackIds)
+		}
+		result = append(result, trackIds[start:end])
+	}
+	return result
+}
+
+func indexOf(trackIds []string, trackId string, start int) int {
+	for i := start; i < len(trackIds); i++ {
+		if trackIds[i] == trackId {
+			return i
+		}
+	}
+	return -1
+}
+
+// move returns the tracks with the track at index from moved to index to
+func move(trackIds []string, from int, to int) []string {
+	trackId := trackIds[from]
+	moved := append([]string{}, trackIds[:from]...)
+	moved = append(moved, trackIds[from+1:]...)
+	moved = append(moved[:to], append([]string{trackId}, moved[to:]...)...)
+	return moved
+}
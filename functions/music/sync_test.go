@@ -0,0 +1,184 @@
+package music_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/macintoshpie/mxtp-fx/music"
+	"github.com/macintoshpie/mxtp-fx/music/spotifytest"
+	"github.com/stretchr/testify/require"
+	"github.com/zmb3/spotify"
+)
+
+// changes counts the calls that changed a playlist
+func changes(server *spotifytest.Server) int {
+	count := 0
+	for _, call := range server.Calls() {
+		if call.Method != "GET" {
+			count++
+		}
+	}
+	return count
+}
+
+func TestSync(t *testing.T) {
+	server := spotifytest.NewServer()
+	defer server.Close()
+	server.PageSize = 2
+	server.AddPlaylist("playlist", "old", "c", "dup", "a", "dup")
+	provider := music.NewSpotify(server.Client())
+
+	report, err := music.Sync(provider, "playlist", []string{"a", "b", "c", "dup", "a"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"old", "dup"}, report.Removed)
+	require.Equal(t, []string{"b", "dup"}, report.Added)
+	require.Equal(t, []string{"a", "b"}, report.Moved)
+	require.Empty(t, report.Failed)
+
+	playlist, _ := server.Playlist("playlist")
+	require.Equal(t, []string{"a", "b", "c", "dup"}, playlist.TrackIds)
+
+	// syncing again changes nothing
+	before := changes(server)
+	report, err = music.Sync(provider, "playlist", []string{"a", "b", "c", "dup"})
+	require.Nil(t, err)
+	require.Equal(t, music.SyncReport{}, report)
+	require.Equal(t, before, changes(server))
+
+	// reversing the playlist only moves tracks
+	report, err = music.Sync(provider, "playlist", []string{"dup", "c", "b", "a"})
+	require.Nil(t, err)
+	require.Empty(t, report.Added)
+	require.Empty(t, report.Removed)
+	playlist, _ = server.Playlist("playlist")
+	require.Equal(t, []string{"dup", "c", "b", "a"}, playlist.TrackIds)
+
+	_, err = music.Sync(provider, "missing", []string{"a"})
+	require.NotNil(t, err)
+}
+
+func TestSyncBatches(t *testing.T) {
+	server := spotifytest.NewServer()
+	defer server.Close()
+
+	var current, desired []string
+	for i := 0; i < 150; i++ {
+		current = append(current, fmt.Sprintf("old%v", i))
+	}
+	for i := 0; i < 250; i++ {
+		desired = append(desired, fmt.Sprintf("new%v", i))
+	}
+	server.AddPlaylist("playlist", current...)
+
+	report, err := music.Sync(music.NewSpotify(server.Client()), "playlist", desired)
+	require.Nil(t, err)
+	require.Len(t, report.Removed, 150)
+	require.Len(t, report.Added, 250)
+	require.Empty(t, report.Moved)
+	require.Empty(t, report.Failed)
+
+	playlist, _ := server.Playlist("playlist")
+	require.Equal(t, desired, playlist.TrackIds)
+
+	// two removes and three adds
+	var methods []string
+	for _, call := range server.Calls() {
+		if call.Method != "GET" {
+			methods = append(methods, call.Method)
+		}
+	}
+	require.Equal(t, []string{"DELETE", "DELETE", "POST", "POST", "POST"}, methods)
+}
+
+func TestSyncFailures(t *testing.T) {
+	server := spotifytest.NewServer()
+	defer server.Close()
+	server.AddPlaylist("playlist", "c")
+	server.RejectTrack("bad")
+
+	report, err := music.Sync(music.NewSpotify(server.Client()), "playlist", []string{"a", "bad", "b", "c"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"a", "b"}, report.Added)
+	require.Len(t, report.Failed, 1)
+	require.Equal(t, music.SyncAdd, report.Failed[0].Op)
+	require.Equal(t, "bad", report.Failed[0].TrackId)
+	require.NotEmpty(t, report.Failed[0].Error)
+
+	// the rest of the tracks are still in order
+	playlist, _ := server.Playlist("playlist")
+	require.Equal(t, []string{"a", "b", "c"}, playlist.TrackIds)
+}
+
+func TestRetryTransport(t *testing.T) {
+	server := spotifytest.NewServer()
+	defer server.Close()
+	server.AddPlaylist("playlist")
+
+	client := spotify.NewClient(&http.Client{Transport: music.NewRetryTransport(server.Transport())})
+	provider := music.NewSpotify(&client)
+
+	// the request body is sent again
+	server.RateLimit(2)
+	require.Nil(t, provider.AddPlaylistTracks("playlist", []string{"a"}))
+	playlist, _ := server.Playlist("playlist")
+	require.Equal(t, []string{"a"}, playlist.TrackIds)
+	calls := server.Calls()
+	require.Len(t, calls, 3)
+	require.Equal(t, calls[0].Body, calls[2].Body)
+
+	// requests are only retried so many times
+	server.RateLimit(4)
+	require.NotNil(t, provider.AddPlaylistTracks("playlist", []string{"b"}))
+}
+
+// rateLimited answers every request with 429 and the Retry-After header
+type rateLimited struct {
+	retryAfter string
+	calls      int
+}
+
+func (r *rateLimited) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.calls++
+	return &http.Response{
+		StatusCode: http.StatusTooManyRequests,
+		Header:     http.Header{"Retry-After": []string{r.retryAfter}},
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
+
+func TestRetryTransportGivesUpOnLongWaits(t *testing.T) {
+	// a wait longer than MaxWait
+	base := &rateLimited{retryAfter: "60"}
+	transport := music.NewRetryTransport(base)
+	req, err := http.NewRequest("GET", "https://api.spotify.com/v1/me", nil)
+	require.Nil(t, err)
+	resp, err := transport.RoundTrip(req)
+	require.Nil(t, err)
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, 1, base.calls)
+
+	// a wait past the budget
+	base = &rateLimited{retryAfter: "1"}
+	transport = music.NewRetryTransport(base)
+	transport.WaitBudget = 500 * time.Millisecond
+	resp, err = transport.RoundTrip(req)
+	require.Nil(t, err)
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, 1, base.calls)
+
+	// a wait past the request's deadline
+	base = &rateLimited{retryAfter: "1"}
+	transport = music.NewRetryTransport(base)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	resp, err = transport.RoundTrip(req.WithContext(ctx))
+	require.Nil(t, err)
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, 1, base.calls)
+}
@@ -0,0 +1,324 @@
+// Package musiclink parses links to songs on music services into a provider,
+// resource type and ID.
+package musiclink
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+type Provider string
+
+const (
+	Spotify    Provider = "spotify"
+	YouTube    Provider = "youtube"
+	AppleMusic Provider = "applemusic"
+	SoundCloud Provider = "soundcloud"
+	Bandcamp   Provider = "bandcamp"
+)
+
+var providerNames = map[Provider]string{
+	Spotify:    "Spotify",
+	YouTube:    "YouTube",
+	AppleMusic: "Apple Music",
+	SoundCloud: "SoundCloud",
+	Bandcamp:   "Bandcamp",
+}
+
+// Name returns the provider's display name
+func (p Provider) Name() string {
+	if name, ok := providerNames[p]; ok {
+		return name
+	}
+	return string(p)
+}
+
+type Resource string
+
+const (
+	Track    Resource = "track"
+	Album    Resource = "album"
+	Playlist Resource = "playlist"
+	Artist   Resource = "artist"
+	Other    Resource = "other"
+)
+
+// Link is a parsed music link. Id is only unique within the provider, and URL
+// is the canonical link to the resource.
+type Link struct {
+	Provider Provider
+	Resource Resource
+	Id       string
+	URL      string
+}
+
+var ErrUnsupported = errors.New("Unsupported music link, use a Spotify, YouTube, Apple Music, SoundCloud or Bandcamp link")
+
+// ResourceError is returned by RequireTrack for links to something other
+// than a single track.
+type ResourceError struct {
+	Link Link
+}
+
+func (e *ResourceError) Error() string {
+	return fmt.Sprintf("%v %v links aren't supported, please link a single track", e.Link.Provider.Name(), e.Link.Resource)
+}
+
+// RequireTrack returns a *ResourceError if the link isn't to a track
+func (l Link) RequireTrack() error {
+	if l.Resource != Track {
+		return &ResourceError{Link: l}
+	}
+	return nil
+}
+
+// ParseTrack parses a link and requires that it's to a track
+func ParseTrack(raw string) (Link, error) {
+	link, err := Parse(raw)
+	if err != nil {
+		return link, err
+	}
+	return link, link.RequireTrack()
+}
+
+// Parse parses a link to a music resource. It returns ErrUnsupported if the
+// link isn't to a recognized provider or is malformed.
+func Parse(raw string) (Link, error) {
+	raw = strings.TrimSpace(raw)
+	if strings.HasPrefix(raw, "spotify:") {
+		return parseSpotifyURI(raw)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return Link{}, ErrUnsupported
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	path := splitPath(u.Path)
+	switch {
+	case host == "open.spotify.com" || host == "play.spotify.com":
+		return parseSpotify(path)
+	case host == "youtube.com" || host == "m.youtube.com" || host == "music.youtube.com" || host == "youtu.be":
+		return parseYouTube(host, path, u.Query())
+	case host == "music.apple.com" || host == "geo.music.apple.com" || host == "itunes.apple.com":
+		return parseAppleMusic(path, u.Query())
+	case host == "soundcloud.com" || host == "m.soundcloud.com":
+		return parseSoundCloud(path)
+	case strings.HasSuffix(host, ".bandcamp.com"):
+		return parseBandcamp(strings.TrimSuffix(host, ".bandcamp.com"), path)
+	}
+	return Link{}, ErrUnsupported
+}
+
+func splitPath(path string) []string {
+	var parts []string
+	for _, part := range strings.Split(path, "/") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+var spotifyId = regexp.MustCompile(`^[0-9A-Za-z]{22}$`)
+
+var spotifyResources = map[string]Resource{
+	"track":    Track,
+	"album":    Album,
+	"playlist": Playlist,
+	"artist":   Artist,
+}
+
+func spotifyLink(kind string, id string) (Link, error) {
+	resource, ok := spotifyResources[kind]
+	if !ok {
+		resource = Other
+	}
+	if !spotifyId.MatchString(id) {
+		return Link{}, ErrUnsupported
+	}
+	return Link{
+		Provider: Spotify,
+		Resource: resource,
+		Id:       id,
+		URL:      fmt.Sprintf("https://open.spotify.com/%v/%v", kind, id),
+	}, nil
+}
+
+// parseSpotifyURI parses URIs like spotify:track:<id>
+func parseSpotifyURI(raw string) (Link, error) {
+	parts := strings.Split(raw, ":")
+	if len(parts) < 3 {
+		return Link{}, ErrUnsupported
+	}
+	// legacy playlist URIs include the user, e.g. spotify:user:<name>:playlist:<id>
+	return spotifyLink(parts[len(parts)-2], parts[len(parts)-1])
+}
+
+// parseSpotify parses paths like /track/<id>, /intl-de/track/<id> and
+// /embed/track/<id>
+func parseSpotify(path []string) (Link, error) {
+	if len(path) > 0 && (strings.HasPrefix(path[0], "intl-") || path[0] == "embed") {
+		path = path[1:]
+	}
+	if len(path) == 4 && path[0] == "user" {
+		// legacy playlist links, e.g. /user/<name>/playlist/<id>
+		path = path[2:]
+	}
+	if len(path) != 2 {
+		return Link{}, ErrUnsupported
+	}
+	return spotifyLink(path[0], path[1])
+}
+
+var youTubeId = regexp.MustCompile(`^[0-9A-Za-z_-]{11}$`)
+
+func parseYouTube(host string, path []string, query url.Values) (Link, error) {
+	var id string
+	switch {
+	case host == "youtu.be" && len(path) == 1:
+		id = path[0]
+	case len(path) == 1 && path[0] == "watch":
+		id = query.Get("v")
+	case len(path) == 2 && (path[0] == "shorts" || path[0] == "embed" || path[0] == "v"):
+		id = path[1]
+	case len(path) == 1 && path[0] == "playlist" && query.Get("list") != "":
+		return Link{
+			Provider: YouTube,
+			Resource: Playlist,
+			Id:       query.Get("list"),
+			URL:      "https://www.youtube.com/playlist?list=" + url.QueryEscape(query.Get("list")),
+		}, nil
+	case len(path) > 0 && (path[0] == "channel" || path[0] == "c" || path[0] == "user" || strings.HasPrefix(path[0], "@")):
+		return Link{
+			Provider: YouTube,
+			Resource: Artist,
+			Id:       strings.Join(path, "/"),
+			URL:      "https://www.youtube.com/" + strings.Join(path, "/"),
+		}, nil
+	}
+
+	if !youTubeId.MatchString(id) {
+		return Link{}, ErrUnsupported
+	}
+	return Link{
+		Provider: YouTube,
+		Resource: Track,
+		Id:       id,
+		URL:      "https://www.youtube.com/watch?v=" + id,
+	}, nil
+}
+
+var appleMusicId = regexp.MustCompile(`^(id|pl\.)?[0-9A-Za-z.-]+$`)
+
+// parseAppleMusic parses paths like /<storefront>/album/<name>/<id>?i=<track>
+// and /<storefront>/song/<name>/<id>. The name is optional.
+func parseAppleMusic(path []string, query url.Values) (Link, error) {
+	if len(path) < 3 {
+		return Link{}, ErrUnsupported
+	}
+	storefront, kind, id := path[0], path[1], path[len(path)-1]
+	if !appleMusicId.MatchString(id) || len(path) > 4 {
+		return Link{}, ErrUnsupported
+	}
+	id = strings.TrimPrefix(id, "id")
+
+	link := Link{Provider: AppleMusic, Id: id}
+	switch kind {
+	case "song":
+		link.Resource = Track
+	case "album":
+		// tracks are linked as a position within their album
+		if track := query.Get("i"); track != "" {
+			link.Resource = Track
+			link.Id = track
+		} else {
+			link.Resource = Album
+		}
+	case "playlist":
+		link.Resource = Playlist
+	case "artist":
+		link.Resource = Artist
+	default:
+		link.Resource = Other
+	}
+
+	if link.Resource == Track {
+		link.URL = fmt.Sprintf("https://music.apple.com/%v/song/%v", storefront, link.Id)
+	} else {
+		link.URL = fmt.Sprintf("https://music.apple.com/%v/%v/%v", storefront, kind, id)
+	}
+	return link, nil
+}
+
+// soundCloudReserved are top level SoundCloud paths that aren't users
+var soundCloudReserved = map[string]bool{
+	"discover": true,
+	"search":   true,
+	"stream":   true,
+	"upload":   true,
+	"you":      true,
+	"charts":   true,
+}
+
+// parseSoundCloud parses paths like /<user>/<track> and /<user>/sets/<set>
+func parseSoundCloud(path []string) (Link, error) {
+	if len(path) == 0 || soundCloudReserved[path[0]] {
+		return Link{}, ErrUnsupported
+	}
+
+	link := Link{
+		Provider: SoundCloud,
+		Id:       strings.Join(path, "/"),
+		URL:      "https://soundcloud.com/" + strings.Join(path, "/"),
+	}
+	switch {
+	case len(path) == 1:
+		link.Resource = Artist
+	case len(path) == 3 && path[1] == "sets":
+		link.Resource = Playlist
+	case len(path) == 2 && !isSoundCloudUserPage(path[1]):
+		link.Resource = Track
+	default:
+		link.Resource = Other
+	}
+	return link, nil
+}
+
+func isSoundCloudUserPage(page string) bool {
+	switch page {
+	case "sets", "tracks", "albums", "popular-tracks", "reposts", "likes", "followers", "following":
+		return true
+	}
+	return false
+}
+
+// parseBandcamp parses paths like /track/<slug> and /album/<slug> on an
+// artist's subdomain
+func parseBandcamp(artist string, path []string) (Link, error) {
+	if artist == "" || strings.Contains(artist, ".") {
+		return Link{}, ErrUnsupported
+	}
+
+	link := Link{Provider: Bandcamp}
+	switch {
+	case len(path) == 0:
+		link.Resource = Artist
+		link.Id = artist
+		link.URL = fmt.Sprintf("https://%v.bandcamp.com/", artist)
+		return link, nil
+	case len(path) == 2 && path[0] == "track":
+		link.Resource = Track
+	case len(path) == 2 && path[0] == "album":
+		link.Resource = Album
+	default:
+		return Link{}, ErrUnsupported
+	}
+	link.Id = artist + "/" + path[1]
+	link.URL = fmt.Sprintf("https://%v.bandcamp.com/%v/%v", artist, path[0], path[1])
+	return link, nil
+}
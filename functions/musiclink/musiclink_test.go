@@ -0,0 +1,84 @@
+package musiclink
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	spotifyTrack := Link{Spotify, Track, "4uLU6hMCjMI75M1A2tKUQC", "https://open.spotify.com/track/4uLU6hMCjMI75M1A2tKUQC"}
+	youTubeTrack := Link{YouTube, Track, "dQw4w9WgXcQ", "https://www.youtube.com/watch?v=dQw4w9WgXcQ"}
+
+	tests := []struct {
+		raw  string
+		link Link
+	}{
+		{"https://open.spotify.com/track/4uLU6hMCjMI75M1A2tKUQC", spotifyTrack},
+		{"https://open.spotify.com/track/4uLU6hMCjMI75M1A2tKUQC?si=abc123", spotifyTrack},
+		{"https://open.spotify.com/intl-de/track/4uLU6hMCjMI75M1A2tKUQC", spotifyTrack},
+		{"https://open.spotify.com/embed/track/4uLU6hMCjMI75M1A2tKUQC", spotifyTrack},
+		{"  spotify:track:4uLU6hMCjMI75M1A2tKUQC ", spotifyTrack},
+		{"https://open.spotify.com/album/1DFixLWuPkv3KT3TnV35m3", Link{Spotify, Album, "1DFixLWuPkv3KT3TnV35m3", "https://open.spotify.com/album/1DFixLWuPkv3KT3TnV35m3"}},
+		{"spotify:user:someone:playlist:37i9dQZF1DXcBWIGoYBM5M", Link{Spotify, Playlist, "37i9dQZF1DXcBWIGoYBM5M", "https://open.spotify.com/playlist/37i9dQZF1DXcBWIGoYBM5M"}},
+
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=42", youTubeTrack},
+		{"https://m.youtube.com/watch?v=dQw4w9WgXcQ", youTubeTrack},
+		{"https://music.youtube.com/watch?v=dQw4w9WgXcQ&feature=share", youTubeTrack},
+		{"https://youtu.be/dQw4w9WgXcQ", youTubeTrack},
+		{"https://www.youtube.com/shorts/dQw4w9WgXcQ", youTubeTrack},
+		{"https://www.youtube.com/playlist?list=PL123", Link{YouTube, Playlist, "PL123", "https://www.youtube.com/playlist?list=PL123"}},
+
+		{"https://music.apple.com/us/album/never-gonna-give-you-up/1558533900?i=1558534271", Link{AppleMusic, Track, "1558534271", "https://music.apple.com/us/song/1558534271"}},
+		{"https://music.apple.com/gb/song/never-gonna-give-you-up/1558534271", Link{AppleMusic, Track, "1558534271", "https://music.apple.com/gb/song/1558534271"}},
+		{"https://music.apple.com/us/album/whenever-you-need-somebody/1558533900", Link{AppleMusic, Album, "1558533900", "https://music.apple.com/us/album/1558533900"}},
+
+		{"https://soundcloud.com/rick-astley-official/never-gonna-give-you-up-4", Link{SoundCloud, Track, "rick-astley-official/never-gonna-give-you-up-4", "https://soundcloud.com/rick-astley-official/never-gonna-give-you-up-4"}},
+		{"https://soundcloud.com/rick-astley-official/sets/hits", Link{SoundCloud, Playlist, "rick-astley-official/sets/hits", "https://soundcloud.com/rick-astley-official/sets/hits"}},
+		{"https://soundcloud.com/rick-astley-official", Link{SoundCloud, Artist, "rick-astley-official", "https://soundcloud.com/rick-astley-official"}},
+
+		{"https://artist.bandcamp.com/track/a-song?from=embed", Link{Bandcamp, Track, "artist/a-song", "https://artist.bandcamp.com/track/a-song"}},
+		{"https://artist.bandcamp.com/album/a-record", Link{Bandcamp, Album, "artist/a-record", "https://artist.bandcamp.com/album/a-record"}},
+	}
+
+	for _, test := range tests {
+		link, err := Parse(test.raw)
+		require.Nil(t, err, test.raw)
+		require.Equal(t, test.link, link, test.raw)
+	}
+}
+
+func TestParseUnsupported(t *testing.T) {
+	for _, raw := range []string{
+		"",
+		"not a url",
+		"ftp://open.spotify.com/track/4uLU6hMCjMI75M1A2tKUQC",
+		"https://example.com/song",
+		"https://open.spotify.com/track/short",
+		"https://open.spotify.com/",
+		"spotify:track",
+		"https://www.youtube.com/watch",
+		"https://youtu.be/",
+		"https://soundcloud.com/discover",
+		"https://bandcamp.com/track/a-song",
+		"https://artist.bandcamp.com/merch",
+	} {
+		_, err := Parse(raw)
+		require.Equal(t, ErrUnsupported, err, raw)
+	}
+}
+
+func TestParseTrack(t *testing.T) {
+	link, err := ParseTrack("https://youtu.be/dQw4w9WgXcQ")
+	require.Nil(t, err)
+	require.Equal(t, "dQw4w9WgXcQ", link.Id)
+
+	_, err = ParseTrack("https://open.spotify.com/album/1DFixLWuPkv3KT3TnV35m3")
+	resourceErr, ok := err.(*ResourceError)
+	require.True(t, ok)
+	require.Equal(t, Album, resourceErr.Link.Resource)
+	require.Equal(t, "Spotify album links aren't supported, please link a single track", err.Error())
+
+	_, err = ParseTrack("https://example.com/song")
+	require.Equal(t, ErrUnsupported, err)
+}
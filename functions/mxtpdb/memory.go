@@ -0,0 +1,550 @@
+package mxtpdb
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// MemoryStore is a Store that keeps items in memory. Items are stored with the
+// same partition and sort keys used in DynamoDB so queries return them in the
+// same order.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	items map[string]map[string]MxtpItem
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		items: make(map[string]map[string]MxtpItem),
+	}
+}
+
+// WithContext returns the store itself, as it never waits on anything
+func (m *MemoryStore) WithContext(ctx context.Context) Store {
+	return m
+}
+
+// Put stores an item, replacing any existing item with the same keys. It is
+// mostly useful for seeding data that has no write method (e.g. leagues).
+func (m *MemoryStore) Put(item MxtpItem) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	partition, ok := m.items[item.PK]
+	if !ok {
+		partition = make(map[string]MxtpItem)
+		m.items[item.PK] = partition
+	}
+	partition[item.SK] = copyItem(item)
+}
+
+// query returns copies of all items in the partition sorted by SK.
+func (m *MemoryStore) query(pk string) []MxtpItem {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []MxtpItem
+	for _, item := range m.items[pk] {
+		items = append(items, copyItem(item))
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].SK < items[j].SK
+	})
+
+	return items
+}
+
+// deleteOne removes the item, returning its old value.
+func (m *MemoryStore) deleteOne(pk, sk string) (MxtpItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	item, ok := m.items[pk][sk]
+	if !ok {
+		return MxtpItem{}, ErrNotFound
+	}
+	delete(m.items[pk], sk)
+	return item, nil
+}
+
+func (m *MemoryStore) getOne(pk, sk string) (MxtpItem, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	item, ok := m.items[pk][sk]
+	if !ok {
+		return MxtpItem{}, ErrNotFound
+	}
+	return copyItem(item), nil
+}
+
+func copyItem(item MxtpItem) MxtpItem {
+	if item.SubmissionIds != nil {
+		item.SubmissionIds = append([]string{}, item.SubmissionIds...)
+	}
+	if item.Artists != nil {
+		item.Artists = append([]string{}, item.Artists...)
+	}
+	if item.Events != nil {
+		item.Events = append([]string{}, item.Events...)
+	}
+	return item
+}
+
+func (m *MemoryStore) GetLeague(leagueName string) (League, error) {
+	pk, err := makeLeaguePK(leagueName)
+	if err != nil {
+		return League{}, err
+	}
+
+	meta, err := m.getOne(pk, leagueMetaSK)
+	if err != nil {
+		return League{}, err
+	}
+
+	var themes []MxtpItem
+	for _, item := range m.query(pk) {
+		if strings.HasPrefix(item.SK, "theme#") {
+			themes = append(themes, item)
+		}
+	}
+
+	return leagueFromItems(meta, themes, time.Now())
+}
+
+func (m *MemoryStore) PutLeague(league League) error {
+	pk, err := makeLeaguePK(league.Name)
+	if err != nil {
+		return err
+	}
+	if league.Name == "" {
+		return errors.New("League must have a name")
+	}
+
+	m.Put(leagueToItem(pk, league))
+	return nil
+}
+
+func (m *MemoryStore) GetLeagueNames() ([]string, error) {
+	m.mu.RLock()
+	var items []MxtpItem
+	for _, partition := range m.items {
+		if item, ok := partition[leagueMetaSK]; ok {
+			items = append(items, copyItem(item))
+		}
+	}
+	m.mu.RUnlock()
+
+	return leagueNamesFromItems(items)
+}
+
+func (m *MemoryStore) GetThemes(leagueName string) ([]Theme, error) {
+	pk, err := makeLeaguePK(leagueName)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []MxtpItem
+	for _, item := range m.query(pk) {
+		if strings.HasPrefix(item.SK, "theme#") {
+			items = append(items, item)
+		}
+	}
+
+	return themesFromItems(items)
+}
+
+func (m *MemoryStore) GetTheme(leagueName, themeId string) (Theme, error) {
+	pk, err := makeLeaguePK(leagueName)
+	if err != nil {
+		return Theme{}, err
+	}
+	sk, err := makeThemeSK(themeId)
+	if err != nil {
+		return Theme{}, err
+	}
+
+	item, err := m.getOne(pk, sk)
+	if err != nil {
+		return Theme{}, err
+	}
+
+	return item.toTheme()
+}
+
+func (m *MemoryStore) PutTheme(leagueName string, theme Theme) error {
+	item, err := themeToItem(leagueName, theme)
+	if err != nil {
+		return err
+	}
+
+	m.Put(item)
+	return nil
+}
+
+func (m *MemoryStore) SetThemePlaylist(leagueName, themeId, playlistId string) error {
+	pk, err := makeLeaguePK(leagueName)
+	if err != nil {
+		return err
+	}
+	sk, err := makeThemeSK(themeId)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item, ok := m.items[pk][sk]
+	if !ok {
+		return ErrNotFound
+	}
+	item.SpotifyPlaylistId = playlistId
+	m.items[pk][sk] = item
+	return nil
+}
+
+func (m *MemoryStore) MarkTransition(leagueName, themeId string, transition Transition, at time.Time) error {
+	pk, err := makeLeaguePK(leagueName)
+	if err != nil {
+		return err
+	}
+	sk, err := makeThemeSK(themeId)
+	if err != nil {
+		return err
+	}
+	if _, err := (&MxtpItem{}).transitionField(transition); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item, ok := m.items[pk][sk]
+	if !ok {
+		return ErrNotFound
+	}
+	marked, _ := item.transitionField(transition)
+	if !marked.IsZero() {
+		return ErrTransitionDone
+	}
+	*marked = at
+	m.items[pk][sk] = item
+	return nil
+}
+
+func (m *MemoryStore) DeleteTheme(leagueName, themeId string) error {
+	pk, err := makeLeaguePK(leagueName)
+	if err != nil {
+		return err
+	}
+	sk, err := makeThemeSK(themeId)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.deleteOne(pk, sk)
+	return err
+}
+
+func (m *MemoryStore) GetThemeItems(leagueName, themeId string) (ThemeItems, error) {
+	pk, err := makeThemeItemsPK(leagueName, themeId)
+	if err != nil {
+		return ThemeItems{}, err
+	}
+
+	return themeItemsFromItems(themeId, m.query(pk))
+}
+
+func (m *MemoryStore) GetSong(leagueName, themeId, userId string) (Song, error) {
+	pk, sk, err := makeSongKeys(leagueName, themeId, userId)
+	if err != nil {
+		return Song{}, err
+	}
+
+	item, err := m.getOne(pk, sk)
+	if err != nil {
+		return Song{}, err
+	}
+
+	return item.toSong()
+}
+
+func (m *MemoryStore) UpdateSong(leagueName, themeId, userId, songUrl, submissionId, spotifyTrackId, songName string, songArtists []string) error {
+	pk, sk, err := makeSongKeys(leagueName, themeId, userId)
+	if err != nil {
+		return err
+	}
+
+	m.Put(MxtpItem{
+		PK:             pk,
+		SK:             sk,
+		UserId:         userId,
+		SongUrl:        songUrl,
+		SubmissionId:   submissionId,
+		SpotifyTrackId: spotifyTrackId,
+		Name:           songName,
+		Artists:        songArtists,
+	})
+	return nil
+}
+
+func (m *MemoryStore) UpdateVotes(leagueName, themeId, userId string, submissionIds []string) error {
+	pk, sk, err := makeVotesKeys(leagueName, themeId, userId)
+	if err != nil {
+		return err
+	}
+
+	m.Put(MxtpItem{
+		PK:            pk,
+		SK:            sk,
+		UserId:        userId,
+		SubmissionIds: submissionIds,
+	})
+	return nil
+}
+
+func (m *MemoryStore) GetSpotifyToken(userId string) (*oauth2.Token, error) {
+	pk, sk, err := makeSpotifyTokenKeys(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := m.getOne(pk, sk)
+	if err != nil {
+		return nil, err
+	}
+
+	return item.toOAuthToken()
+}
+
+func (m *MemoryStore) UpdateSpotifyToken(token *oauth2.Token, userId string) error {
+	pk, sk, err := makeSpotifyTokenKeys(userId)
+	if err != nil {
+		return err
+	}
+
+	m.Put(MxtpItem{
+		PK:           pk,
+		SK:           sk,
+		AccessToken:  token.AccessToken,
+		TokenType:    token.TokenType,
+		RefreshToken: token.RefreshToken,
+		Expiry:       token.Expiry,
+	})
+	return nil
+}
+
+func (m *MemoryStore) FlagSpotifyReauth(userId string) error {
+	pk, sk, err := makeSpotifyTokenKeys(userId)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item, ok := m.items[pk][sk]
+	if !ok {
+		return ErrNotFound
+	}
+	item.ReauthRequired = true
+	m.items[pk][sk] = item
+	return nil
+}
+
+func (m *MemoryStore) SpotifyReauthRequired(userId string) (bool, error) {
+	pk, sk, err := makeSpotifyTokenKeys(userId)
+	if err != nil {
+		return false, err
+	}
+
+	item, err := m.getOne(pk, sk)
+	if err != nil {
+		return false, err
+	}
+	return item.ReauthRequired, nil
+}
+
+func (m *MemoryStore) GetUserFromState(state string) (string, error) {
+	pk, sk, err := makeUserStateKeys(state)
+	if err != nil {
+		return "", err
+	}
+
+	item, err := m.getOne(pk, sk)
+	if err != nil {
+		return "", err
+	}
+
+	return item.UserId, nil
+}
+
+func (m *MemoryStore) UpdateUserState(userId, state string) error {
+	pk, sk, err := makeUserStateKeys(state)
+	if err != nil {
+		return err
+	}
+
+	m.Put(MxtpItem{
+		PK:     pk,
+		SK:     sk,
+		UserId: userId,
+	})
+	return nil
+}
+
+func (m *MemoryStore) PutLoginNonce(userId, nonce string, expiry time.Time) error {
+	pk, sk, err := makeLoginNonceKeys(nonce)
+	if err != nil {
+		return err
+	}
+
+	m.Put(MxtpItem{
+		PK:     pk,
+		SK:     sk,
+		UserId: userId,
+		Expiry: expiry,
+	})
+	return nil
+}
+
+func (m *MemoryStore) ConsumeLoginNonce(nonce string) (string, error) {
+	pk, sk, err := makeLoginNonceKeys(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	item, err := m.deleteOne(pk, sk)
+	if err != nil {
+		return "", err
+	}
+
+	return item.toLoginUser(time.Now())
+}
+
+func (m *MemoryStore) PutMember(leagueName, userId, role string) error {
+	pk, sk, err := makeMemberKeys(leagueName, userId)
+	if err != nil {
+		return err
+	}
+	err = validateRole(role)
+	if err != nil {
+		return err
+	}
+
+	m.Put(MxtpItem{
+		PK:     pk,
+		SK:     sk,
+		UserId: userId,
+		Role:   role,
+	})
+	return nil
+}
+
+func (m *MemoryStore) RemoveMember(leagueName, userId string) error {
+	pk, sk, err := makeMemberKeys(leagueName, userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.deleteOne(pk, sk)
+	return err
+}
+
+func (m *MemoryStore) GetMember(leagueName, userId string) (Member, error) {
+	pk, sk, err := makeMemberKeys(leagueName, userId)
+	if err != nil {
+		return Member{}, err
+	}
+
+	item, err := m.getOne(pk, sk)
+	if err != nil {
+		return Member{}, err
+	}
+
+	return item.toMember()
+}
+
+func (m *MemoryStore) GetMembers(leagueName string) ([]Member, error) {
+	pk, err := makeLeaguePK(leagueName)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []MxtpItem
+	for _, item := range m.query(pk) {
+		if strings.HasPrefix(item.SK, "member#") {
+			items = append(items, item)
+		}
+	}
+
+	return membersFromItems(items)
+}
+
+func (m *MemoryStore) PutHook(leagueName string, hook Hook) error {
+	item, err := hookToItem(leagueName, hook)
+	if err != nil {
+		return err
+	}
+
+	m.Put(item)
+	return nil
+}
+
+func (m *MemoryStore) GetHooks(leagueName string) ([]Hook, error) {
+	pk, err := makeLeaguePK(leagueName)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []MxtpItem
+	for _, item := range m.query(pk) {
+		if strings.HasPrefix(item.SK, "hook#") {
+			items = append(items, item)
+		}
+	}
+
+	return hooksFromItems(items)
+}
+
+func (m *MemoryStore) DeleteHook(leagueName, hookId string) error {
+	pk, sk, err := makeHookKeys(leagueName, hookId)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.deleteOne(pk, sk)
+	return err
+}
+
+func (m *MemoryStore) PutSlackUser(leagueName, slackUserId, userId string) error {
+	pk, sk, err := makeSlackUserKeys(leagueName, slackUserId)
+	if err != nil {
+		return err
+	}
+	err = validateIds(userId)
+	if err != nil {
+		return err
+	}
+
+	m.Put(MxtpItem{PK: pk, SK: sk, UserId: userId})
+	return nil
+}
+
+func (m *MemoryStore) GetSlackUser(leagueName, slackUserId string) (string, error) {
+	pk, sk, err := makeSlackUserKeys(leagueName, slackUserId)
+	if err != nil {
+		return "", err
+	}
+
+	item, err := m.getOne(pk, sk)
+	if err != nil {
+		return "", err
+	}
+
+	return item.toSlackUser()
+}
@@ -0,0 +1,282 @@
+package mxtpdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+// seedLeague adds a league with a closed, a voting and a submitting theme
+// using the default cadence.
+func seedLeague(m *MemoryStore) {
+	m.Put(MxtpItem{PK: "league#devetry", SK: "~meta", Name: "devetry"})
+	for i, name := range []string{"first", "second", "third"} {
+		date := time.Now().UTC().AddDate(0, 0, (i-2)*DefaultSubmitDays).Format(ThemeDateFormat)
+		m.Put(MxtpItem{PK: "league#devetry", SK: "theme#" + date, Name: name, Date: date})
+	}
+}
+
+func TestMemoryStoreGetLeague(t *testing.T) {
+	m := NewMemoryStore()
+	seedLeague(m)
+
+	league, err := m.GetLeague("devetry")
+	require.Nil(t, err)
+	require.Equal(t, "devetry", league.Name)
+	require.Equal(t, "third", league.SubmitTheme.Name)
+	require.Equal(t, "second", league.VoteTheme.Name)
+}
+
+func TestMemoryStoreGetLeagueMissing(t *testing.T) {
+	m := NewMemoryStore()
+	_, err := m.GetLeague("devetry")
+	require.NotNil(t, err)
+}
+
+func TestMemoryStoreThemeItemsOrdering(t *testing.T) {
+	m := NewMemoryStore()
+	require.Nil(t, m.UpdateVotes("devetry", "2020-05-29", "bob", []string{"sub-a"}))
+	require.Nil(t, m.UpdateSong("devetry", "2020-05-29", "bob", "url-b", "sub-b", "", "", nil))
+	require.Nil(t, m.UpdateSong("devetry", "2020-05-29", "alice", "url-a", "sub-a", "track", "name", []string{"artist"}))
+
+	items, err := m.GetThemeItems("devetry", "2020-05-29")
+	require.Nil(t, err)
+	require.Equal(t, "2020-05-29", items.Id)
+	require.Len(t, items.Songs, 2)
+	require.Equal(t, "alice", items.Songs[0].UserId)
+	require.Equal(t, "bob", items.Songs[1].UserId)
+	require.Equal(t, []Votes{{UserId: "bob", SubmissionIds: []string{"sub-a"}}}, items.Votes)
+
+	song, err := m.GetSong("devetry", "2020-05-29", "alice")
+	require.Nil(t, err)
+	require.Equal(t, []string{"artist"}, song.Artists)
+}
+
+func TestMemoryStoreValidatesIds(t *testing.T) {
+	m := NewMemoryStore()
+	require.NotNil(t, m.UpdateSong("devetry", "2020-05-29", "bad#user", "url", "sub", "", "", nil))
+	require.NotNil(t, m.UpdateVotes("bad#league", "2020-05-29", "bob", nil))
+	require.NotNil(t, m.UpdateUserState("bob", "bad#state"))
+	_, err := m.GetThemeItems("devetry", "bad#theme")
+	require.NotNil(t, err)
+}
+
+func TestMemoryStoreNotFound(t *testing.T) {
+	m := NewMemoryStore()
+	_, err := m.GetSong("devetry", "2020-05-29", "alice")
+	require.Equal(t, ErrNotFound, err)
+	_, err = m.GetSpotifyToken("alice")
+	require.Equal(t, ErrNotFound, err)
+	_, err = m.GetUserFromState("abc")
+	require.Equal(t, ErrNotFound, err)
+}
+
+func TestMemoryStoreTokensAndState(t *testing.T) {
+	m := NewMemoryStore()
+	require.Nil(t, m.UpdateSpotifyToken(&oauth2.Token{AccessToken: "access", RefreshToken: "refresh"}, "alice"))
+	token, err := m.GetSpotifyToken("alice")
+	require.Nil(t, err)
+	require.Equal(t, "access", token.AccessToken)
+	require.Equal(t, "refresh", token.RefreshToken)
+
+	required, err := m.SpotifyReauthRequired("alice")
+	require.Nil(t, err)
+	require.False(t, required)
+	require.Nil(t, m.FlagSpotifyReauth("alice"))
+	required, err = m.SpotifyReauthRequired("alice")
+	require.Nil(t, err)
+	require.True(t, required)
+	require.Equal(t, ErrNotFound, m.FlagSpotifyReauth("bob"))
+
+	// connecting again clears the flag
+	require.Nil(t, m.UpdateSpotifyToken(&oauth2.Token{AccessToken: "new", RefreshToken: "refresh"}, "alice"))
+	required, err = m.SpotifyReauthRequired("alice")
+	require.Nil(t, err)
+	require.False(t, required)
+
+	require.Nil(t, m.UpdateUserState("alice", "abc"))
+	user, err := m.GetUserFromState("abc")
+	require.Nil(t, err)
+	require.Equal(t, "alice", user)
+}
+
+func TestMemoryStoreLoginNonce(t *testing.T) {
+	m := NewMemoryStore()
+	require.Nil(t, m.PutLoginNonce("alice", "abc", time.Now().Add(time.Minute)))
+	require.Nil(t, m.PutLoginNonce("bob", "old", time.Now().Add(-time.Minute)))
+
+	user, err := m.ConsumeLoginNonce("abc")
+	require.Nil(t, err)
+	require.Equal(t, "alice", user)
+
+	// nonces are single use
+	_, err = m.ConsumeLoginNonce("abc")
+	require.Equal(t, ErrNotFound, err)
+
+	_, err = m.ConsumeLoginNonce("old")
+	require.Equal(t, ErrNonceExpired, err)
+
+	require.NotNil(t, m.PutLoginNonce("alice", "bad#nonce", time.Now().Add(time.Minute)))
+}
+
+func TestMemoryStoreThemes(t *testing.T) {
+	m := NewMemoryStore()
+	require.Nil(t, m.PutLeague(League{Name: "devetry", Description: "desc"}))
+	future := time.Now().UTC().Add(48 * time.Hour).Format(ThemeDateFormat)
+	require.Nil(t, m.PutTheme("devetry", Theme{Name: "future", Date: future}))
+	require.Nil(t, m.PutTheme("devetry", Theme{Name: "past", Date: "2020-05-01"}))
+	require.NotNil(t, m.PutTheme("devetry", Theme{Name: "bad", Date: "May 1"}))
+	require.NotNil(t, m.PutTheme("devetry", Theme{Date: "2020-05-02"}))
+
+	themes, err := m.GetThemes("devetry")
+	require.Nil(t, err)
+	require.Equal(t, []Theme{{Name: "past", Date: "2020-05-01"}, {Name: "future", Date: future}}, themes)
+
+	// neither theme is open yet
+	league, err := m.GetLeague("devetry")
+	require.Nil(t, err)
+	require.Equal(t, "desc", league.Description)
+	require.Equal(t, Theme{}, league.SubmitTheme)
+	require.Equal(t, Theme{}, league.VoteTheme)
+
+	theme, err := m.GetTheme("devetry", future)
+	require.Nil(t, err)
+	require.Equal(t, "future", theme.Name)
+
+	require.Nil(t, m.SetThemePlaylist("devetry", "2020-05-01", "playlist"))
+	theme, err = m.GetTheme("devetry", "2020-05-01")
+	require.Nil(t, err)
+	require.Equal(t, Theme{Name: "past", Date: "2020-05-01", SpotifyPlaylistId: "playlist"}, theme)
+	require.Equal(t, ErrNotFound, m.SetThemePlaylist("devetry", "2020-05-03", "playlist"))
+
+	require.Nil(t, m.DeleteTheme("devetry", future))
+	require.Equal(t, ErrNotFound, m.DeleteTheme("devetry", future))
+	_, err = m.GetTheme("devetry", future)
+	require.Equal(t, ErrNotFound, err)
+}
+
+func TestMemoryStoreTransitions(t *testing.T) {
+	m := NewMemoryStore()
+	require.Nil(t, m.PutLeague(League{Name: "devetry"}))
+	require.Nil(t, m.PutLeague(League{Name: "another"}))
+	require.Nil(t, m.PutTheme("devetry", Theme{Name: "theme", Date: "2020-05-01"}))
+
+	names, err := m.GetLeagueNames()
+	require.Nil(t, err)
+	require.Equal(t, []string{"another", "devetry"}, names)
+
+	at := time.Date(2020, 5, 15, 0, 0, 0, 0, time.UTC)
+	require.Nil(t, m.MarkTransition("devetry", "2020-05-01", TransitionSubmitClosed, at))
+	require.Equal(t, ErrTransitionDone, m.MarkTransition("devetry", "2020-05-01", TransitionSubmitClosed, at.Add(time.Hour)))
+	require.Equal(t, ErrNotFound, m.MarkTransition("devetry", "2020-05-02", TransitionSubmitClosed, at))
+	require.NotNil(t, m.MarkTransition("devetry", "2020-05-01", Transition("Bogus"), at))
+
+	theme, err := m.GetTheme("devetry", "2020-05-01")
+	require.Nil(t, err)
+	require.Equal(t, at, theme.TransitionedAt(TransitionSubmitClosed))
+	require.True(t, theme.TransitionedAt(TransitionSubmitOpened).IsZero())
+}
+
+func TestMemoryStoreHooks(t *testing.T) {
+	m := NewMemoryStore()
+	require.Nil(t, m.PutHook("devetry", Hook{Id: "slack", Kind: "slack", Url: "https://hooks.slack.com/x"}))
+	require.Nil(t, m.PutHook("devetry", Hook{Id: "bot", Kind: "webhook", Url: "https://bot.example.com", Secret: "shh", Events: []string{"vote.opened"}}))
+	require.NotNil(t, m.PutHook("devetry", Hook{Kind: "slack"}))
+	require.NotNil(t, m.PutHook("devetry", Hook{Id: "a#b", Kind: "slack"}))
+
+	hooks, err := m.GetHooks("devetry")
+	require.Nil(t, err)
+	require.Equal(t, []Hook{
+		{Id: "bot", Kind: "webhook", Url: "https://bot.example.com", Secret: "shh", Events: []string{"vote.opened"}},
+		{Id: "slack", Kind: "slack", Url: "https://hooks.slack.com/x"},
+	}, hooks)
+
+	require.Nil(t, m.DeleteHook("devetry", "bot"))
+	require.Equal(t, ErrNotFound, m.DeleteHook("devetry", "bot"))
+	hooks, err = m.GetHooks("devetry")
+	require.Nil(t, err)
+	require.Len(t, hooks, 1)
+}
+
+func TestMemoryStoreSlackUsers(t *testing.T) {
+	m := NewMemoryStore()
+	require.Nil(t, m.PutSlackUser("devetry", "U123", "alice"))
+	require.NotNil(t, m.PutSlackUser("devetry", "", "alice"))
+	require.NotNil(t, m.PutSlackUser("devetry", "U#1", "alice"))
+
+	userId, err := m.GetSlackUser("devetry", "U123")
+	require.Nil(t, err)
+	require.Equal(t, "alice", userId)
+
+	// relinking replaces the user
+	require.Nil(t, m.PutSlackUser("devetry", "U123", "bob"))
+	userId, err = m.GetSlackUser("devetry", "U123")
+	require.Nil(t, err)
+	require.Equal(t, "bob", userId)
+
+	_, err = m.GetSlackUser("devetry", "U456")
+	require.Equal(t, ErrNotFound, err)
+	_, err = m.GetSlackUser("other", "U123")
+	require.Equal(t, ErrNotFound, err)
+}
+
+func TestMemoryStoreMembers(t *testing.T) {
+	m := NewMemoryStore()
+	require.Nil(t, m.PutMember("devetry", "ted", RoleOwner))
+	require.Nil(t, m.PutMember("devetry", "alice", RoleMember))
+	require.Nil(t, m.PutMember("devetry", "alice", RoleAdmin))
+	require.NotNil(t, m.PutMember("devetry", "bob", "dictator"))
+	require.NotNil(t, m.PutMember("devetry", "bad#user", RoleMember))
+
+	members, err := m.GetMembers("devetry")
+	require.Nil(t, err)
+	require.Equal(t, []Member{{UserId: "alice", Role: RoleAdmin}, {UserId: "ted", Role: RoleOwner}}, members)
+
+	member, err := m.GetMember("devetry", "ted")
+	require.Nil(t, err)
+	require.Equal(t, RoleOwner, member.Role)
+
+	require.Nil(t, m.RemoveMember("devetry", "alice"))
+	require.Equal(t, ErrNotFound, m.RemoveMember("devetry", "alice"))
+	_, err = m.GetMember("devetry", "alice")
+	require.Equal(t, ErrNotFound, err)
+
+	// members don't get mistaken for themes
+	require.Nil(t, m.PutLeague(League{Name: "devetry"}))
+	league, err := m.GetLeague("devetry")
+	require.Nil(t, err)
+	require.Equal(t, Theme{}, league.SubmitTheme)
+}
+
+func TestBackfillMembers(t *testing.T) {
+	m := NewMemoryStore()
+	seedLeague(m)
+	league, err := m.GetLeague("devetry")
+	require.Nil(t, err)
+	require.Nil(t, m.UpdateSong("devetry", league.SubmitTheme.Date, "alice", "url-a", "sub-a", "", "", nil))
+	require.Nil(t, m.UpdateSong("devetry", league.VoteTheme.Date, "bob", "url-b", "sub-b", "", "", nil))
+	require.Nil(t, m.UpdateVotes("devetry", league.VoteTheme.Date, "carl", []string{"sub-b"}))
+
+	// past submitters and voters become members, with the given owner
+	require.Nil(t, BackfillMembers(m, "devetry", "ted"))
+	members, err := m.GetMembers("devetry")
+	require.Nil(t, err)
+	require.Equal(t, []Member{
+		{UserId: "alice", Role: RoleMember},
+		{UserId: "bob", Role: RoleMember},
+		{UserId: "carl", Role: RoleMember},
+		{UserId: "ted", Role: RoleOwner},
+	}, members)
+
+	// existing members keep their roles
+	require.Nil(t, m.PutMember("devetry", "alice", RoleAdmin))
+	require.Nil(t, BackfillMembers(m, "devetry", "bob"))
+	member, err := m.GetMember("devetry", "bob")
+	require.Nil(t, err)
+	require.Equal(t, RoleMember, member.Role)
+	member, err = m.GetMember("devetry", "alice")
+	require.Nil(t, err)
+	require.Equal(t, RoleAdmin, member.Role)
+}
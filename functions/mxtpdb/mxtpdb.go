@@ -1,22 +1,34 @@
 package mxtpdb
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/guregu/dynamo"
 	"golang.org/x/oauth2"
 )
 
+// ErrNotFound is returned by a Store when a requested item does not exist.
+var ErrNotFound = dynamo.ErrNotFound
+
+// ErrNonceExpired is returned when consuming a login nonce after its expiry.
+var ErrNonceExpired = errors.New("Login nonce expired")
+
 type DB struct {
 	db    *dynamo.DB
 	table dynamo.Table
+	// ctx is used for every call to DynamoDB
+	ctx context.Context
 }
 
 type MxtpItem struct {
@@ -34,26 +46,91 @@ type MxtpItem struct {
 	Artists           []string `dynamo:",omitempty"`
 	Role              string   `dynamo:",omitempty"`
 
+	MinVotes       int  `dynamo:",omitempty"`
+	MaxVotes       int  `dynamo:",omitempty"`
+	AllowSelfVotes bool `dynamo:",omitempty"`
+
+	SubmitDays  int       `dynamo:",omitempty"`
+	VoteDays    int       `dynamo:",omitempty"`
+	SubmitOpen  time.Time `dynamo:",omitempty"`
+	SubmitClose time.Time `dynamo:",omitempty"`
+	VoteOpen    time.Time `dynamo:",omitempty"`
+	VoteClose   time.Time `dynamo:",omitempty"`
+	// theme transitions, named by their Transition
+	SubmitOpenedAt   time.Time `dynamo:",omitempty"`
+	SubmitRemindedAt time.Time `dynamo:",omitempty"`
+	SubmitClosedAt   time.Time `dynamo:",omitempty"`
+	VoteOpenedAt     time.Time `dynamo:",omitempty"`
+	VoteClosedAt     time.Time `dynamo:",omitempty"`
+
 	AccessToken  string    `dynamo:",omitempty"`
 	TokenType    string    `dynamo:",omitempty"`
 	RefreshToken string    `dynamo:",omitempty"`
 	Expiry       time.Time `dynamo:",omitempty"`
+	// ReauthRequired is set on a token that was revoked
+	ReauthRequired bool `dynamo:",omitempty"`
 
 	State string `dynamo:",omitempty"`
+
+	Kind   string   `dynamo:",omitempty"`
+	Url    string   `dynamo:",omitempty"`
+	Secret string   `dynamo:",omitempty"`
+	Events []string `dynamo:",omitempty,set"`
 }
 
 type League struct {
-	Name              string `dynamo:",omitempty"`
-	Description       string `dynamo:",omitempty"`
-	SubmitTheme       Theme  `dynamo:",omitempty"`
-	VoteTheme         Theme  `dynamo:",omitempty"`
-	SpotifyPlaylistId string `dynamo:",omitempty"`
+	Name        string `dynamo:",omitempty"`
+	Description string `dynamo:",omitempty"`
+	SubmitTheme Theme  `dynamo:",omitempty"`
+	VoteTheme   Theme  `dynamo:",omitempty"`
+	// SubmitDays and VoteDays are the default length of each theme phase
+	SubmitDays int `dynamo:",omitempty"`
+	VoteDays   int `dynamo:",omitempty"`
+	// MinVotes and MaxVotes bound how many songs each user votes for, where 0
+	// is unbounded. Users can't vote for their own song unless AllowSelfVotes.
+	MinVotes       int  `dynamo:",omitempty"`
+	MaxVotes       int  `dynamo:",omitempty"`
+	AllowSelfVotes bool `dynamo:",omitempty"`
 }
 
 type Theme struct {
-	Name        string `dynamo:",omitempty"`
-	Description string `dynamo:",omitempty"`
-	Date        string `dynamo:",omitempty"`
+	Name        string    `dynamo:",omitempty"`
+	Description string    `dynamo:",omitempty"`
+	Date        string    `dynamo:",omitempty"`
+	SubmitOpen  time.Time `dynamo:",omitempty"`
+	SubmitClose time.Time `dynamo:",omitempty"`
+	VoteOpen    time.Time `dynamo:",omitempty"`
+	VoteClose   time.Time `dynamo:",omitempty"`
+	// SpotifyPlaylistId is the theme's own playlist, kept after the theme ends
+	SpotifyPlaylistId string `dynamo:",omitempty"`
+	// SubmitOpenedAt etc. are when each Transition was marked
+	SubmitOpenedAt   time.Time `dynamo:",omitempty"`
+	SubmitRemindedAt time.Time `dynamo:",omitempty"`
+	SubmitClosedAt   time.Time `dynamo:",omitempty"`
+	VoteOpenedAt     time.Time `dynamo:",omitempty"`
+	VoteClosedAt     time.Time `dynamo:",omitempty"`
+}
+
+const (
+	RoleMember = "member"
+	RoleAdmin  = "admin"
+	RoleOwner  = "owner"
+)
+
+type Member struct {
+	UserId string
+	Role   string
+}
+
+// Hook is where a league sends notifications of its events
+type Hook struct {
+	Id   string
+	Kind string
+	Url  string
+	// Secret signs generic webhooks
+	Secret string
+	// Events are the event types sent to the hook, or every event if empty
+	Events []string
 }
 
 type ThemeItems struct {
@@ -104,7 +181,7 @@ func (item *MxtpItem) toLeague() (League, error) {
 		}, errors.New(fmt.Sprintf("Failed to validate League: %v", err.Error()))
 	}
 
-	if item.SK != "~meta" {
+	if item.SK != leagueMetaSK {
 		return League{
 			SubmitTheme: Theme{},
 			VoteTheme:   Theme{},
@@ -112,11 +189,15 @@ func (item *MxtpItem) toLeague() (League, error) {
 	}
 
 	return League{
-		Name:              item.Name,
-		Description:       item.Description,
-		SpotifyPlaylistId: item.SpotifyPlaylistId,
-		SubmitTheme:       Theme{},
-		VoteTheme:         Theme{},
+		Name:           item.Name,
+		Description:    item.Description,
+		SubmitDays:     item.SubmitDays,
+		VoteDays:       item.VoteDays,
+		MinVotes:       item.MinVotes,
+		MaxVotes:       item.MaxVotes,
+		AllowSelfVotes: item.AllowSelfVotes,
+		SubmitTheme:    Theme{},
+		VoteTheme:      Theme{},
 	}, nil
 }
 
@@ -133,9 +214,19 @@ func (item *MxtpItem) toTheme() (Theme, error) {
 	}
 
 	return Theme{
-		Name:        item.Name,
-		Description: item.Description,
-		Date:        item.Date,
+		Name:              item.Name,
+		Description:       item.Description,
+		Date:              item.Date,
+		SubmitOpen:        item.SubmitOpen,
+		SubmitClose:       item.SubmitClose,
+		VoteOpen:          item.VoteOpen,
+		VoteClose:         item.VoteClose,
+		SpotifyPlaylistId: item.SpotifyPlaylistId,
+		SubmitOpenedAt:    item.SubmitOpenedAt,
+		SubmitRemindedAt:  item.SubmitRemindedAt,
+		SubmitClosedAt:    item.SubmitClosedAt,
+		VoteOpenedAt:      item.VoteOpenedAt,
+		VoteClosedAt:      item.VoteClosedAt,
 	}, nil
 }
 
@@ -177,6 +268,23 @@ func (item *MxtpItem) toVotes() (Votes, error) {
 	}, nil
 }
 
+func (item *MxtpItem) toMember() (Member, error) {
+	err := validateCompoundKey(item.PK, "league")
+	if err != nil {
+		return Member{}, errors.New(fmt.Sprintf("Failed to validate Member: %v", err.Error()))
+	}
+
+	err = validateCompoundKey(item.SK, "member")
+	if err != nil {
+		return Member{}, errors.New(fmt.Sprintf("Failed to validate Member: %v", err.Error()))
+	}
+
+	return Member{
+		UserId: item.UserId,
+		Role:   item.Role,
+	}, nil
+}
+
 func (item *MxtpItem) toOAuthToken() (*oauth2.Token, error) {
 	err := validateCompoundKey(item.PK, "secret")
 	if err != nil {
@@ -191,6 +299,19 @@ func (item *MxtpItem) toOAuthToken() (*oauth2.Token, error) {
 	}, nil
 }
 
+func (item *MxtpItem) toLoginUser(now time.Time) (string, error) {
+	err := validateCompoundKey(item.PK, "login")
+	if err != nil {
+		return "", err
+	}
+
+	if !now.Before(item.Expiry) {
+		return "", ErrNonceExpired
+	}
+
+	return item.UserId, nil
+}
+
 func New() (*DB, error) {
 	accessKeyId := os.Getenv("PERSONAL_AWS_ACCESS_KEY_ID")
 	secretAccessKey := os.Getenv("PERSONAL_AWS_SECRET_ACCESS_KEY")
@@ -209,11 +330,20 @@ func New() (*DB, error) {
 
 	db := dynamo.New(sess, &aws.Config{Region: aws.String("us-west-2")})
 	return &DB{
-		db,
-		db.Table("mxtp"),
+		db:    db,
+		table: db.Table("mxtp"),
+		ctx:   context.Background(),
 	}, nil
 }
 
+// WithContext returns a DB whose calls to DynamoDB are made with ctx, so they
+// stop when it's done.
+func (db *DB) WithContext(ctx context.Context) Store {
+	withContext := *db
+	withContext.ctx = ctx
+	return &withContext
+}
+
 func makeLeaguePK(leagueName string) (string, error) {
 	err := validateIds(leagueName)
 	if err != nil {
@@ -223,18 +353,30 @@ func makeLeaguePK(leagueName string) (string, error) {
 	return fmt.Sprintf("league#%v", leagueName), nil
 }
 
+// ThemeDateFormat is the layout of theme dates, which also serve as theme ids.
+const ThemeDateFormat = "2006-01-02"
+
+const leagueMetaSK = "~meta"
+
+func makeThemeSK(themeId string) (string, error) {
+	err := validateIds(themeId)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("theme#%v", themeId), nil
+}
+
 func (db *DB) GetLeague(leagueName string) (League, error) {
 	pk, err := makeLeaguePK(leagueName)
 	if err != nil {
 		return League{}, err
 	}
 
-	var items []MxtpItem
+	var meta MxtpItem
 	err = db.table.Get("PK", pk).
-		Order(dynamo.Descending).
-		Limit(3).
-		All(&items)
-
+		Range("SK", dynamo.Equal, leagueMetaSK).
+		OneWithContext(db.ctx, &meta)
 	if err != nil {
 		return League{
 			SubmitTheme: Theme{},
@@ -242,18 +384,25 @@ func (db *DB) GetLeague(leagueName string) (League, error) {
 		}, err
 	}
 
-	// Expect the items to be the following:
-	// [0] - League info
-	// [1] - Submitting theme (if exists)
-	// [2] - Voting theme (if exists)
-	if len(items) == 0 {
+	var themes []MxtpItem
+	err = db.table.Get("PK", pk).
+		Range("SK", dynamo.BeginsWith, "theme#").
+		AllWithContext(db.ctx, &themes)
+	if err != nil {
 		return League{
 			SubmitTheme: Theme{},
 			VoteTheme:   Theme{},
-		}, errors.New("No items found for league")
+		}, err
 	}
 
-	league, err := items[0].toLeague()
+	return leagueFromItems(meta, themes, time.Now())
+}
+
+// leagueFromItems builds a League from its meta item and all of its themes in
+// ascending date order. The submit and vote themes are the most recent themes
+// in those phases at the given time.
+func leagueFromItems(meta MxtpItem, themes []MxtpItem, now time.Time) (League, error) {
+	league, err := meta.toLeague()
 	if err != nil {
 		return League{
 			SubmitTheme: Theme{},
@@ -261,33 +410,535 @@ func (db *DB) GetLeague(leagueName string) (League, error) {
 		}, err
 	}
 
-	if len(items) == 1 {
-		// no themes yet for league
-		return league, nil
+	for _, item := range themes {
+		theme, err := item.toTheme()
+		if err != nil {
+			return league, err
+		}
+		theme, err = league.ScheduleTheme(theme)
+		if err != nil {
+			return league, err
+		}
+
+		switch theme.PhaseAt(now) {
+		case PhaseSubmit:
+			league.SubmitTheme = theme
+		case PhaseVote:
+			league.VoteTheme = theme
+		}
 	}
-	submitTheme, err := items[1].toTheme()
+
+	return league, nil
+}
+
+// PutLeague creates the league or replaces its info. Themes are unaffected.
+func (db *DB) PutLeague(league League) error {
+	pk, err := makeLeaguePK(league.Name)
 	if err != nil {
-		return league, err
+		return err
+	}
+	if league.Name == "" {
+		return errors.New("League must have a name")
 	}
-	league.SubmitTheme = submitTheme
 
-	if len(items) == 2 {
-		// only one theme (submit theme)
-		return league, nil
+	return db.table.Put(leagueToItem(pk, league)).RunWithContext(db.ctx)
+}
+
+func leagueToItem(pk string, league League) MxtpItem {
+	return MxtpItem{
+		PK:             pk,
+		SK:             leagueMetaSK,
+		Name:           league.Name,
+		Description:    league.Description,
+		SubmitDays:     league.SubmitDays,
+		VoteDays:       league.VoteDays,
+		MinVotes:       league.MinVotes,
+		MaxVotes:       league.MaxVotes,
+		AllowSelfVotes: league.AllowSelfVotes,
 	}
-	voteTheme, err := items[2].toTheme()
+}
+
+// GetLeagueNames returns the name of every league. It scans the whole table,
+// so is only meant for infrequent jobs.
+func (db *DB) GetLeagueNames() ([]string, error) {
+	var items []MxtpItem
+	err := db.table.Scan().
+		Filter("SK = ?", leagueMetaSK).
+		AllWithContext(db.ctx, &items)
 	if err != nil {
-		return league, err
+		return nil, err
 	}
-	league.VoteTheme = voteTheme
 
-	return league, nil
+	return leagueNamesFromItems(items)
+}
+
+func leagueNamesFromItems(items []MxtpItem) ([]string, error) {
+	var names []string
+	for _, item := range items {
+		league, err := item.toLeague()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, league.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// GetThemes returns all of the league's themes, including those scheduled for
+// the future, ordered by date.
+func (db *DB) GetThemes(leagueName string) ([]Theme, error) {
+	pk, err := makeLeaguePK(leagueName)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []MxtpItem
+	err = db.table.Get("PK", pk).
+		Range("SK", dynamo.BeginsWith, "theme#").
+		AllWithContext(db.ctx, &items)
+	if err != nil {
+		return nil, err
+	}
+
+	return themesFromItems(items)
+}
+
+func themesFromItems(items []MxtpItem) ([]Theme, error) {
+	themes := []Theme{}
+	for _, item := range items {
+		theme, err := item.toTheme()
+		if err != nil {
+			return nil, err
+		}
+		themes = append(themes, theme)
+	}
+	return themes, nil
+}
+
+func (db *DB) GetTheme(leagueName, themeId string) (Theme, error) {
+	pk, err := makeLeaguePK(leagueName)
+	if err != nil {
+		return Theme{}, err
+	}
+	sk, err := makeThemeSK(themeId)
+	if err != nil {
+		return Theme{}, err
+	}
+
+	var item MxtpItem
+	err = db.table.Get("PK", pk).
+		Range("SK", dynamo.Equal, sk).
+		OneWithContext(db.ctx, &item)
+	if err != nil {
+		return Theme{}, err
+	}
+
+	return item.toTheme()
+}
+
+// PutTheme creates or replaces the theme identified by its Date.
+func (db *DB) PutTheme(leagueName string, theme Theme) error {
+	item, err := themeToItem(leagueName, theme)
+	if err != nil {
+		return err
+	}
+
+	return db.table.Put(item).RunWithContext(db.ctx)
+}
+
+func themeToItem(leagueName string, theme Theme) (MxtpItem, error) {
+	pk, err := makeLeaguePK(leagueName)
+	if err != nil {
+		return MxtpItem{}, err
+	}
+	if _, err := time.Parse(ThemeDateFormat, theme.Date); err != nil {
+		return MxtpItem{}, fmt.Errorf("Theme date must be formatted as %v", ThemeDateFormat)
+	}
+	if theme.Name == "" {
+		return MxtpItem{}, errors.New("Theme must have a name")
+	}
+	sk, err := makeThemeSK(theme.Date)
+	if err != nil {
+		return MxtpItem{}, err
+	}
+
+	return MxtpItem{
+		PK:                pk,
+		SK:                sk,
+		Name:              theme.Name,
+		Description:       theme.Description,
+		Date:              theme.Date,
+		SubmitOpen:        theme.SubmitOpen,
+		SubmitClose:       theme.SubmitClose,
+		VoteOpen:          theme.VoteOpen,
+		VoteClose:         theme.VoteClose,
+		SpotifyPlaylistId: theme.SpotifyPlaylistId,
+		SubmitOpenedAt:    theme.SubmitOpenedAt,
+		SubmitRemindedAt:  theme.SubmitRemindedAt,
+		SubmitClosedAt:    theme.SubmitClosedAt,
+		VoteOpenedAt:      theme.VoteOpenedAt,
+		VoteClosedAt:      theme.VoteClosedAt,
+	}, nil
+}
+
+// SetThemePlaylist sets the theme's Spotify playlist, returning ErrNotFound
+// if the theme does not exist.
+func (db *DB) SetThemePlaylist(leagueName, themeId, playlistId string) error {
+	pk, err := makeLeaguePK(leagueName)
+	if err != nil {
+		return err
+	}
+	sk, err := makeThemeSK(themeId)
+	if err != nil {
+		return err
+	}
+
+	// only set the playlist so concurrent transitions aren't overwritten
+	err = db.table.Update("PK", pk).
+		Range("SK", sk).
+		Set("SpotifyPlaylistId", playlistId).
+		If("attribute_exists(PK)").
+		RunWithContext(db.ctx)
+	if isConditionalCheckFailed(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// MarkTransition records that the theme's transition was acted on. It returns
+// ErrTransitionDone if it already was, so only one caller acts on it, or
+// ErrNotFound if the theme does not exist.
+func (db *DB) MarkTransition(leagueName, themeId string, transition Transition, at time.Time) error {
+	pk, err := makeLeaguePK(leagueName)
+	if err != nil {
+		return err
+	}
+	sk, err := makeThemeSK(themeId)
+	if err != nil {
+		return err
+	}
+	if _, err := (&MxtpItem{}).transitionField(transition); err != nil {
+		return err
+	}
+
+	field := string(transition) + "At"
+	err = db.table.Update("PK", pk).
+		Range("SK", sk).
+		Set(field, at).
+		If("attribute_exists(PK) AND attribute_not_exists($)", field).
+		RunWithContext(db.ctx)
+	if !isConditionalCheckFailed(err) {
+		return err
+	}
+
+	// find out which condition failed
+	_, err = db.GetTheme(leagueName, themeId)
+	if err != nil {
+		return err
+	}
+	return ErrTransitionDone
+}
+
+func isConditionalCheckFailed(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+// DeleteTheme deletes the theme, returning ErrNotFound if it does not exist.
+// Songs and votes submitted for the theme are kept.
+func (db *DB) DeleteTheme(leagueName, themeId string) error {
+	pk, err := makeLeaguePK(leagueName)
+	if err != nil {
+		return err
+	}
+	sk, err := makeThemeSK(themeId)
+	if err != nil {
+		return err
+	}
+
+	var old MxtpItem
+	return db.table.Delete("PK", pk).
+		Range("SK", sk).
+		OldValueWithContext(db.ctx, &old)
+}
+
+func makeMemberKeys(leagueName, userId string) (pk, sk string, err error) {
+	err = validateIds(leagueName, userId)
+	if err != nil {
+		return "", "", err
+	}
+	if userId == "" {
+		return "", "", errors.New("Member must have a user id")
+	}
+
+	pk, err = makeLeaguePK(leagueName)
+	if err != nil {
+		return "", "", err
+	}
+
+	sk = fmt.Sprintf("member#%v", userId)
+	return pk, sk, err
+}
+
+func validateRole(role string) error {
+	switch role {
+	case RoleMember, RoleAdmin, RoleOwner:
+		return nil
+	default:
+		return fmt.Errorf("Invalid role %q", role)
+	}
+}
+
+// PutMember adds the user to the league or changes their role.
+func (db *DB) PutMember(leagueName, userId, role string) error {
+	pk, sk, err := makeMemberKeys(leagueName, userId)
+	if err != nil {
+		return err
+	}
+	err = validateRole(role)
+	if err != nil {
+		return err
+	}
+
+	member := MxtpItem{
+		PK:     pk,
+		SK:     sk,
+		UserId: userId,
+		Role:   role,
+	}
+
+	return db.table.Put(member).RunWithContext(db.ctx)
+}
+
+// RemoveMember removes the user from the league, returning ErrNotFound if they
+// were not a member.
+func (db *DB) RemoveMember(leagueName, userId string) error {
+	pk, sk, err := makeMemberKeys(leagueName, userId)
+	if err != nil {
+		return err
+	}
+
+	var old MxtpItem
+	return db.table.Delete("PK", pk).
+		Range("SK", sk).
+		OldValueWithContext(db.ctx, &old)
+}
+
+func (db *DB) GetMember(leagueName, userId string) (Member, error) {
+	pk, sk, err := makeMemberKeys(leagueName, userId)
+	if err != nil {
+		return Member{}, err
+	}
+
+	var item MxtpItem
+	err = db.table.Get("PK", pk).
+		Range("SK", dynamo.Equal, sk).
+		OneWithContext(db.ctx, &item)
+	if err != nil {
+		return Member{}, err
+	}
+
+	return item.toMember()
+}
+
+// GetMembers returns the league's members ordered by user id.
+func (db *DB) GetMembers(leagueName string) ([]Member, error) {
+	pk, err := makeLeaguePK(leagueName)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []MxtpItem
+	err = db.table.Get("PK", pk).
+		Range("SK", dynamo.BeginsWith, "member#").
+		AllWithContext(db.ctx, &items)
+	if err != nil {
+		return nil, err
+	}
+
+	return membersFromItems(items)
+}
+
+func membersFromItems(items []MxtpItem) ([]Member, error) {
+	members := []Member{}
+	for _, item := range items {
+		member, err := item.toMember()
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+func makeHookKeys(leagueName, hookId string) (pk, sk string, err error) {
+	err = validateIds(leagueName, hookId)
+	if err != nil {
+		return "", "", err
+	}
+	if hookId == "" {
+		return "", "", errors.New("Hook must have an id")
+	}
+
+	pk, err = makeLeaguePK(leagueName)
+	if err != nil {
+		return "", "", err
+	}
+
+	sk = fmt.Sprintf("hook#%v", hookId)
+	return pk, sk, err
+}
+
+func hookToItem(leagueName string, hook Hook) (MxtpItem, error) {
+	pk, sk, err := makeHookKeys(leagueName, hook.Id)
+	if err != nil {
+		return MxtpItem{}, err
+	}
+
+	return MxtpItem{
+		PK:     pk,
+		SK:     sk,
+		Kind:   hook.Kind,
+		Url:    hook.Url,
+		Secret: hook.Secret,
+		Events: hook.Events,
+	}, nil
+}
+
+func (item *MxtpItem) toHook() (Hook, error) {
+	err := validateCompoundKey(item.SK, "hook")
+	if err != nil {
+		return Hook{}, errors.New(fmt.Sprintf("Failed to validate Hook: %v", err.Error()))
+	}
+
+	return Hook{
+		Id:     strings.TrimPrefix(item.SK, "hook#"),
+		Kind:   item.Kind,
+		Url:    item.Url,
+		Secret: item.Secret,
+		Events: item.Events,
+	}, nil
+}
+
+func hooksFromItems(items []MxtpItem) ([]Hook, error) {
+	var hooks []Hook
+	for _, item := range items {
+		hook, err := item.toHook()
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+// PutHook creates or replaces the league's hook with the same id.
+func (db *DB) PutHook(leagueName string, hook Hook) error {
+	item, err := hookToItem(leagueName, hook)
+	if err != nil {
+		return err
+	}
+
+	return db.table.Put(item).RunWithContext(db.ctx)
+}
+
+// GetHooks returns the league's hooks ordered by id.
+func (db *DB) GetHooks(leagueName string) ([]Hook, error) {
+	pk, err := makeLeaguePK(leagueName)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []MxtpItem
+	err = db.table.Get("PK", pk).
+		Range("SK", dynamo.BeginsWith, "hook#").
+		AllWithContext(db.ctx, &items)
+	if err != nil {
+		return nil, err
+	}
+
+	return hooksFromItems(items)
+}
+
+// DeleteHook deletes the hook, returning ErrNotFound if it does not exist.
+func (db *DB) DeleteHook(leagueName, hookId string) error {
+	pk, sk, err := makeHookKeys(leagueName, hookId)
+	if err != nil {
+		return err
+	}
+
+	var old MxtpItem
+	return db.table.Delete("PK", pk).
+		Range("SK", sk).
+		OldValueWithContext(db.ctx, &old)
+}
+
+func makeSlackUserKeys(leagueName, slackUserId string) (pk, sk string, err error) {
+	err = validateIds(leagueName, slackUserId)
+	if err != nil {
+		return "", "", err
+	}
+	if slackUserId == "" {
+		return "", "", errors.New("Slack user must have an id")
+	}
+
+	pk, err = makeLeaguePK(leagueName)
+	if err != nil {
+		return "", "", err
+	}
+
+	sk = fmt.Sprintf("slack#%v", slackUserId)
+	return pk, sk, err
+}
+
+func (item *MxtpItem) toSlackUser() (string, error) {
+	err := validateCompoundKey(item.SK, "slack")
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Failed to validate Slack user: %v", err.Error()))
+	}
+
+	return item.UserId, nil
+}
+
+// PutSlackUser links a Slack user to the league user they submit and vote as
+// from Slack.
+func (db *DB) PutSlackUser(leagueName, slackUserId, userId string) error {
+	pk, sk, err := makeSlackUserKeys(leagueName, slackUserId)
+	if err != nil {
+		return err
+	}
+	err = validateIds(userId)
+	if err != nil {
+		return err
+	}
+
+	return db.table.Put(MxtpItem{PK: pk, SK: sk, UserId: userId}).RunWithContext(db.ctx)
+}
+
+// GetSlackUser returns the league user linked to the Slack user, or
+// ErrNotFound if they haven't been linked.
+func (db *DB) GetSlackUser(leagueName, slackUserId string) (string, error) {
+	pk, sk, err := makeSlackUserKeys(leagueName, slackUserId)
+	if err != nil {
+		return "", err
+	}
+
+	var item MxtpItem
+	err = db.table.Get("PK", pk).
+		Range("SK", dynamo.Equal, sk).
+		OneWithContext(db.ctx, &item)
+	if err != nil {
+		return "", err
+	}
+
+	return item.toSlackUser()
 }
 
 func makeSongKeys(leagueName, themeId, userId string) (pk, sk string, err error) {
 	err = validateIds(leagueName, themeId, userId)
 	if err != nil {
-		return "", "", nil
+		return "", "", err
 	}
 
 	pk, err = makeThemeItemsPK(leagueName, themeId)
@@ -302,7 +953,7 @@ func makeSongKeys(leagueName, themeId, userId string) (pk, sk string, err error)
 func makeVotesKeys(leagueName, themeId, userId string) (pk, sk string, err error) {
 	err = validateIds(leagueName, themeId, userId)
 	if err != nil {
-		return "", "", nil
+		return "", "", err
 	}
 
 	pk, err = makeThemeItemsPK(leagueName, themeId)
@@ -322,7 +973,7 @@ func (db *DB) GetSong(leagueName, themeId, userId string) (Song, error) {
 	var item MxtpItem
 	err = db.table.Get("PK", pk).
 		Range("SK", dynamo.Equal, sk).
-		One(&item)
+		OneWithContext(db.ctx, &item)
 
 	if err != nil {
 		return Song{}, err
@@ -368,12 +1019,18 @@ func (db *DB) GetThemeItems(leagueName, themeId string) (ThemeItems, error) {
 
 	var items []MxtpItem
 	err = db.table.Get("PK", pk).
-		All(&items)
+		AllWithContext(db.ctx, &items)
 
 	if err != nil {
 		return ThemeItems{}, err
 	}
 
+	return themeItemsFromItems(themeId, items)
+}
+
+// themeItemsFromItems builds ThemeItems from all items stored for a theme, in
+// ascending sort key order.
+func themeItemsFromItems(themeId string, items []MxtpItem) (ThemeItems, error) {
 	// Expected order of results
 	// [0:x] - Songs
 	// [x:] - Votes
@@ -428,7 +1085,7 @@ func (db *DB) UpdateSong(leagueName, themeId, userId, songUrl, submissionId, spo
 		Artists:        songArtists,
 	}
 
-	return db.table.Put(song).Run()
+	return db.table.Put(song).RunWithContext(db.ctx)
 }
 
 func (db *DB) UpdateVotes(leagueName, themeId, userId string, submissionIds []string) error {
@@ -443,13 +1100,13 @@ func (db *DB) UpdateVotes(leagueName, themeId, userId string, submissionIds []st
 		SubmissionIds: submissionIds,
 	}
 
-	return db.table.Put(song).Run()
+	return db.table.Put(song).RunWithContext(db.ctx)
 }
 
 func makeSpotifyTokenKeys(userId string) (pk, sk string, err error) {
 	err = validateIds(userId)
 	if err != nil {
-		return "", "", nil
+		return "", "", err
 	}
 
 	pk = fmt.Sprintf("secret#%v", userId)
@@ -466,7 +1123,7 @@ func (db *DB) GetSpotifyToken(userId string) (*oauth2.Token, error) {
 	var item MxtpItem
 	err = db.table.Get("PK", pk).
 		Range("SK", dynamo.Equal, sk).
-		One(&item)
+		OneWithContext(db.ctx, &item)
 	if err != nil {
 		return nil, err
 	}
@@ -493,13 +1150,51 @@ func (db *DB) UpdateSpotifyToken(token *oauth2.Token, userId string) error {
 		Expiry:       token.Expiry,
 	}
 
-	return db.table.Put(tokenItem).Run()
+	return db.table.Put(tokenItem).RunWithContext(db.ctx)
+}
+
+// FlagSpotifyReauth marks the user's Spotify token as revoked, so they need
+// to connect their account again. The flag is cleared by UpdateSpotifyToken.
+func (db *DB) FlagSpotifyReauth(userId string) error {
+	pk, sk, err := makeSpotifyTokenKeys(userId)
+	if err != nil {
+		return err
+	}
+
+	// only set the flag so a token saved meanwhile isn't overwritten
+	err = db.table.Update("PK", pk).
+		Range("SK", sk).
+		Set("ReauthRequired", true).
+		If("attribute_exists(PK)").
+		RunWithContext(db.ctx)
+	if isConditionalCheckFailed(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// SpotifyReauthRequired returns true if the user's Spotify token was revoked
+func (db *DB) SpotifyReauthRequired(userId string) (bool, error) {
+	pk, sk, err := makeSpotifyTokenKeys(userId)
+	if err != nil {
+		return false, err
+	}
+
+	var item MxtpItem
+	err = db.table.Get("PK", pk).
+		Range("SK", dynamo.Equal, sk).
+		OneWithContext(db.ctx, &item)
+	if err != nil {
+		return false, err
+	}
+
+	return item.ReauthRequired, nil
 }
 
 func makeUserStateKeys(state string) (pk, sk string, err error) {
 	err = validateIds(state)
 	if err != nil {
-		return "", "", nil
+		return "", "", err
 	}
 
 	pk = fmt.Sprintf("state#%v", state)
@@ -516,7 +1211,7 @@ func (db *DB) GetUserFromState(state string) (string, error) {
 	var item MxtpItem
 	err = db.table.Get("PK", pk).
 		Range("SK", dynamo.Equal, sk).
-		One(&item)
+		OneWithContext(db.ctx, &item)
 	if err != nil {
 		return "", err
 	}
@@ -536,5 +1231,57 @@ func (db *DB) UpdateUserState(userId, state string) error {
 		UserId: userId,
 	}
 
-	return db.table.Put(tokenItem).Run()
+	return db.table.Put(tokenItem).RunWithContext(db.ctx)
+}
+
+func makeLoginNonceKeys(nonce string) (pk, sk string, err error) {
+	err = validateIds(nonce)
+	if err != nil {
+		return "", "", err
+	}
+	if nonce == "" {
+		return "", "", errors.New("Login nonce is required")
+	}
+
+	pk = fmt.Sprintf("login#%v", nonce)
+	sk = "login"
+	return pk, sk, err
+}
+
+// PutLoginNonce stores a single use login nonce for the user that expires at
+// the given time.
+func (db *DB) PutLoginNonce(userId, nonce string, expiry time.Time) error {
+	pk, sk, err := makeLoginNonceKeys(nonce)
+	if err != nil {
+		return err
+	}
+
+	nonceItem := MxtpItem{
+		PK:     pk,
+		SK:     sk,
+		UserId: userId,
+		Expiry: expiry,
+	}
+
+	return db.table.Put(nonceItem).RunWithContext(db.ctx)
+}
+
+// ConsumeLoginNonce deletes the nonce and returns the user it was issued for.
+// ErrNotFound is returned if the nonce does not exist or was already used, and
+// ErrNonceExpired if it has expired.
+func (db *DB) ConsumeLoginNonce(nonce string) (string, error) {
+	pk, sk, err := makeLoginNonceKeys(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	var item MxtpItem
+	err = db.table.Delete("PK", pk).
+		Range("SK", sk).
+		OldValueWithContext(db.ctx, &item)
+	if err != nil {
+		return "", err
+	}
+
+	return item.toLoginUser(time.Now())
 }
@@ -0,0 +1,182 @@
+package mxtpdb
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Phase is the stage a theme is in. Themes move through the phases in order:
+// scheduled, submit, (pending,) vote, closed. The pending phase only exists
+// if voting opens some time after submissions close.
+type Phase string
+
+const (
+	PhaseScheduled Phase = "scheduled"
+	PhaseSubmit    Phase = "submit"
+	PhasePending   Phase = "pending"
+	PhaseVote      Phase = "vote"
+	PhaseClosed    Phase = "closed"
+)
+
+// Default league cadence, used when a league doesn't set its own.
+const (
+	DefaultSubmitDays = 14
+	DefaultVoteDays   = 14
+)
+
+const day = time.Hour * 24
+
+// ScheduleTheme fills in any of the theme's unset phase timestamps using the
+// league's cadence: submissions open at midnight UTC on the theme's Date and
+// stay open for SubmitDays, then voting opens and stays open for VoteDays.
+func (league League) ScheduleTheme(theme Theme) (Theme, error) {
+	start, err := time.Parse(ThemeDateFormat, theme.Date)
+	if err != nil {
+		return theme, fmt.Errorf("Theme date must be formatted as %v", ThemeDateFormat)
+	}
+
+	submitDays := league.SubmitDays
+	if submitDays <= 0 {
+		submitDays = DefaultSubmitDays
+	}
+	voteDays := league.VoteDays
+	if voteDays <= 0 {
+		voteDays = DefaultVoteDays
+	}
+
+	if theme.SubmitOpen.IsZero() {
+		theme.SubmitOpen = start
+	}
+	if theme.SubmitClose.IsZero() {
+		theme.SubmitClose = theme.SubmitOpen.Add(day * time.Duration(submitDays))
+	}
+	if theme.VoteOpen.IsZero() {
+		theme.VoteOpen = theme.SubmitClose
+	}
+	if theme.VoteClose.IsZero() {
+		theme.VoteClose = theme.VoteOpen.Add(day * time.Duration(voteDays))
+	}
+
+	return theme, theme.validateSchedule()
+}
+
+func (theme Theme) validateSchedule() error {
+	if theme.SubmitClose.Before(theme.SubmitOpen) ||
+		theme.VoteOpen.Before(theme.SubmitClose) ||
+		theme.VoteClose.Before(theme.VoteOpen) {
+		return errors.New("Theme phases must be ordered submit open, submit close, vote open, vote close")
+	}
+	return nil
+}
+
+// PhaseAt returns the theme's phase at the given time. Each phase includes its
+// opening time and excludes its closing time. The theme must be scheduled.
+func (theme Theme) PhaseAt(now time.Time) Phase {
+	switch {
+	case now.Before(theme.SubmitOpen):
+		return PhaseScheduled
+	case now.Before(theme.SubmitClose):
+		return PhaseSubmit
+	case now.Before(theme.VoteOpen):
+		return PhasePending
+	case now.Before(theme.VoteClose):
+		return PhaseVote
+	default:
+		return PhaseClosed
+	}
+}
+
+// PhaseError describes an action attempted outside of the phase it requires.
+type PhaseError struct {
+	Theme    Theme
+	Required Phase
+	Actual   Phase
+}
+
+func (e *PhaseError) Error() string {
+	var opens, closes time.Time
+	switch e.Required {
+	case PhaseSubmit:
+		opens, closes = e.Theme.SubmitOpen, e.Theme.SubmitClose
+	case PhaseVote:
+		opens, closes = e.Theme.VoteOpen, e.Theme.VoteClose
+	case PhaseClosed:
+		return fmt.Sprintf("Theme %v is in the %v phase until %v", e.Theme.Date, e.Actual, e.Theme.VoteClose.UTC().Format(time.RFC3339))
+	}
+
+	return fmt.Sprintf(
+		"Theme %v is in the %v phase, but this requires the %v phase (%v to %v)",
+		e.Theme.Date,
+		e.Actual,
+		e.Required,
+		opens.UTC().Format(time.RFC3339),
+		closes.UTC().Format(time.RFC3339),
+	)
+}
+
+// RequirePhase returns a *PhaseError if the theme is not in the phase at the
+// given time.
+func (theme Theme) RequirePhase(required Phase, now time.Time) error {
+	actual := theme.PhaseAt(now)
+	if actual != required {
+		return &PhaseError{
+			Theme:    theme,
+			Required: required,
+			Actual:   actual,
+		}
+	}
+	return nil
+}
+
+// Transition is a phase boundary that has been acted on, e.g. by building the
+// playlist once submissions close. Each is recorded on the theme so it's only
+// acted on once.
+type Transition string
+
+const (
+	TransitionSubmitOpened   Transition = "SubmitOpened"
+	TransitionSubmitReminded Transition = "SubmitReminded"
+	TransitionSubmitClosed   Transition = "SubmitClosed"
+	TransitionVoteOpened     Transition = "VoteOpened"
+	TransitionVoteClosed     Transition = "VoteClosed"
+)
+
+// ErrTransitionDone is returned when marking a transition that was already
+// marked.
+var ErrTransitionDone = errors.New("Theme transition already done")
+
+// TransitionedAt returns when the transition was marked, or the zero time if
+// it hasn't been.
+func (theme Theme) TransitionedAt(transition Transition) time.Time {
+	switch transition {
+	case TransitionSubmitOpened:
+		return theme.SubmitOpenedAt
+	case TransitionSubmitReminded:
+		return theme.SubmitRemindedAt
+	case TransitionSubmitClosed:
+		return theme.SubmitClosedAt
+	case TransitionVoteOpened:
+		return theme.VoteOpenedAt
+	case TransitionVoteClosed:
+		return theme.VoteClosedAt
+	}
+	return time.Time{}
+}
+
+// transitionField returns the item's field for the transition
+func (item *MxtpItem) transitionField(transition Transition) (*time.Time, error) {
+	switch transition {
+	case TransitionSubmitOpened:
+		return &item.SubmitOpenedAt, nil
+	case TransitionSubmitReminded:
+		return &item.SubmitRemindedAt, nil
+	case TransitionSubmitClosed:
+		return &item.SubmitClosedAt, nil
+	case TransitionVoteOpened:
+		return &item.VoteOpenedAt, nil
+	case TransitionVoteClosed:
+		return &item.VoteClosedAt, nil
+	}
+	return nil, fmt.Errorf("Unknown theme transition %v", transition)
+}
@@ -0,0 +1,82 @@
+package mxtpdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func date(value string) time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestScheduleThemeDefaults(t *testing.T) {
+	theme, err := League{}.ScheduleTheme(Theme{Date: "2020-05-01"})
+	require.Nil(t, err)
+	require.Equal(t, date("2020-05-01T00:00:00Z"), theme.SubmitOpen)
+	require.Equal(t, date("2020-05-15T00:00:00Z"), theme.SubmitClose)
+	require.Equal(t, date("2020-05-15T00:00:00Z"), theme.VoteOpen)
+	require.Equal(t, date("2020-05-29T00:00:00Z"), theme.VoteClose)
+}
+
+func TestScheduleThemeLeagueCadence(t *testing.T) {
+	league := League{SubmitDays: 7, VoteDays: 3}
+	theme, err := league.ScheduleTheme(Theme{
+		Date:     "2020-05-01",
+		VoteOpen: date("2020-05-09T12:00:00Z"),
+	})
+	require.Nil(t, err)
+	require.Equal(t, date("2020-05-08T00:00:00Z"), theme.SubmitClose)
+	require.Equal(t, date("2020-05-09T12:00:00Z"), theme.VoteOpen)
+	require.Equal(t, date("2020-05-12T12:00:00Z"), theme.VoteClose)
+
+	// scheduling is idempotent
+	again, err := league.ScheduleTheme(theme)
+	require.Nil(t, err)
+	require.Equal(t, theme, again)
+}
+
+func TestScheduleThemeRejectsBadSchedules(t *testing.T) {
+	_, err := League{}.ScheduleTheme(Theme{Date: "May 1"})
+	require.NotNil(t, err)
+
+	_, err = League{}.ScheduleTheme(Theme{
+		Date:     "2020-05-01",
+		VoteOpen: date("2020-05-10T00:00:00Z"),
+	})
+	require.NotNil(t, err, "voting can't open before submissions close")
+}
+
+func TestPhaseAt(t *testing.T) {
+	theme, err := League{SubmitDays: 7, VoteDays: 7}.ScheduleTheme(Theme{
+		Date:     "2020-05-01",
+		VoteOpen: date("2020-05-09T00:00:00Z"),
+	})
+	require.Nil(t, err)
+
+	require.Equal(t, PhaseScheduled, theme.PhaseAt(date("2020-04-30T23:59:59Z")))
+	require.Equal(t, PhaseSubmit, theme.PhaseAt(date("2020-05-01T00:00:00Z")))
+	require.Equal(t, PhasePending, theme.PhaseAt(date("2020-05-08T00:00:00Z")))
+	require.Equal(t, PhaseVote, theme.PhaseAt(date("2020-05-09T00:00:00Z")))
+	require.Equal(t, PhaseClosed, theme.PhaseAt(date("2020-05-16T00:00:00Z")))
+}
+
+func TestRequirePhase(t *testing.T) {
+	theme, err := League{}.ScheduleTheme(Theme{Date: "2020-05-01"})
+	require.Nil(t, err)
+
+	now := date("2020-05-20T00:00:00Z")
+	require.Nil(t, theme.RequirePhase(PhaseVote, now))
+
+	err = theme.RequirePhase(PhaseSubmit, now)
+	phaseErr, ok := err.(*PhaseError)
+	require.True(t, ok)
+	require.Equal(t, PhaseSubmit, phaseErr.Required)
+	require.Equal(t, PhaseVote, phaseErr.Actual)
+	require.Contains(t, err.Error(), "2020-05-15T00:00:00Z")
+}
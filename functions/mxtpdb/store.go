@@ -0,0 +1,126 @@
+package mxtpdb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Store is implemented by the storage backends for mxtp data. DB is the
+// DynamoDB backed implementation and MemoryStore keeps everything in process.
+type Store interface {
+	// WithContext returns the store with its calls made with ctx
+	WithContext(ctx context.Context) Store
+
+	GetLeague(leagueName string) (League, error)
+	GetLeagueNames() ([]string, error)
+	PutLeague(league League) error
+	GetThemes(leagueName string) ([]Theme, error)
+	GetTheme(leagueName, themeId string) (Theme, error)
+	PutTheme(leagueName string, theme Theme) error
+	SetThemePlaylist(leagueName, themeId, playlistId string) error
+	MarkTransition(leagueName, themeId string, transition Transition, at time.Time) error
+	DeleteTheme(leagueName, themeId string) error
+
+	PutMember(leagueName, userId, role string) error
+	RemoveMember(leagueName, userId string) error
+	GetMember(leagueName, userId string) (Member, error)
+	GetMembers(leagueName string) ([]Member, error)
+	PutHook(leagueName string, hook Hook) error
+	GetHooks(leagueName string) ([]Hook, error)
+	DeleteHook(leagueName, hookId string) error
+	PutSlackUser(leagueName, slackUserId, userId string) error
+	GetSlackUser(leagueName, slackUserId string) (string, error)
+	GetThemeItems(leagueName, themeId string) (ThemeItems, error)
+	GetSong(leagueName, themeId, userId string) (Song, error)
+	UpdateSong(leagueName, themeId, userId, songUrl, submissionId, spotifyTrackId, songName string, songArtists []string) error
+	UpdateVotes(leagueName, themeId, userId string, submissionIds []string) error
+
+	GetSpotifyToken(userId string) (*oauth2.Token, error)
+	UpdateSpotifyToken(token *oauth2.Token, userId string) error
+	FlagSpotifyReauth(userId string) error
+	SpotifyReauthRequired(userId string) (bool, error)
+
+	GetUserFromState(state string) (string, error)
+	UpdateUserState(userId, state string) error
+
+	PutLoginNonce(userId, nonce string, expiry time.Time) error
+	ConsumeLoginNonce(nonce string) (string, error)
+}
+
+var _ Store = (*DB)(nil)
+var _ Store = (*MemoryStore)(nil)
+
+// BackfillMembers adds the users who submitted songs or voted in the league's
+// themes as members, and makes owner the league's owner if it has none. It's
+// for leagues created before membership was stored. Existing members keep
+// their roles, so it's safe to run more than once.
+func BackfillMembers(db Store, leagueName, owner string) error {
+	members, err := db.GetMembers(leagueName)
+	if err != nil {
+		return err
+	}
+
+	roles := map[string]string{}
+	hasOwner := false
+	for _, member := range members {
+		roles[member.UserId] = member.Role
+		hasOwner = hasOwner || member.Role == RoleOwner
+	}
+	if !hasOwner {
+		if err := db.PutMember(leagueName, owner, RoleOwner); err != nil {
+			return err
+		}
+		roles[owner] = RoleOwner
+	}
+
+	themes, err := db.GetThemes(leagueName)
+	if err != nil {
+		return err
+	}
+	for _, theme := range themes {
+		items, err := db.GetThemeItems(leagueName, theme.Date)
+		if err != nil {
+			return err
+		}
+
+		var userIds []string
+		for _, song := range items.Songs {
+			userIds = append(userIds, song.UserId)
+		}
+		for _, votes := range items.Votes {
+			userIds = append(userIds, votes.UserId)
+		}
+		for _, userId := range userIds {
+			if _, ok := roles[userId]; ok || userId == "" {
+				continue
+			}
+			if err := db.PutMember(leagueName, userId, RoleMember); err != nil {
+				return err
+			}
+			roles[userId] = RoleMember
+		}
+	}
+	return nil
+}
+
+// ErrNoOwner is returned by LeagueOwner for a league without an owner.
+var ErrNoOwner = errors.New("League has no owner")
+
+// LeagueOwner returns the owner of the league, whose Spotify account is used
+// for the league's playlists.
+func LeagueOwner(db Store, leagueName string) (string, error) {
+	members, err := db.GetMembers(leagueName)
+	if err != nil {
+		return "", err
+	}
+
+	for _, member := range members {
+		if member.Role == RoleOwner {
+			return member.UserId, nil
+		}
+	}
+	return "", ErrNoOwner
+}
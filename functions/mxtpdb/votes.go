@@ -0,0 +1,100 @@
+package mxtpdb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Voting rules, as reported in a VoteViolation
+const (
+	RuleDuplicateVote     = "duplicate_vote"
+	RuleUnknownSubmission = "unknown_submission"
+	RuleSelfVote          = "self_vote"
+	RuleMinVotes          = "min_votes"
+	RuleMaxVotes          = "max_votes"
+)
+
+// VoteViolation is a voting rule broken by a user's votes
+type VoteViolation struct {
+	Rule    string
+	Message string
+}
+
+// VoteRuleError lists every rule broken by a user's votes
+type VoteRuleError struct {
+	Violations []VoteViolation
+}
+
+func (e *VoteRuleError) Error() string {
+	var messages []string
+	for _, violation := range e.Violations {
+		messages = append(messages, violation.Message)
+	}
+	return "Votes break the league's rules: " + strings.Join(messages, "; ")
+}
+
+// CheckVotes returns a *VoteRuleError if the user's votes for the theme break
+// the league's rules. Votes must be for distinct submissions in the theme, and
+// the league's MinVotes, MaxVotes and AllowSelfVotes are applied on top.
+func (league League) CheckVotes(items ThemeItems, userId string, submissionIds []string) error {
+	var violations []VoteViolation
+
+	submitters := map[string]string{}
+	for _, song := range items.Songs {
+		submitters[song.SubmissionId] = song.UserId
+	}
+
+	seen := map[string]bool{}
+	var duplicates, unknown []string
+	selfVote := false
+	for _, submissionId := range submissionIds {
+		if seen[submissionId] {
+			duplicates = append(duplicates, submissionId)
+			continue
+		}
+		seen[submissionId] = true
+
+		submitter, ok := submitters[submissionId]
+		if !ok {
+			unknown = append(unknown, submissionId)
+		} else if submitter == userId {
+			selfVote = true
+		}
+	}
+
+	if len(duplicates) > 0 {
+		violations = append(violations, VoteViolation{
+			Rule:    RuleDuplicateVote,
+			Message: "Submissions can only be voted for once: " + strings.Join(duplicates, ", "),
+		})
+	}
+	if len(unknown) > 0 {
+		violations = append(violations, VoteViolation{
+			Rule:    RuleUnknownSubmission,
+			Message: fmt.Sprintf("Submissions aren't in theme %v: %v", items.Id, strings.Join(unknown, ", ")),
+		})
+	}
+	if selfVote && !league.AllowSelfVotes {
+		violations = append(violations, VoteViolation{
+			Rule:    RuleSelfVote,
+			Message: "You can't vote for your own song",
+		})
+	}
+	if league.MinVotes > 0 && len(seen) < league.MinVotes {
+		violations = append(violations, VoteViolation{
+			Rule:    RuleMinVotes,
+			Message: fmt.Sprintf("Vote for at least %v songs", league.MinVotes),
+		})
+	}
+	if league.MaxVotes > 0 && len(seen) > league.MaxVotes {
+		violations = append(violations, VoteViolation{
+			Rule:    RuleMaxVotes,
+			Message: fmt.Sprintf("Vote for at most %v songs", league.MaxVotes),
+		})
+	}
+
+	if len(violations) > 0 {
+		return &VoteRuleError{Violations: violations}
+	}
+	return nil
+}
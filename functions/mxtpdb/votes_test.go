@@ -0,0 +1,36 @@
+package mxtpdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckVotes(t *testing.T) {
+	items := ThemeItems{
+		Id: "2020-05-01",
+		Songs: []Song{
+			{UserId: "alice", SubmissionId: "a"},
+			{UserId: "bob", SubmissionId: "b"},
+			{UserId: "carl", SubmissionId: "c"},
+		},
+	}
+
+	require.Nil(t, League{}.CheckVotes(items, "alice", []string{"b", "c"}))
+	require.Nil(t, League{}.CheckVotes(items, "alice", nil))
+	require.Nil(t, League{AllowSelfVotes: true}.CheckVotes(items, "alice", []string{"a"}))
+
+	err := League{MaxVotes: 1}.CheckVotes(items, "alice", []string{"a", "b", "b", "x"})
+	ruleErr, ok := err.(*VoteRuleError)
+	require.True(t, ok)
+	require.Equal(t, []VoteViolation{
+		{Rule: RuleDuplicateVote, Message: "Submissions can only be voted for once: b"},
+		{Rule: RuleUnknownSubmission, Message: "Submissions aren't in theme 2020-05-01: x"},
+		{Rule: RuleSelfVote, Message: "You can't vote for your own song"},
+		{Rule: RuleMaxVotes, Message: "Vote for at most 1 songs"},
+	}, ruleErr.Violations)
+
+	err = League{MinVotes: 2}.CheckVotes(items, "alice", []string{"b", "b"})
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "Vote for at least 2 songs")
+}
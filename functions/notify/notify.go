@@ -0,0 +1,286 @@
+// Package notify sends league events to chat channels and webhooks. Each
+// league configures its own hooks, which are sent events through a Sink for
+// their kind.
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/macintoshpie/mxtp-fx/mxtpdb"
+)
+
+type EventType string
+
+const (
+	EventThemeOpened      EventType = "theme.opened"
+	EventSubmitClosing    EventType = "submit.closing"
+	EventVoteOpened       EventType = "vote.opened"
+	EventResultsPublished EventType = "results.published"
+)
+
+// EventTypes is every event a hook can subscribe to
+var EventTypes = []EventType{EventThemeOpened, EventSubmitClosing, EventVoteOpened, EventResultsPublished}
+
+// Hook kinds
+const (
+	KindSlack   = "slack"
+	KindDiscord = "discord"
+	KindWebhook = "webhook"
+)
+
+// Event is something that happened in a league
+type Event struct {
+	Type             EventType
+	League           string
+	ThemeId          string
+	ThemeName        string
+	ThemeDescription string `json:",omitempty"`
+	// Deadline is when the phase the event is about closes
+	Deadline time.Time `json:",omitempty"`
+	// PlaylistUrl is the theme's playlist, once it has one
+	PlaylistUrl string `json:",omitempty"`
+	// Winners are the display names of the theme's winners. Events go to
+	// third parties, so they never carry member emails.
+	Winners []string `json:",omitempty"`
+}
+
+// DisplayName returns how a user is shown in events: the local part of their
+// email, or the user id if it isn't one.
+func DisplayName(userId string) string {
+	if at := strings.LastIndex(userId, "@"); at > 0 {
+		return userId[:at]
+	}
+	return userId
+}
+
+// Text describes the event for people
+func (event Event) Text() string {
+	deadline := event.Deadline.UTC().Format("Mon Jan 2 15:04 MST")
+	var text string
+	switch event.Type {
+	case EventThemeOpened:
+		text = fmt.Sprintf("New %v theme: %v. Submit a song by %v.", event.League, event.ThemeName, deadline)
+		if event.ThemeDescription != "" {
+			text += "\n" + event.ThemeDescription
+		}
+	case EventSubmitClosing:
+		text = fmt.Sprintf("Last chance to submit a song for %v, submissions close %v.", event.ThemeName, deadline)
+	case EventVoteOpened:
+		text = fmt.Sprintf("Voting is open for %v until %v.", event.ThemeName, deadline)
+		if event.PlaylistUrl != "" {
+			text += " Listen: " + event.PlaylistUrl
+		}
+	case EventResultsPublished:
+		if len(event.Winners) == 0 {
+			text = fmt.Sprintf("Voting has closed for %v, but nobody won.", event.ThemeName)
+		} else {
+			text = fmt.Sprintf("Voting has closed for %v. Congratulations %v!", event.ThemeName, strings.Join(event.Winners, " and "))
+		}
+	default:
+		text = fmt.Sprintf("%v: %v", event.Type, event.ThemeName)
+	}
+	return text
+}
+
+// Sink sends events somewhere
+type Sink interface {
+	Send(event Event) error
+}
+
+// SlackSink posts events to a Slack incoming webhook
+type SlackSink struct {
+	WebhookUrl string
+	Client     *http.Client
+}
+
+func (s *SlackSink) Send(event Event) error {
+	return postJSON(s.Client, s.WebhookUrl, map[string]string{"text": event.Text()}, nil)
+}
+
+// DiscordSink posts events to a Discord channel webhook
+type DiscordSink struct {
+	WebhookUrl string
+	Client     *http.Client
+}
+
+func (s *DiscordSink) Send(event Event) error {
+	return postJSON(s.Client, s.WebhookUrl, map[string]string{"content": event.Text()}, nil)
+}
+
+// Webhook headers. The signature is "sha256=" followed by the hex HMAC-SHA256
+// of the timestamp, a '.' and the body, keyed with the hook's secret.
+const (
+	HeaderEvent     = "X-Mxtp-Event"
+	HeaderTimestamp = "X-Mxtp-Timestamp"
+	HeaderSignature = "X-Mxtp-Signature"
+)
+
+// WebhookSink posts events as JSON, signed with Secret so receivers can check
+// they came from us
+type WebhookSink struct {
+	Url    string
+	Secret []byte
+	Client *http.Client
+	// now is replaced in tests
+	now func() time.Time
+}
+
+func (s *WebhookSink) Send(event Event) error {
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	timestamp := strconv.FormatInt(now().Unix(), 10)
+
+	return postJSON(s.Client, s.Url, event, func(req *http.Request, body []byte) {
+		req.Header.Set(HeaderEvent, string(event.Type))
+		req.Header.Set(HeaderTimestamp, timestamp)
+		req.Header.Set(HeaderSignature, Sign(s.Secret, timestamp, body))
+	})
+}
+
+// Sign returns the signature of a webhook body sent at timestamp
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a webhook signature
+func Verify(secret []byte, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+func postJSON(client *http.Client, target string, content interface{}, prepare func(*http.Request, []byte)) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	body, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if prepare != nil {
+		prepare(req, body)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("Hook responded %v: %s", resp.StatusCode, message)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
+// ValidateHook checks the hook can be sent events
+func ValidateHook(hook mxtpdb.Hook) error {
+	switch hook.Kind {
+	case KindSlack, KindDiscord:
+	case KindWebhook:
+		if hook.Secret == "" {
+			return errors.New("Webhooks must have a secret")
+		}
+	default:
+		return fmt.Errorf("Hook kind must be one of %v, %v or %v", KindSlack, KindDiscord, KindWebhook)
+	}
+
+	parsed, err := url.Parse(hook.Url)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return errors.New("Hook url must be an http(s) url")
+	}
+
+	for _, eventType := range hook.Events {
+		if !knownEvent(EventType(eventType)) {
+			return fmt.Errorf("Unknown event %v", eventType)
+		}
+	}
+	return nil
+}
+
+func knownEvent(eventType EventType) bool {
+	for _, known := range EventTypes {
+		if eventType == known {
+			return true
+		}
+	}
+	return false
+}
+
+// NewSink returns the sink for the hook
+func NewSink(hook mxtpdb.Hook, client *http.Client) (Sink, error) {
+	if err := ValidateHook(hook); err != nil {
+		return nil, err
+	}
+
+	switch hook.Kind {
+	case KindSlack:
+		return &SlackSink{WebhookUrl: hook.Url, Client: client}, nil
+	case KindDiscord:
+		return &DiscordSink{WebhookUrl: hook.Url, Client: client}, nil
+	default:
+		return &WebhookSink{Url: hook.Url, Secret: []byte(hook.Secret), Client: client}, nil
+	}
+}
+
+// subscribed determines if the hook wants the event. Hooks without events
+// get every event.
+func subscribed(hook mxtpdb.Hook, eventType EventType) bool {
+	if len(hook.Events) == 0 {
+		return true
+	}
+	for _, subscribedType := range hook.Events {
+		if EventType(subscribedType) == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Notify sends the event to each hook subscribed to it. Every hook is tried,
+// and the number that failed is returned as an error.
+func Notify(hooks []mxtpdb.Hook, event Event, client *http.Client) error {
+	failed := 0
+	for _, hook := range hooks {
+		if !subscribed(hook, event.Type) {
+			continue
+		}
+
+		sink, err := NewSink(hook, client)
+		if err == nil {
+			err = sink.Send(event)
+		}
+		if err != nil {
+			fmt.Printf("ERROR: failed to send %v to hook %v: %v\n", event.Type, hook.Id, err.Error())
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("Failed to notify %v hooks", failed)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package notify
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/macintoshpie/mxtp-fx/mxtpdb"
+	"github.com/stretchr/testify/require"
+)
+
+// receiver records the requests sent to it
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []string
+	status   int
+}
+
+func newReceiver() *receiver {
+	r := &receiver{status: http.StatusOK}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests = append(r.requests, req)
+		r.bodies = append(r.bodies, string(body))
+		w.WriteHeader(r.status)
+	}))
+	return r
+}
+
+var testEvent = Event{
+	Type:        EventVoteOpened,
+	League:      "devetry",
+	ThemeId:     "2020-01-01",
+	ThemeName:   "covers",
+	Deadline:    time.Date(2020, 1, 29, 0, 0, 0, 0, time.UTC),
+	PlaylistUrl: "https://open.spotify.com/playlist/abc",
+}
+
+func TestChatSinks(t *testing.T) {
+	r := newReceiver()
+	defer r.Close()
+
+	require.Nil(t, (&SlackSink{WebhookUrl: r.URL}).Send(testEvent))
+	require.Nil(t, (&DiscordSink{WebhookUrl: r.URL}).Send(testEvent))
+
+	text := "Voting is open for covers until Wed Jan 29 00:00 UTC. Listen: https://open.spotify.com/playlist/abc"
+	var slack, discord map[string]string
+	require.Nil(t, json.Unmarshal([]byte(r.bodies[0]), &slack))
+	require.Nil(t, json.Unmarshal([]byte(r.bodies[1]), &discord))
+	require.Equal(t, map[string]string{"text": text}, slack)
+	require.Equal(t, map[string]string{"content": text}, discord)
+	require.Equal(t, "application/json", r.requests[0].Header.Get("Content-Type"))
+
+	r.status = http.StatusNotFound
+	require.NotNil(t, (&SlackSink{WebhookUrl: r.URL}).Send(testEvent))
+}
+
+func TestWebhookSink(t *testing.T) {
+	r := newReceiver()
+	defer r.Close()
+
+	sink := &WebhookSink{Url: r.URL, Secret: []byte("secret"), now: func() time.Time { return time.Unix(1577836800, 0) }}
+	require.Nil(t, sink.Send(testEvent))
+
+	req := r.requests[0]
+	require.Equal(t, "vote.opened", req.Header.Get(HeaderEvent))
+	require.Equal(t, "1577836800", req.Header.Get(HeaderTimestamp))
+	require.True(t, Verify([]byte("secret"), "1577836800", []byte(r.bodies[0]), req.Header.Get(HeaderSignature)))
+	require.False(t, Verify([]byte("wrong"), "1577836800", []byte(r.bodies[0]), req.Header.Get(HeaderSignature)))
+	require.False(t, Verify([]byte("secret"), "1577836801", []byte(r.bodies[0]), req.Header.Get(HeaderSignature)))
+
+	var event Event
+	require.Nil(t, json.Unmarshal([]byte(r.bodies[0]), &event))
+	require.Equal(t, testEvent, event)
+}
+
+func TestValidateHook(t *testing.T) {
+	require.Nil(t, ValidateHook(mxtpdb.Hook{Kind: KindSlack, Url: "https://hooks.slack.com/services/x"}))
+	require.Nil(t, ValidateHook(mxtpdb.Hook{Kind: KindWebhook, Url: "http://localhost:8080", Secret: "s", Events: []string{"vote.opened"}}))
+
+	require.NotNil(t, ValidateHook(mxtpdb.Hook{Kind: "email", Url: "https://example.com"}))
+	require.NotNil(t, ValidateHook(mxtpdb.Hook{Kind: KindDiscord, Url: "discord.com/api/webhooks"}))
+	require.NotNil(t, ValidateHook(mxtpdb.Hook{Kind: KindWebhook, Url: "https://example.com"}))
+	require.NotNil(t, ValidateHook(mxtpdb.Hook{Kind: KindSlack, Url: "https://example.com", Events: []string{"theme.deleted"}}))
+}
+
+func TestNotify(t *testing.T) {
+	r := newReceiver()
+	defer r.Close()
+
+	hooks := []mxtpdb.Hook{
+		{Id: "all", Kind: KindSlack, Url: r.URL},
+		{Id: "results", Kind: KindDiscord, Url: r.URL, Events: []string{string(EventResultsPublished)}},
+		{Id: "broken", Kind: KindSlack, Url: "not a url"},
+	}
+
+	// every subscribed hook is tried
+	require.NotNil(t, Notify(hooks, testEvent, nil))
+	require.Len(t, r.bodies, 1)
+
+	results := testEvent
+	results.Type = EventResultsPublished
+	results.Winners = []string{"alice", "bob"}
+	require.NotNil(t, Notify(hooks, results, nil))
+	require.Len(t, r.bodies, 3)
+	require.Contains(t, r.bodies[2], "Congratulations alice and bob!")
+
+	require.Nil(t, Notify(hooks[:2], testEvent, nil))
+}
+
+func TestDisplayName(t *testing.T) {
+	require.Equal(t, "alice", DisplayName("alice@devetry.com"))
+	require.Equal(t, "alice", DisplayName("alice"))
+}
@@ -0,0 +1,99 @@
+package scoring
+
+import "sort"
+
+// LeaderboardEntry is a user's standing across a league's themes.
+type LeaderboardEntry struct {
+	// Rank is shared by users with the same points and wins.
+	Rank   int
+	UserId string
+	// Points is the number of votes received across all themes.
+	Points int
+	// Wins is the number of themes where the user ranked first with at least
+	// one vote (ties count as wins for everyone tied).
+	Wins int
+	// Participation is the number of themes the user submitted a song to.
+	Participation int
+	// CurrentStreak is the number of consecutive themes, up to the most recent,
+	// the user submitted to. LongestStreak is the longest such run.
+	CurrentStreak int
+	LongestStreak int
+}
+
+type Leaderboard struct {
+	// Themes is the number of themes included.
+	Themes  int
+	Entries []LeaderboardEntry
+}
+
+// NewLeaderboard aggregates the results of themes, which must be ordered from
+// oldest to newest for streaks to be meaningful.
+func NewLeaderboard(results []Results) Leaderboard {
+	entries := make(map[string]*LeaderboardEntry)
+	for _, result := range results {
+		winners := make(map[string]bool)
+		for _, winner := range result.Winners() {
+			winners[winner.UserId] = true
+		}
+
+		submitted := make(map[string]bool)
+		for _, standing := range result.Standings {
+			entry, ok := entries[standing.UserId]
+			if !ok {
+				entry = &LeaderboardEntry{UserId: standing.UserId}
+				entries[standing.UserId] = entry
+			}
+			entry.Points += standing.Votes
+			if submitted[standing.UserId] {
+				continue
+			}
+			submitted[standing.UserId] = true
+			entry.Participation += 1
+			if winners[standing.UserId] {
+				entry.Wins += 1
+			}
+		}
+
+		for userId, entry := range entries {
+			if submitted[userId] {
+				entry.CurrentStreak += 1
+				if entry.CurrentStreak > entry.LongestStreak {
+					entry.LongestStreak = entry.CurrentStreak
+				}
+			} else {
+				entry.CurrentStreak = 0
+			}
+		}
+	}
+
+	sorted := make([]LeaderboardEntry, 0, len(entries))
+	for _, entry := range entries {
+		sorted = append(sorted, *entry)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Points != b.Points {
+			return a.Points > b.Points
+		}
+		if a.Wins != b.Wins {
+			return a.Wins > b.Wins
+		}
+		if a.Participation != b.Participation {
+			return a.Participation > b.Participation
+		}
+		return a.UserId < b.UserId
+	})
+	for idx := range sorted {
+		prev := idx - 1
+		if idx > 0 && sorted[idx].Points == sorted[prev].Points && sorted[idx].Wins == sorted[prev].Wins {
+			sorted[idx].Rank = sorted[prev].Rank
+		} else {
+			sorted[idx].Rank = idx + 1
+		}
+	}
+
+	return Leaderboard{
+		Themes:  len(results),
+		Entries: sorted,
+	}
+}
@@ -0,0 +1,65 @@
+package scoring
+
+import (
+	"testing"
+
+	"github.com/macintoshpie/mxtp-fx/mxtpdb"
+	"github.com/stretchr/testify/require"
+)
+
+func theme(songs map[string]string, votes map[string][]string) Results {
+	items := mxtpdb.ThemeItems{}
+	for userId, submissionId := range songs {
+		items.Songs = append(items.Songs, mxtpdb.Song{UserId: userId, SubmissionId: submissionId})
+	}
+	for userId, submissionIds := range votes {
+		items.Votes = append(items.Votes, mxtpdb.Votes{UserId: userId, SubmissionIds: submissionIds})
+	}
+	return Tally(items)
+}
+
+func TestLeaderboard(t *testing.T) {
+	leaderboard := NewLeaderboard([]Results{
+		theme(
+			map[string]string{"alice": "a1", "bob": "b1"},
+			map[string][]string{"alice": {"b1"}, "bob": {"a1"}, "carl": {"a1"}},
+		),
+		theme(
+			map[string]string{"alice": "a2", "carl": "c2"},
+			map[string][]string{"alice": {"c2"}, "carl": {"c2"}},
+		),
+		theme(
+			map[string]string{"bob": "b3", "carl": "c3"},
+			map[string][]string{"bob": {"c3"}},
+		),
+	})
+
+	require.Equal(t, 3, leaderboard.Themes)
+	require.Equal(t, []LeaderboardEntry{
+		{Rank: 1, UserId: "carl", Points: 3, Wins: 2, Participation: 2, CurrentStreak: 2, LongestStreak: 2},
+		{Rank: 2, UserId: "alice", Points: 2, Wins: 1, Participation: 2, CurrentStreak: 0, LongestStreak: 2},
+		{Rank: 3, UserId: "bob", Points: 1, Wins: 0, Participation: 2, CurrentStreak: 1, LongestStreak: 1},
+	}, leaderboard.Entries)
+}
+
+func TestLeaderboardTies(t *testing.T) {
+	leaderboard := NewLeaderboard([]Results{
+		theme(
+			map[string]string{"bob": "b1", "alice": "a1", "carl": "c1"},
+			map[string][]string{"carl": {"a1", "b1"}},
+		),
+	})
+
+	require.Equal(t, 1, leaderboard.Entries[0].Rank)
+	require.Equal(t, "alice", leaderboard.Entries[0].UserId)
+	require.Equal(t, 1, leaderboard.Entries[1].Rank)
+	require.Equal(t, "bob", leaderboard.Entries[1].UserId)
+	require.Equal(t, 3, leaderboard.Entries[2].Rank)
+	require.Equal(t, 0, leaderboard.Entries[2].Wins)
+}
+
+func TestLeaderboardEmpty(t *testing.T) {
+	leaderboard := NewLeaderboard(nil)
+	require.Equal(t, 0, leaderboard.Themes)
+	require.Empty(t, leaderboard.Entries)
+}
@@ -0,0 +1,106 @@
+// Package scoring tallies the votes cast for a theme's submissions.
+package scoring
+
+import (
+	"sort"
+
+	"github.com/macintoshpie/mxtp-fx/mxtpdb"
+)
+
+// Standing is a submission's place in a theme's results.
+type Standing struct {
+	// Rank is shared by submissions with the same number of votes, with the
+	// next rank skipping accordingly (1, 1, 3).
+	Rank         int
+	SubmissionId string
+	UserId       string
+	SongUrl      string
+	Name         string
+	Artists      []string
+	Votes        int
+}
+
+type Results struct {
+	ThemeId string
+	// Standings has every submission, most votes first. Ties are ordered by
+	// UserId then SubmissionId.
+	Standings []Standing
+	// Voters is the number of users whose votes were counted.
+	Voters int
+}
+
+// Tally counts one vote per submission listed in each user's votes. Duplicate
+// ids in a user's votes count once, and ids that don't match a submission are
+// ignored.
+func Tally(items mxtpdb.ThemeItems) Results {
+	standings := make([]Standing, 0, len(items.Songs))
+	bySubmission := make(map[string]int)
+	for _, song := range items.Songs {
+		if song.SubmissionId == "" {
+			continue
+		}
+		if _, ok := bySubmission[song.SubmissionId]; ok {
+			continue
+		}
+		bySubmission[song.SubmissionId] = len(standings)
+		standings = append(standings, Standing{
+			SubmissionId: song.SubmissionId,
+			UserId:       song.UserId,
+			SongUrl:      song.SongUrl,
+			Name:         song.Name,
+			Artists:      song.Artists,
+		})
+	}
+
+	voters := 0
+	for _, votes := range items.Votes {
+		counted := make(map[string]bool)
+		for _, submissionId := range votes.SubmissionIds {
+			idx, ok := bySubmission[submissionId]
+			if !ok || counted[submissionId] {
+				continue
+			}
+			counted[submissionId] = true
+			standings[idx].Votes += 1
+		}
+		if len(counted) > 0 {
+			voters += 1
+		}
+	}
+
+	sort.Slice(standings, func(i, j int) bool {
+		a, b := standings[i], standings[j]
+		if a.Votes != b.Votes {
+			return a.Votes > b.Votes
+		}
+		if a.UserId != b.UserId {
+			return a.UserId < b.UserId
+		}
+		return a.SubmissionId < b.SubmissionId
+	})
+	for idx := range standings {
+		if idx > 0 && standings[idx].Votes == standings[idx-1].Votes {
+			standings[idx].Rank = standings[idx-1].Rank
+		} else {
+			standings[idx].Rank = idx + 1
+		}
+	}
+
+	return Results{
+		ThemeId:   items.Id,
+		Standings: standings,
+		Voters:    voters,
+	}
+}
+
+// Winners returns the standings ranked first, or nil if nobody received a vote.
+func (r Results) Winners() []Standing {
+	var winners []Standing
+	for _, standing := range r.Standings {
+		if standing.Rank != 1 || standing.Votes == 0 {
+			break
+		}
+		winners = append(winners, standing)
+	}
+	return winners
+}
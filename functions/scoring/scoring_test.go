@@ -0,0 +1,69 @@
+package scoring
+
+import (
+	"testing"
+
+	"github.com/macintoshpie/mxtp-fx/mxtpdb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTally(t *testing.T) {
+	results := Tally(mxtpdb.ThemeItems{
+		Id: "2020-05-01",
+		Songs: []mxtpdb.Song{
+			{UserId: "alice", SubmissionId: "a"},
+			{UserId: "bob", SubmissionId: "b"},
+			{UserId: "carl", SubmissionId: "c"},
+			{UserId: "dana", SubmissionId: "d"},
+		},
+		Votes: []mxtpdb.Votes{
+			{UserId: "alice", SubmissionIds: []string{"b", "c", "b"}},
+			{UserId: "bob", SubmissionIds: []string{"c", "missing"}},
+			{UserId: "carl", SubmissionIds: []string{"a", "b"}},
+			{UserId: "dana", SubmissionIds: []string{}},
+			{UserId: "erin", SubmissionIds: []string{"missing"}},
+		},
+	})
+
+	require.Equal(t, "2020-05-01", results.ThemeId)
+	require.Equal(t, 3, results.Voters)
+
+	var summary [][]interface{}
+	for _, standing := range results.Standings {
+		summary = append(summary, []interface{}{standing.Rank, standing.UserId, standing.Votes})
+	}
+	require.Equal(t, [][]interface{}{
+		{1, "bob", 2},
+		{1, "carl", 2},
+		{3, "alice", 1},
+		{4, "dana", 0},
+	}, summary)
+
+	winners := results.Winners()
+	require.Len(t, winners, 2)
+	require.Equal(t, "b", winners[0].SubmissionId)
+	require.Equal(t, "c", winners[1].SubmissionId)
+}
+
+func TestTallyIsDeterministic(t *testing.T) {
+	items := mxtpdb.ThemeItems{
+		Songs: []mxtpdb.Song{
+			{UserId: "zed", SubmissionId: "z"},
+			{UserId: "amy", SubmissionId: "y"},
+			{UserId: "amy", SubmissionId: "x"},
+		},
+	}
+	first := Tally(items)
+	items.Songs[0], items.Songs[2] = items.Songs[2], items.Songs[0]
+	require.Equal(t, first, Tally(items))
+	require.Equal(t, "x", first.Standings[0].SubmissionId)
+	require.Equal(t, "y", first.Standings[1].SubmissionId)
+	require.Equal(t, "z", first.Standings[2].SubmissionId)
+	require.Nil(t, first.Winners())
+}
+
+func TestTallyEmpty(t *testing.T) {
+	results := Tally(mxtpdb.ThemeItems{})
+	require.Empty(t, results.Standings)
+	require.Equal(t, 0, results.Voters)
+}
@@ -1,19 +1,45 @@
 const baseUrl = location.host === 'www.mxtp.xyz' ? 'https://www.mxtp.xyz' : 'http://localhost:8000'
 const apiBaseUrl = `${baseUrl}/.netlify/functions/jockey`
 
-export async function getGame(leagueName, gameId, userId) {
-  return fetch(`${apiBaseUrl}/leagues/${leagueName}/games/${gameId}`, {
+const authHeaders = (user) => ({
+  'Content-Type': 'application/json',
+  'authorization': 'Bearer ' + user.token,
+})
+
+export async function requestLogin(email) {
+  return fetch(`${apiBaseUrl}/auth/login`, {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify({Email: email})
+  })
+    .then(response => response.ok ? response.json() : Promise.reject(response))
+    .catch(e => ({error: e, message: 'Failed to send login link'}))
+}
+
+export async function createSession(nonce) {
+  return fetch(`${apiBaseUrl}/auth/session`, {
+    method: 'POST',
     headers: {
       'Content-Type': 'application/json',
-      'authorization': 'Bearer ' + btoa(userId),
     },
+    body: JSON.stringify({Nonce: nonce})
+  })
+    .then(response => response.ok ? response.json() : Promise.reject(response))
+    .catch(e => ({error: e, message: 'Failed to log in'}))
+}
+
+export async function getGame(leagueName, gameId, user) {
+  return fetch(`${apiBaseUrl}/leagues/${leagueName}/games/${gameId}`, {
+    headers: authHeaders(user),
   })
     .then(response => response.json())
     .catch(e => ({error: e, message: 'Failed to fetch game'}))
 }
 
-export async function getCurrentGame(leagueName, userId) {
-  return getGame(leagueName, 'current', userId)
+export async function getCurrentGame(leagueName, user) {
+  return getGame(leagueName, 'current', user)
 }
 
 export async function getLeague(leagueName) {
@@ -28,12 +54,9 @@ export async function getThemeItems(leagueName, themeId) {
     .catch(e => ({error: e, message: 'Failed to fetch theme info'}))
 }
 
-export async function getThemeSongs(leagueName, themeId) {
+export async function getThemeSongs(user, leagueName, themeId) {
   return fetch(`${apiBaseUrl}/leagues/${leagueName}/themes/${themeId}/songs`, {
-    headers: {
-      'Content-Type': 'application/json',
-      'authorization': 'Bearer ' + btoa(user.username),
-    },
+    headers: authHeaders(user),
   })
     .then(response => response.json())
     .catch(e => ({error: e, message: 'Failed to fetch theme info'}))
@@ -45,10 +68,7 @@ export async function updateSubmission(user, leagueName, themeId, songUrl) {
   }
   return fetch(`${apiBaseUrl}/leagues/${leagueName}/themes/${themeId}/songs`, {
     method: 'POST',
-    headers: {
-      'Content-Type': 'application/json',
-      'authorization': 'Bearer ' + btoa(user.username),
-    },
+    headers: authHeaders(user),
     redirect: 'follow',
     body: JSON.stringify(data)
   })
@@ -60,10 +80,7 @@ export async function updateVotes(user, leagueName, themeId, submissionIds) {
   }
   return fetch(`${apiBaseUrl}/leagues/${leagueName}/themes/${themeId}/votes`, {
     method: 'POST',
-    headers: {
-      'Content-Type': 'application/json',
-      'authorization': 'Bearer ' + btoa(user.username),
-    },
+    headers: authHeaders(user),
     redirect: 'follow',
     body: JSON.stringify(data)
   })
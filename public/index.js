@@ -2,8 +2,12 @@ import {
   updateVotes,
   updateSubmission,
   getCurrentGame,
+  requestLogin,
+  createSession,
 } from './client.js'
 
+const SESSION_KEY = 'mxtp-session'
+
 const state = {
   user: {},
   League: {},
@@ -16,25 +20,61 @@ const state = {
     'warn-card-username': false,
     'warn-card-fetching': false,
     'warn-card-fetchfailed': false,
+    'warn-card-loginsent': false,
+    'warn-card-loginfailed': false,
   },
 }
 
 const
   WARN_FETCHING = 'fetching',
   WARN_USERNAME = 'username',
-  WARN_FETCH_FAILED = 'fetchfailed'
+  WARN_FETCH_FAILED = 'fetchfailed',
+  WARN_LOGIN_SENT = 'loginsent',
+  WARN_LOGIN_FAILED = 'loginfailed'
 
 window.onload = async () => {
   // setup the page
-  document.getElementById('who-submit').addEventListener('click', setUser)
+  document.getElementById('who-submit').addEventListener('click', sendLoginLink)
   document.getElementById('submit-theme-form-submit').addEventListener('click', sendSubmission)
   document.getElementById('vote-theme-form-submit').addEventListener('click', sendVotes)
+
+  await restoreSession()
+  if (state.user.token) {
+    document.getElementById('who-input').value = state.user.username
+    hideOrDisplay()
+    loadGameData()
+  }
+}
+
+// restoreSession exchanges a login link nonce for a session, or loads the
+// session saved by a previous visit
+const restoreSession = async () => {
+  const params = new URLSearchParams(location.search)
+  const nonce = params.get('login')
+  if (nonce) {
+    // don't leave the single use nonce in the address bar
+    history.replaceState(null, '', location.pathname)
+    const session = await createSession(nonce)
+    if ('error' in session) {
+      addWarning(WARN_LOGIN_FAILED)
+      return
+    }
+    localStorage.setItem(SESSION_KEY, JSON.stringify(session))
+  }
+
+  const saved = JSON.parse(localStorage.getItem(SESSION_KEY) || 'null')
+  if (saved && new Date(saved.ExpiresAt) > new Date()) {
+    state.user.username = saved.Username
+    state.user.token = saved.Token
+  } else {
+    localStorage.removeItem(SESSION_KEY)
+  }
 }
 
 const loadGameData = async () => {
   addWarning(WARN_FETCHING)
 
-  const response = await getCurrentGame("devetry", state.user.username);
+  const response = await getCurrentGame("devetry", state.user);
   if ('error' in response) {
     addWarning(WARN_FETCH_FAILED)
     removeWarning(WARN_FETCHING)
@@ -48,6 +88,7 @@ const loadGameData = async () => {
   state.VoteThemeSongs = response.VoteThemeItems.Songs
   state.VoteThemeVotes = response.VoteThemeItems.Votes[0]
   state.SpotifyAuthUrl = response.SpotifyAuthUrl
+  state.SpotifyReauthRequired = response.SpotifyReauthRequired === true
   
   removeWarning(WARN_FETCHING)
   renderCards()
@@ -76,6 +117,10 @@ const renderCards = () => {
   // render vote theme card
   document.getElementById('vote-theme-name').innerText = state.VoteTheme.Name
   document.getElementById('vote-theme-desc').innerText = state.VoteTheme.Description
+  // themes built before playlists were archived per theme use the embed's default
+  if (state.VoteTheme.SpotifyPlaylistId) {
+    document.getElementById('vote-theme-playlist').src = `https://open.spotify.com/embed/playlist/${state.VoteTheme.SpotifyPlaylistId}`
+  }
   const voteThemeForm = document.getElementById('vote-theme-form')
   // remove any preexisting form checkboxes (can happen if reset user name)
   Array.from(voteThemeForm.getElementsByClassName('vote-theme-form-item')).forEach(e => e.remove())
@@ -108,14 +153,19 @@ const renderCards = () => {
     const spotifyLink = document.getElementById('spotify-auth-link')
     spotifyLink.href = state.SpotifyAuthUrl
     spotifyLink.hidden = false
+    if (state.SpotifyReauthRequired) {
+      spotifyLink.innerText = '(admin) spotify access was revoked, login with spotify again'
+    }
+  } else {
+    document.getElementById('spotify-reauth-warning').hidden = !state.SpotifyReauthRequired
   }
 }
 
 const hideOrDisplay = () => {
   // warnings is true if at least one warning should be shown
   const warnings = Object.values(state.warnings).some(x => x)
-  document.getElementById('submit-card').hidden = state.user.username == undefined || warnings
-  document.getElementById('vote-card').hidden = state.user.username == undefined || warnings
+  document.getElementById('submit-card').hidden = state.user.token == undefined || warnings
+  document.getElementById('vote-card').hidden = state.user.token == undefined || warnings
 
   // handle warnings
   document.getElementById('warn-card').hidden = !warnings
@@ -125,24 +175,28 @@ const hideOrDisplay = () => {
 }
 
 const userRegex = /[a-z]+@devetry.com/
-const setUser = () => {
+const sendLoginLink = async () => {
   const whoInput = document.getElementById('who-input')
   const username = whoInput.value.toLowerCase()
   whoInput.value = username
-  if (userRegex.test(username)) {
-    if (username == state.user.username) {
-      return
-    }
-    state.user.username = username
-    removeWarning(WARN_USERNAME)
-  } else {
-    state.user.username = null
+  removeWarning(WARN_LOGIN_SENT)
+  removeWarning(WARN_LOGIN_FAILED)
+  if (!userRegex.test(username)) {
     addWarning(WARN_USERNAME)
+    return
   }
+  removeWarning(WARN_USERNAME)
 
+  // log out until the link is followed
+  localStorage.removeItem(SESSION_KEY)
+  state.user = {}
   hideOrDisplay()
-  if (state.user.username) {
-    loadGameData()
+
+  const response = await requestLogin(username)
+  if ('error' in response) {
+    addWarning(WARN_LOGIN_FAILED)
+  } else {
+    addWarning(WARN_LOGIN_SENT)
   }
 }
 
@@ -166,8 +220,8 @@ const buttonLoading = async (btn, res) => {
   return res
 }
 
-// TODO: allow youtube urls and do better matching
-const songRegex = /^(http|https):\/\/open.spotify.com\/track\/.+$/
+// the server checks the link is to a track on a supported service
+const songRegex = /^((http|https):\/\/|spotify:)\S+$/
 const sendSubmission = () => {
   let songUrl = document.getElementById('submit-theme-form-input').value
   const btn = document.getElementById('submit-theme-form-submit')
@@ -3,6 +3,9 @@
 #
 # For example:
 # curl http://localhost:8000/.netlify/functions/jockey/leagues/123
+#
+# To run without Docker, serve jockey over plain HTTP instead:
+# cd functions && go run ./jockey -http :8000 -store memory
 set -e
 
 make build